@@ -4,8 +4,10 @@ import (
         "EverythingSuckz/fsb/config"
         "EverythingSuckz/fsb/internal/bot"
         "EverythingSuckz/fsb/internal/cache"
+        "EverythingSuckz/fsb/internal/commands"
         "EverythingSuckz/fsb/internal/database"
         "EverythingSuckz/fsb/internal/routes"
+        "EverythingSuckz/fsb/internal/throttle"
         "EverythingSuckz/fsb/internal/types"
         "EverythingSuckz/fsb/internal/utils"
         "fmt"
@@ -47,13 +49,16 @@ func runApp(cmd *cobra.Command, args []string) {
         if err != nil {
                 log.Panic("Failed to start main bot", zap.Error(err))
         }
+        commands.Load(log, mainBot.Dispatcher)
         cache.InitCache(log)
+        throttle.InitThrottle(log)
         workers, err := bot.StartWorkers(log)
         if err != nil {
                 log.Panic("Failed to start workers", zap.Error(err))
                 return
         }
         workers.AddDefaultClient(mainBot, mainBot.Self)
+        bot.WatchTokenFile(log)
         bot.StartUserBot(log)
         mainLogger.Info("Server started", zap.Int("port", config.ValueOf.Port))
         mainLogger.Info("File Stream Bot", zap.String("version", versionString))
@@ -71,6 +76,12 @@ func getRouter(log *zap.Logger) *gin.Engine {
                 gin.SetMode(gin.ReleaseMode)
         }
         router := gin.Default()
+        // let handlers observe client disconnects through *gin.Context
+        router.ContextWithFallback = true
+        // only take the client IP from X-Forwarded-For when it's set by a trusted proxy
+        if err := router.SetTrustedProxies(config.ValueOf.TrustedProxies); err != nil {
+                log.Sugar().Errorf("Failed to set trusted proxies: %v", err)
+        }
         router.Use(gin.ErrorLogger())
         router.GET("/", func(ctx *gin.Context) {
                 ctx.JSON(http.StatusOK, types.RootResponse{
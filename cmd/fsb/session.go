@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"os"
 
 	"EverythingSuckz/fsb/pkg/qrlogin"
 
@@ -17,6 +18,7 @@ var sessionCmd = &cobra.Command{
 
 func init() {
 	sessionCmd.Flags().StringP("login-type", "T", "qr", "The login type to use. Can be either 'qr' or 'phone'")
+	sessionCmd.Flags().StringP("format", "F", qrlogin.FormatPyrogram, "The session format to generate. Can be 'pyrogram', 'telethon' or 'gotd'")
 	sessionCmd.Flags().Int32P("api-id", "I", 0, "The API ID to use for the session (required).")
 	sessionCmd.Flags().StringP("api-hash", "H", "", "The API hash to use for the session (required).")
 	sessionCmd.MarkFlagRequired("api-id")
@@ -27,15 +29,22 @@ func generateSession(cmd *cobra.Command, args []string) {
 	loginType, _ := cmd.Flags().GetString("login-type")
 	apiId, _ := cmd.Flags().GetInt32("api-id")
 	apiHash, _ := cmd.Flags().GetString("api-hash")
+	format, _ := cmd.Flags().GetString("format")
+	if format != qrlogin.FormatPyrogram && format != qrlogin.FormatTelethon && format != qrlogin.FormatGotd {
+		fmt.Println("Invalid format. Please use either 'pyrogram', 'telethon' or 'gotd'")
+		return
+	}
+	var err error
 	if loginType == "qr" {
-		qrlogin.GenerateQRSession(int(apiId), apiHash)
+		err = qrlogin.GenerateQRSession(int(apiId), apiHash, format)
 	} else if loginType == "phone" {
-		generatePhoneSession()
+		err = qrlogin.GeneratePhoneSession(int(apiId), apiHash, format)
 	} else {
 		fmt.Println("Invalid login type. Please use either 'qr' or 'phone'")
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
-}
-
-func generatePhoneSession() {
-	fmt.Println("Phone session is not implemented yet.")
 }
@@ -1,7 +1,11 @@
 package config
 
 import (
+        "bufio"
+        "crypto/sha256"
+        "encoding/hex"
         "errors"
+        "fmt"
         "io"
         "net"
         "net/http"
@@ -9,6 +13,7 @@ import (
         "path/filepath"
         "reflect"
         "regexp"
+        "slices"
         "strconv"
         "strings"
 
@@ -37,24 +42,108 @@ func (au *allowedUsers) Decode(value string) error {
         return nil
 }
 
+// bandwidthTiers maps tier names to bytes per second, 0 meaning unlimited
+type bandwidthTiers map[string]int64
+
+func (bt *bandwidthTiers) Decode(value string) error {
+        *bt = bandwidthTiers{}
+        if value == "" {
+                return nil
+        }
+        for _, tier := range strings.Split(value, ",") {
+                name, rate, ok := strings.Cut(strings.TrimSpace(tier), ":")
+                if !ok || name == "" {
+                        return fmt.Errorf("invalid bandwidth tier %q, expected name:bytes_per_second", tier)
+                }
+                bytesPerSecond, err := strconv.ParseInt(rate, 10, 64)
+                if err != nil || bytesPerSecond < 0 {
+                        return fmt.Errorf("invalid rate of bandwidth tier %q", name)
+                }
+                (*bt)[name] = bytesPerSecond
+        }
+        return nil
+}
+
 type config struct {
-        ApiID          int32        `envconfig:"API_ID" required:"true"`
-        ApiHash        string       `envconfig:"API_HASH" required:"true"`
-        BotToken       string       `envconfig:"BOT_TOKEN" required:"true"`
-        LogChannelID   int64        `envconfig:"LOG_CHANNEL" required:"true"`
-        Dev            bool         `envconfig:"DEV" default:"false"`
-        Port           int          `envconfig:"PORT" default:"8080"`
-        Host           string       `envconfig:"HOST" default:""`
-        HashLength     int          `envconfig:"HASH_LENGTH" default:"6"`
-        UseSessionFile bool         `envconfig:"USE_SESSION_FILE" default:"true"`
-        UserSession    string       `envconfig:"USER_SESSION"`
-        UsePublicIP    bool         `envconfig:"USE_PUBLIC_IP" default:"false"`
-        AllowedUsers   allowedUsers `envconfig:"ALLOWED_USERS"`
-        AdminUserID    int64        `envconfig:"ADMIN_USER_ID" required:"true"`
-        MultiTokens    []string
+        ApiID             int32          `envconfig:"API_ID" required:"true"`
+        ApiHash           string         `envconfig:"API_HASH" required:"true"`
+        BotToken          string         `envconfig:"BOT_TOKEN" required:"true"`
+        LogChannelID      int64          `envconfig:"LOG_CHANNEL" required:"true"`
+        Dev               bool           `envconfig:"DEV" default:"false"`
+        Port              int            `envconfig:"PORT" default:"8080"`
+        Host              string         `envconfig:"HOST" default:""`
+        HashLength        int            `envconfig:"HASH_LENGTH" default:"6"`
+        UseSessionFile    bool           `envconfig:"USE_SESSION_FILE" default:"true"`
+        UserSession       string         `envconfig:"USER_SESSION"`
+        UsePublicIP       bool           `envconfig:"USE_PUBLIC_IP" default:"false"`
+        AllowedUsers      allowedUsers   `envconfig:"ALLOWED_USERS"`
+        AdminUserID       int64          `envconfig:"ADMIN_USER_ID" required:"true"`
+        StreamConcurrency int            `envconfig:"STREAM_CONCURRENCY" default:"4"`
+        StripeWorkers     bool           `envconfig:"STRIPE_WORKERS" default:"false"`
+        WorkerPolicy      string         `envconfig:"WORKER_POLICY" default:"least-loaded"`
+        AdminAPIToken     string         `envconfig:"ADMIN_API_TOKEN"`
+        MultiTokenFile    string         `envconfig:"MULTI_TOKEN_TXT_FILE"`
+        LinkSecret        string         `envconfig:"LINK_SECRET"`
+        LegacyHashLinks   bool           `envconfig:"LEGACY_HASH_LINKS" default:"false"`
+        QuotaFilesPerHour int            `envconfig:"QUOTA_FILES_PER_HOUR"`
+        QuotaFilesPerDay  int            `envconfig:"QUOTA_FILES_PER_DAY"`
+        QuotaBytesPerDay  int64          `envconfig:"QUOTA_BYTES_PER_DAY"`
+        IPRateLimit       float64        `envconfig:"IP_RATE_LIMIT" default:"10"`
+        IPRateBurst       int            `envconfig:"IP_RATE_BURST" default:"30"`
+        IPMaxStreams      int            `envconfig:"IP_MAX_STREAMS" default:"8"`
+        LinkRateLimit     float64        `envconfig:"LINK_RATE_LIMIT"`
+        LinkRateBurst     int            `envconfig:"LINK_RATE_BURST" default:"60"`
+        LinkMaxStreams    int            `envconfig:"LINK_MAX_STREAMS"`
+        TrustedProxies    []string       `envconfig:"TRUSTED_PROXIES"`
+        BandwidthGlobal   int64          `envconfig:"BANDWIDTH_GLOBAL"`
+        BandwidthStream   int64          `envconfig:"BANDWIDTH_PER_STREAM"`
+        BandwidthTiers    bandwidthTiers `envconfig:"BANDWIDTH_TIERS"`
+        MultiTokens       []string
+        FileTokens        []string       `ignored:"true"`
 }
 
-var botTokenRegex = regexp.MustCompile(`MULTI\_TOKEN\d+=(.*)`)
+var botTokenRegex = regexp.MustCompile(`^MULTI\_TOKEN\d+=(.*)`)
+
+var tokenFormatRegex = regexp.MustCompile(`^\d+:[\w-]{30,}$`)
+
+// ReadTokenFile reads bot tokens from path, one per line. Blank lines and
+// anything after a '#' are ignored. Malformed and duplicate tokens are left
+// out and reported in problems along with their line number.
+func ReadTokenFile(path string) (tokens []string, problems []error, err error) {
+        file, err := os.Open(path)
+        if err != nil {
+                return nil, nil, err
+        }
+        defer file.Close()
+        seen := make(map[string]int)
+        scanner := bufio.NewScanner(file)
+        lineNumber := 0
+        for scanner.Scan() {
+                lineNumber++
+                line := scanner.Text()
+                if i := strings.Index(line, "#"); i != -1 {
+                        line = line[:i]
+                }
+                token := strings.TrimSpace(line)
+                if token == "" {
+                        continue
+                }
+                if !tokenFormatRegex.MatchString(token) {
+                        problems = append(problems, fmt.Errorf("%s:%d: malformed bot token", path, lineNumber))
+                        continue
+                }
+                if first, ok := seen[token]; ok {
+                        problems = append(problems, fmt.Errorf("%s:%d: duplicate of the token on line %d", path, lineNumber, first))
+                        continue
+                }
+                seen[token] = lineNumber
+                tokens = append(tokens, token)
+        }
+        if err := scanner.Err(); err != nil {
+                return nil, nil, err
+        }
+        return tokens, problems, nil
+}
 
 func (c *config) loadFromEnvFile(log *zap.Logger) {
         envPath := filepath.Clean("fsb.env")
@@ -82,10 +171,29 @@ func SetFlagsFromConfig(cmd *cobra.Command) {
         cmd.Flags().String("host", ValueOf.Host, "Server host that will be included in links")
         cmd.Flags().Int("hash-length", ValueOf.HashLength, "Hash length in links")
         cmd.Flags().Bool("use-session-file", ValueOf.UseSessionFile, "Use session files")
-        cmd.Flags().String("user-session", ValueOf.UserSession, "Pyrogram user session")
+        cmd.Flags().String("user-session", ValueOf.UserSession, "Pyrogram, Telethon or gotd user session")
         cmd.Flags().Bool("use-public-ip", ValueOf.UsePublicIP, "Use public IP instead of local IP")
         cmd.Flags().Int64("admin-user-id", ValueOf.AdminUserID, "Admin user ID for bot management")
-        cmd.Flags().String("multi-token-txt-file", "", "Multi token txt file (Not implemented)")
+        cmd.Flags().Int("stream-concurrency", ValueOf.StreamConcurrency, "Number of chunks fetched in parallel per stream")
+        cmd.Flags().Bool("stripe-workers", ValueOf.StripeWorkers, "Spread the chunks of each download across all worker bots")
+        cmd.Flags().String("worker-policy", ValueOf.WorkerPolicy, "Worker selection policy: round-robin, least-loaded or weighted")
+        cmd.Flags().String("admin-api-token", ValueOf.AdminAPIToken, "Bearer token for the admin HTTP endpoints")
+        cmd.Flags().String("link-secret", ValueOf.LinkSecret, "Secret used to sign stream links")
+        cmd.Flags().Bool("legacy-hash-links", ValueOf.LegacyHashLinks, "Accept links with the old unsigned hash, while migrating to signed links")
+        cmd.Flags().Int("quota-files-per-hour", ValueOf.QuotaFilesPerHour, "Links a user can generate per hour, 0 for unlimited")
+        cmd.Flags().Int("quota-files-per-day", ValueOf.QuotaFilesPerDay, "Links a user can generate per day, 0 for unlimited")
+        cmd.Flags().Int64("quota-bytes-per-day", ValueOf.QuotaBytesPerDay, "Total size of the files a user can generate links for per day, 0 for unlimited")
+        cmd.Flags().Float64("ip-rate-limit", ValueOf.IPRateLimit, "Requests per second allowed from one client IP, 0 for unlimited")
+        cmd.Flags().Int("ip-rate-burst", ValueOf.IPRateBurst, "Requests a client IP can make at once before being rate limited")
+        cmd.Flags().Int("ip-max-streams", ValueOf.IPMaxStreams, "Concurrent streams allowed from one client IP, 0 for unlimited")
+        cmd.Flags().Float64("link-rate-limit", ValueOf.LinkRateLimit, "Requests per second allowed for one link, 0 for unlimited")
+        cmd.Flags().Int("link-rate-burst", ValueOf.LinkRateBurst, "Requests a link can get at once before being rate limited")
+        cmd.Flags().Int("link-max-streams", ValueOf.LinkMaxStreams, "Concurrent streams allowed for one link, 0 for unlimited")
+        cmd.Flags().String("trusted-proxies", strings.Join(ValueOf.TrustedProxies, ","), "Comma separated IPs or CIDRs of the reverse proxies whose X-Forwarded-For header is trusted")
+        cmd.Flags().Int64("bandwidth-global", ValueOf.BandwidthGlobal, "Total bytes per second served to all clients, 0 for unlimited")
+        cmd.Flags().Int64("bandwidth-per-stream", ValueOf.BandwidthStream, "Bytes per second served to a single stream, 0 for unlimited")
+        cmd.Flags().String("bandwidth-tiers", "", "Comma separated name:bytes_per_second bandwidth tiers for the owners of the links")
+        cmd.Flags().String("multi-token-txt-file", ValueOf.MultiTokenFile, "File with one worker bot token per line, re-read on SIGHUP")
 }
 
 func (c *config) loadConfigFromArgs(log *zap.Logger, cmd *cobra.Command) {
@@ -133,10 +241,71 @@ func (c *config) loadConfigFromArgs(log *zap.Logger, cmd *cobra.Command) {
         if usePublicIP {
                 os.Setenv("USE_PUBLIC_IP", strconv.FormatBool(usePublicIP))
         }
+        streamConcurrency, _ := cmd.Flags().GetInt("stream-concurrency")
+        if streamConcurrency != 0 {
+                os.Setenv("STREAM_CONCURRENCY", strconv.Itoa(streamConcurrency))
+        }
+        if cmd.Flags().Changed("stripe-workers") {
+                stripeWorkers, _ := cmd.Flags().GetBool("stripe-workers")
+                os.Setenv("STRIPE_WORKERS", strconv.FormatBool(stripeWorkers))
+        }
+        workerPolicy, _ := cmd.Flags().GetString("worker-policy")
+        if workerPolicy != "" {
+                os.Setenv("WORKER_POLICY", workerPolicy)
+        }
+        adminAPIToken, _ := cmd.Flags().GetString("admin-api-token")
+        if adminAPIToken != "" {
+                os.Setenv("ADMIN_API_TOKEN", adminAPIToken)
+        }
+        linkSecret, _ := cmd.Flags().GetString("link-secret")
+        if linkSecret != "" {
+                os.Setenv("LINK_SECRET", linkSecret)
+        }
+        if cmd.Flags().Changed("legacy-hash-links") {
+                legacyHashLinks, _ := cmd.Flags().GetBool("legacy-hash-links")
+                os.Setenv("LEGACY_HASH_LINKS", strconv.FormatBool(legacyHashLinks))
+        }
+        quotaFilesPerHour, _ := cmd.Flags().GetInt("quota-files-per-hour")
+        if quotaFilesPerHour != 0 {
+                os.Setenv("QUOTA_FILES_PER_HOUR", strconv.Itoa(quotaFilesPerHour))
+        }
+        quotaFilesPerDay, _ := cmd.Flags().GetInt("quota-files-per-day")
+        if quotaFilesPerDay != 0 {
+                os.Setenv("QUOTA_FILES_PER_DAY", strconv.Itoa(quotaFilesPerDay))
+        }
+        quotaBytesPerDay, _ := cmd.Flags().GetInt64("quota-bytes-per-day")
+        if quotaBytesPerDay != 0 {
+                os.Setenv("QUOTA_BYTES_PER_DAY", strconv.FormatInt(quotaBytesPerDay, 10))
+        }
+        for _, name := range []string{"ip-rate-limit", "link-rate-limit"} {
+                if cmd.Flags().Changed(name) {
+                        value, _ := cmd.Flags().GetFloat64(name)
+                        os.Setenv(strings.ToUpper(strings.ReplaceAll(name, "-", "_")), strconv.FormatFloat(value, 'f', -1, 64))
+                }
+        }
+        for _, name := range []string{"ip-rate-burst", "ip-max-streams", "link-rate-burst", "link-max-streams"} {
+                if cmd.Flags().Changed(name) {
+                        value, _ := cmd.Flags().GetInt(name)
+                        os.Setenv(strings.ToUpper(strings.ReplaceAll(name, "-", "_")), strconv.Itoa(value))
+                }
+        }
+        trustedProxies, _ := cmd.Flags().GetString("trusted-proxies")
+        if trustedProxies != "" {
+                os.Setenv("TRUSTED_PROXIES", trustedProxies)
+        }
+        for _, name := range []string{"bandwidth-global", "bandwidth-per-stream"} {
+                if cmd.Flags().Changed(name) {
+                        value, _ := cmd.Flags().GetInt64(name)
+                        os.Setenv(strings.ToUpper(strings.ReplaceAll(name, "-", "_")), strconv.FormatInt(value, 10))
+                }
+        }
+        bandwidthTiers, _ := cmd.Flags().GetString("bandwidth-tiers")
+        if bandwidthTiers != "" {
+                os.Setenv("BANDWIDTH_TIERS", bandwidthTiers)
+        }
         multiTokens, _ := cmd.Flags().GetString("multi-token-txt-file")
         if multiTokens != "" {
                 os.Setenv("MULTI_TOKEN_TXT_FILE", multiTokens)
-                // TODO: Add support for importing tokens from a separate file
         }
 }
 
@@ -167,13 +336,62 @@ func (c *config) setupEnvVars(log *zap.Logger, cmd *cobra.Command) {
         }
         val := reflect.ValueOf(c).Elem()
         for _, env := range os.Environ() {
-                if strings.HasPrefix(env, "MULTI_TOKEN") {
-                        c.MultiTokens = append(c.MultiTokens, botTokenRegex.FindStringSubmatch(env)[1])
+                if match := botTokenRegex.FindStringSubmatch(env); match != nil {
+                        c.MultiTokens = append(c.MultiTokens, match[1])
                 }
         }
+        if c.MultiTokenFile != "" {
+                tokens, problems, err := ReadTokenFile(c.MultiTokenFile)
+                if err != nil {
+                        log.Fatal("Error while reading MULTI_TOKEN_TXT_FILE", zap.Error(err))
+                }
+                for _, problem := range problems {
+                        log.Error("Skipping token", zap.Error(problem))
+                }
+                c.FileTokens = c.withoutEnvTokens(log, tokens)
+                log.Sugar().Infof("Loaded %d tokens from %s", len(c.FileTokens), c.MultiTokenFile)
+        }
         val.FieldByName("MultiTokens").Set(reflect.ValueOf(c.MultiTokens))
 }
 
+// withoutEnvTokens drops the file tokens that are already set as MULTI_TOKEN.
+func (c *config) withoutEnvTokens(log *zap.Logger, tokens []string) []string {
+        result := make([]string, 0, len(tokens))
+        for _, token := range tokens {
+                if slices.Contains(c.MultiTokens, token) {
+                        log.Sugar().Warnf("Token for bot %s in %s is also set as MULTI_TOKEN, skipping", token[:strings.Index(token, ":")], c.MultiTokenFile)
+                        continue
+                }
+                result = append(result, token)
+        }
+        return result
+}
+
+// ReloadFileTokens reads MULTI_TOKEN_TXT_FILE again and returns the tokens that
+// were added to and removed from it since the last load.
+func ReloadFileTokens(log *zap.Logger) (added []string, removed []string, err error) {
+        tokens, problems, err := ReadTokenFile(ValueOf.MultiTokenFile)
+        if err != nil {
+                return nil, nil, err
+        }
+        for _, problem := range problems {
+                log.Error("Skipping token", zap.Error(problem))
+        }
+        tokens = ValueOf.withoutEnvTokens(log, tokens)
+        for _, token := range tokens {
+                if !slices.Contains(ValueOf.FileTokens, token) {
+                        added = append(added, token)
+                }
+        }
+        for _, token := range ValueOf.FileTokens {
+                if !slices.Contains(tokens, token) {
+                        removed = append(removed, token)
+                }
+        }
+        ValueOf.FileTokens = tokens
+        return added, removed, nil
+}
+
 func Load(log *zap.Logger, cmd *cobra.Command) {
         log = log.Named("Config")
         defer log.Info("Loaded config")
@@ -191,6 +409,55 @@ func Load(log *zap.Logger, cmd *cobra.Command) {
                 log.Sugar().Info("HASH_LENGTH can't be less than 5, defaulting to 6")
                 ValueOf.HashLength = 6
         }
+        if ValueOf.StreamConcurrency < 1 {
+                log.Sugar().Info("STREAM_CONCURRENCY can't be less than 1, defaulting to 4")
+                ValueOf.StreamConcurrency = 4
+        }
+        if ValueOf.StreamConcurrency > 16 {
+                log.Sugar().Info("STREAM_CONCURRENCY can't be more than 16, changing to 16")
+                ValueOf.StreamConcurrency = 16
+        }
+        if ValueOf.LinkSecret == "" {
+                log.Sugar().Info("LINK_SECRET not set, deriving it from BOT_TOKEN")
+                secret := sha256.Sum256([]byte("fsb-link-secret:" + ValueOf.BotToken))
+                ValueOf.LinkSecret = hex.EncodeToString(secret[:])
+        }
+        if ValueOf.QuotaFilesPerHour < 0 || ValueOf.QuotaFilesPerDay < 0 || ValueOf.QuotaBytesPerDay < 0 {
+                log.Sugar().Info("Negative quotas are treated as unlimited")
+                ValueOf.QuotaFilesPerHour = max(ValueOf.QuotaFilesPerHour, 0)
+                ValueOf.QuotaFilesPerDay = max(ValueOf.QuotaFilesPerDay, 0)
+                ValueOf.QuotaBytesPerDay = max(ValueOf.QuotaBytesPerDay, 0)
+        }
+        ValueOf.IPRateLimit = max(ValueOf.IPRateLimit, 0)
+        ValueOf.LinkRateLimit = max(ValueOf.LinkRateLimit, 0)
+        ValueOf.IPMaxStreams = max(ValueOf.IPMaxStreams, 0)
+        ValueOf.LinkMaxStreams = max(ValueOf.LinkMaxStreams, 0)
+        if ValueOf.IPRateBurst < 1 {
+                log.Sugar().Info("IP_RATE_BURST can't be less than 1, changing to 1")
+                ValueOf.IPRateBurst = 1
+        }
+        if ValueOf.LinkRateBurst < 1 {
+                log.Sugar().Info("LINK_RATE_BURST can't be less than 1, changing to 1")
+                ValueOf.LinkRateBurst = 1
+        }
+        ValueOf.BandwidthGlobal = max(ValueOf.BandwidthGlobal, 0)
+        ValueOf.BandwidthStream = max(ValueOf.BandwidthStream, 0)
+        trustedProxies := ValueOf.TrustedProxies[:0]
+        for _, proxy := range ValueOf.TrustedProxies {
+                proxy = strings.TrimSpace(proxy)
+                if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+                        log.Sugar().Warnf("Ignoring invalid TRUSTED_PROXIES entry %q", proxy)
+                        continue
+                }
+                trustedProxies = append(trustedProxies, proxy)
+        }
+        ValueOf.TrustedProxies = trustedProxies
+        switch ValueOf.WorkerPolicy {
+        case "round-robin", "least-loaded", "weighted":
+        default:
+                log.Sugar().Infof("Unknown WORKER_POLICY %q, defaulting to least-loaded", ValueOf.WorkerPolicy)
+                ValueOf.WorkerPolicy = "least-loaded"
+        }
 }
 
 func getIP(public bool) (string, error) {
@@ -2,7 +2,6 @@ package bot
 
 import (
 	"EverythingSuckz/fsb/config"
-	"EverythingSuckz/fsb/internal/commands"
 	"context"
 	"time"
 
@@ -11,10 +10,14 @@ import (
 	"github.com/celestix/gotgproto"
 	"github.com/celestix/gotgproto/sessionMaker"
 	"github.com/glebarez/sqlite"
+	"github.com/gotd/td/telegram"
 )
 
 var Bot *gotgproto.Client
 
+// defaultClientHealth tracks the main bot once it is added as a worker.
+var defaultClientHealth = newWorkerHealth()
+
 func StartClient(log *zap.Logger) (*gotgproto.Client, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
 	defer cancel()
@@ -32,6 +35,7 @@ func StartClient(log *zap.Logger) (*gotgproto.Client, error) {
 					sqlite.Open("fsb.session"),
 				),
 				DisableCopyright: true,
+				Middlewares:      []telegram.Middleware{defaultClientHealth},
 			},
 		)
 		resultChan <- struct {
@@ -47,7 +51,6 @@ func StartClient(log *zap.Logger) (*gotgproto.Client, error) {
 		if result.err != nil {
 			return nil, result.err
 		}
-		commands.Load(log, result.client.Dispatcher)
 		log.Info("Client started", zap.String("username", result.client.Self.Username))
 		Bot = result.client
 		return result.client, nil
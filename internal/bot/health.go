@@ -0,0 +1,117 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+const (
+	// weight of the newest sample in the error rate and latency averages
+	healthSmoothing = 0.2
+	// consecutive failed requests after which a worker is quarantined
+	quarantineThreshold = 5
+	quarantineDuration  = time.Minute
+)
+
+// workerHealth keeps rolling statistics about the requests made by a worker
+// client. It is installed as the innermost client middleware so it observes
+// every attempt, including the ones retried by the flood waiter.
+type workerHealth struct {
+	inFlight          atomic.Int64
+	mut               sync.Mutex
+	errorRate         float64
+	latency           time.Duration
+	consecutiveErrors int
+	floodUntil        time.Time
+	quarantinedUntil  time.Time
+}
+
+func newWorkerHealth() *workerHealth {
+	return &workerHealth{}
+}
+
+func (h *workerHealth) Handle(next tg.Invoker) telegram.InvokeFunc {
+	return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+		started := time.Now()
+		err := next.Invoke(ctx, input, output)
+		h.record(time.Since(started), err)
+		return err
+	}
+}
+
+func (h *workerHealth) record(elapsed time.Duration, err error) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		// the caller gave up, this says nothing about the worker
+		return
+	}
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	if h.latency == 0 {
+		h.latency = elapsed
+	} else {
+		h.latency += time.Duration(healthSmoothing * float64(elapsed-h.latency))
+	}
+	if err == nil {
+		h.errorRate -= healthSmoothing * h.errorRate
+		h.consecutiveErrors = 0
+		return
+	}
+	h.errorRate += healthSmoothing * (1 - h.errorRate)
+	if d, ok := tgerr.AsFloodWait(err); ok {
+		h.floodUntil = time.Now().Add(d)
+		return
+	}
+	h.consecutiveErrors++
+	if h.consecutiveErrors >= quarantineThreshold {
+		h.quarantinedUntil = time.Now().Add(quarantineDuration)
+		h.consecutiveErrors = 0
+	}
+}
+
+// available reports whether the worker is neither flood waited nor
+// quarantined at the given time.
+func (h *workerHealth) available(now time.Time) bool {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	return now.After(h.floodUntil) && now.After(h.quarantinedUntil)
+}
+
+// cost is the weight used by the load aware policies, lower is better.
+func (h *workerHealth) cost() float64 {
+	h.mut.Lock()
+	latency, errorRate := h.latency, h.errorRate
+	h.mut.Unlock()
+	if latency <= 0 {
+		latency = 100 * time.Millisecond
+	}
+	return float64(1+h.inFlight.Load()) * latency.Seconds() * (1 + 4*errorRate)
+}
+
+// WorkerStats is a point in time view of a worker's health.
+type WorkerStats struct {
+	InFlight         int64
+	ErrorRate        float64
+	Latency          time.Duration
+	FloodUntil       time.Time
+	QuarantinedUntil time.Time
+}
+
+func (h *workerHealth) stats() WorkerStats {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	return WorkerStats{
+		InFlight:         h.inFlight.Load(),
+		ErrorRate:        h.errorRate,
+		Latency:          h.latency,
+		FloodUntil:       h.floodUntil,
+		QuarantinedUntil: h.quarantinedUntil,
+	}
+}
@@ -0,0 +1,110 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/database"
+	"EverythingSuckz/fsb/internal/utils"
+)
+
+// QuotaLimits are the quotas that apply to a user, 0 means unlimited.
+type QuotaLimits struct {
+	FilesPerHour int
+	FilesPerDay  int
+	BytesPerDay  int64
+}
+
+// UserQuotaLimits returns the default quotas with the overrides of the user applied.
+func UserQuotaLimits(userID int64) (QuotaLimits, error) {
+	limits := QuotaLimits{
+		FilesPerHour: config.ValueOf.QuotaFilesPerHour,
+		FilesPerDay:  config.ValueOf.QuotaFilesPerDay,
+		BytesPerDay:  config.ValueOf.QuotaBytesPerDay,
+	}
+	override, err := database.DB.GetUserQuota(userID)
+	if err != nil || override == nil {
+		return limits, err
+	}
+	if override.FilesPerHour != nil {
+		limits.FilesPerHour = *override.FilesPerHour
+	}
+	if override.FilesPerDay != nil {
+		limits.FilesPerDay = *override.FilesPerDay
+	}
+	if override.BytesPerDay != nil {
+		limits.BytesPerDay = *override.BytesPerDay
+	}
+	return limits, nil
+}
+
+// CheckQuota tells whether userID may generate a link for a file of fileSize
+// bytes. If not, it returns why and when the user can try again; the time is
+// zero if the file is too large to ever fit in the quota. Quotas are rolling
+// windows over the last hour and the last 24 hours.
+func CheckQuota(userID int64, fileSize int64) (string, time.Time, error) {
+	if userID == config.ValueOf.AdminUserID {
+		return "", time.Time{}, nil
+	}
+	limits, err := UserQuotaLimits(userID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if limits == (QuotaLimits{}) {
+		return "", time.Time{}, nil
+	}
+	now := time.Now()
+	day, err := database.DB.GetLinkGenerations(userID, now.Add(-24*time.Hour))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	var hour []database.LinkGeneration
+	for i, generation := range day {
+		if generation.CreatedAt.After(now.Add(-time.Hour)) {
+			hour = day[i:]
+			break
+		}
+	}
+
+	var reasons []string
+	var resetAt time.Time
+	// the quota frees up once enough of the oldest generations left the window
+	wait := func(until time.Time) {
+		if until.After(resetAt) {
+			resetAt = until
+		}
+	}
+	if limits.FilesPerHour > 0 && len(hour) >= limits.FilesPerHour {
+		reasons = append(reasons, fmt.Sprintf("%d links per hour", limits.FilesPerHour))
+		wait(hour[len(hour)-limits.FilesPerHour].CreatedAt.Add(time.Hour))
+	}
+	if limits.FilesPerDay > 0 && len(day) >= limits.FilesPerDay {
+		reasons = append(reasons, fmt.Sprintf("%d links per day", limits.FilesPerDay))
+		wait(day[len(day)-limits.FilesPerDay].CreatedAt.Add(24 * time.Hour))
+	}
+	if limits.BytesPerDay > 0 {
+		if fileSize > limits.BytesPerDay {
+			return fmt.Sprintf("this file is larger than your daily quota of %s", utils.SizeFormat(limits.BytesPerDay)), time.Time{}, nil
+		}
+		var used int64
+		for _, generation := range day {
+			used += generation.FileSize
+		}
+		if used+fileSize > limits.BytesPerDay {
+			reasons = append(reasons, fmt.Sprintf("%s per day", utils.SizeFormat(limits.BytesPerDay)))
+			for _, generation := range day {
+				used -= generation.FileSize
+				if used+fileSize <= limits.BytesPerDay {
+					wait(generation.CreatedAt.Add(24 * time.Hour))
+					break
+				}
+			}
+		}
+	}
+	if len(reasons) == 0 {
+		return "", time.Time{}, nil
+	}
+	return "you've reached your quota of " + strings.Join(reasons, " and "), resetAt, nil
+}
@@ -0,0 +1,46 @@
+package bot
+
+import (
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/database"
+	"EverythingSuckz/fsb/internal/utils"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gotd/td/tg"
+)
+
+// RevokeFile kills every link to the log channel message messageID. The
+// revocation is stored first so that links stop working even if deleting the
+// message from the channel fails afterwards.
+func RevokeFile(ctx context.Context, messageID int, revokedBy int64) error {
+	var fileName string
+	file, err := database.DB.GetFileByMessageID(messageID)
+	if err == nil {
+		fileName = file.FileName
+	} else if !errors.Is(err, database.ErrFileNotFound) {
+		return err
+	}
+	if err := database.DB.AddRevocation(messageID, fileName, revokedBy); err != nil {
+		return err
+	}
+	for _, worker := range Workers.List() {
+		cache.GetCache().Delete(utils.FileCacheKey(messageID, worker.Self.ID))
+	}
+	if err := database.DB.DeleteFile(messageID); err != nil {
+		return err
+	}
+	channel, err := utils.GetLogChannelPeer(ctx, Bot.API(), Bot.PeerStorage)
+	if err != nil {
+		return fmt.Errorf("links revoked but the log channel message was not deleted: %w", err)
+	}
+	_, err = Bot.API().ChannelsDeleteMessages(ctx, &tg.ChannelsDeleteMessagesRequest{
+		Channel: channel,
+		ID:      []int{messageID},
+	})
+	if err != nil {
+		return fmt.Errorf("links revoked but the log channel message was not deleted: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package bot
+
+import (
+	"EverythingSuckz/fsb/config"
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+// WatchTokenFile re-reads MULTI_TOKEN_TXT_FILE whenever the process receives
+// SIGHUP and reconciles the running workers with it.
+func WatchTokenFile(log *zap.Logger) {
+	path := config.ValueOf.MultiTokenFile
+	if path == "" {
+		return
+	}
+	log = log.Named("TokenFile")
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	go func() {
+		for range sig {
+			log.Sugar().Infof("Received SIGHUP, reloading %s", path)
+			reloadTokenFile(log)
+		}
+	}()
+	log.Sugar().Infof("Watching %s, send SIGHUP to reload", path)
+}
+
+func reloadTokenFile(log *zap.Logger) {
+	added, removed, err := config.ReloadFileTokens(log)
+	if err != nil {
+		log.Error("Failed to read token file, keeping current workers", zap.Error(err))
+		return
+	}
+	var stopped int32
+	for _, token := range removed {
+		if _, err := Workers.RemoveToken(token); err == nil {
+			stopped++
+		}
+	}
+	// also retry tokens that failed to start on a previous load
+	var wg sync.WaitGroup
+	var started int32
+	for _, token := range config.ValueOf.FileTokens {
+		if Workers.hasToken(token) {
+			continue
+		}
+		wg.Add(1)
+		go func(token string) {
+			defer wg.Done()
+			if _, err := Workers.Add(context.Background(), token); err != nil && !errors.Is(err, ErrWorkerNotAdmin) {
+				log.Error("Failed to start worker", zap.Error(err))
+				return
+			}
+			atomic.AddInt32(&started, 1)
+		}(token)
+	}
+	wg.Wait()
+	log.Sugar().Infof("Reconciled workers: %d new tokens, %d started, %d stopped, %d running", len(added), started, stopped, len(Workers.List()))
+}
@@ -0,0 +1,222 @@
+package bot
+
+import (
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/gotd/td/tg"
+)
+
+const (
+	// uploadPartSize is the largest part size Telegram accepts.
+	uploadPartSize = 512 * 1024
+	// bigFileSize is the size from which files are uploaded as big files,
+	// whose parts Telegram keeps until the upload is finished.
+	bigFileSize = 10 * 1024 * 1024
+	// MaxUploadSize is the largest file a bot can upload.
+	MaxUploadSize = 4000 * uploadPartSize
+)
+
+var (
+	ErrUploadSize   = fmt.Errorf("file size must be between 1 byte and %s", utils.SizeFormat(MaxUploadSize))
+	ErrUploadOffset = errors.New("offset doesn't match the bytes received so far")
+	ErrUploadBusy   = errors.New("the upload is being sent by another request")
+)
+
+// Upload is a file being uploaded to Telegram through the bot. It can be fed
+// in several calls to ReadFrom, so that an interrupted upload can go on
+// where it stopped.
+type Upload struct {
+	Size       int64
+	Name       string
+	MimeType   string // sniffed from the start of the file if empty
+	Attributes []tg.DocumentAttributeClass
+
+	mu      sync.Mutex
+	id      int64
+	part    int
+	pending []byte // received bytes of part, not uploaded yet
+	head    []byte // start of the file, to sniff the MIME type
+	writing bool   // a ReadFrom call is feeding the upload
+}
+
+// NewUpload starts the upload of a file of the given size.
+func NewUpload(size int64, name string, mimeType string) (*Upload, error) {
+	if size <= 0 || size > MaxUploadSize {
+		return nil, ErrUploadSize
+	}
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	return &Upload{
+		Size:     size,
+		Name:     name,
+		MimeType: mimeType,
+		id:       int64(binary.LittleEndian.Uint64(id[:])),
+	}, nil
+}
+
+// Offset returns how many bytes of the file were received.
+func (u *Upload) Offset() int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.offset()
+}
+
+func (u *Upload) offset() int64 {
+	return int64(u.part)*uploadPartSize + int64(len(u.pending))
+}
+
+func (u *Upload) parts() int {
+	return int((u.Size + uploadPartSize - 1) / uploadPartSize)
+}
+
+func (u *Upload) big() bool {
+	return u.Size > bigFileSize
+}
+
+// ReadFrom reads the file from r, which must start at offset, and uploads
+// every part it completes. It returns when r ends or the file is complete.
+// Bytes read are kept even if uploading their part fails, so the offset to
+// go on from is always Offset. Only one call can feed the upload at a time,
+// others get ErrUploadBusy.
+func (u *Upload) ReadFrom(ctx context.Context, offset int64, r io.Reader) error {
+	u.mu.Lock()
+	if u.writing {
+		u.mu.Unlock()
+		return ErrUploadBusy
+	}
+	if offset != u.offset() {
+		u.mu.Unlock()
+		return ErrUploadOffset
+	}
+	u.writing = true
+	u.mu.Unlock()
+	defer func() {
+		u.mu.Lock()
+		u.writing = false
+		u.mu.Unlock()
+	}()
+
+	// part and pending only change in this call now, so they are read
+	// without the lock and only changed under it
+	for u.part < u.parts() {
+		want := int(min(uploadPartSize, u.Size-int64(u.part)*uploadPartSize))
+		if len(u.pending) == want {
+			if err := u.savePart(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+		pending := u.pending
+		if pending == nil {
+			pending = make([]byte, 0, uploadPartSize)
+		}
+		n, err := io.ReadFull(r, pending[len(pending):want])
+		u.mu.Lock()
+		u.pending = pending[:len(pending)+n]
+		u.mu.Unlock()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *Upload) savePart(ctx context.Context) error {
+	var ok bool
+	var err error
+	if u.big() {
+		ok, err = Bot.API().UploadSaveBigFilePart(ctx, &tg.UploadSaveBigFilePartRequest{
+			FileID:         u.id,
+			FilePart:       u.part,
+			FileTotalParts: u.parts(),
+			Bytes:          u.pending,
+		})
+	} else {
+		ok, err = Bot.API().UploadSaveFilePart(ctx, &tg.UploadSaveFilePartRequest{
+			FileID:   u.id,
+			FilePart: u.part,
+			Bytes:    u.pending,
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload file part %d: %w", u.part, err)
+	}
+	if !ok {
+		return fmt.Errorf("upload failed for part %d", u.part)
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.part == 0 {
+		u.head = append([]byte(nil), u.pending[:min(len(u.pending), 512)]...)
+	}
+	u.part++
+	u.pending = u.pending[:0]
+	if u.part == u.parts() {
+		u.pending = nil
+	}
+	return nil
+}
+
+// Done reports whether the whole file was uploaded.
+func (u *Upload) Done() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.part == u.parts()
+}
+
+// Send posts the uploaded file to the log channel as a document and returns
+// the ID and file of the new message.
+func (u *Upload) Send(ctx context.Context) (int, *types.File, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.part != u.parts() {
+		return 0, nil, errors.New("the upload isn't complete")
+	}
+	var file tg.InputFileClass = &tg.InputFile{ID: u.id, Parts: u.part, Name: u.Name}
+	if u.big() {
+		file = &tg.InputFileBig{ID: u.id, Parts: u.part, Name: u.Name}
+	}
+	mimeType := u.MimeType
+	if mimeType == "" {
+		mimeType = http.DetectContentType(u.head)
+	}
+	attributes := append([]tg.DocumentAttributeClass{
+		&tg.DocumentAttributeFilename{FileName: u.Name},
+	}, u.Attributes...)
+
+	channel, err := utils.GetLogChannelPeer(ctx, Bot.API(), Bot.PeerStorage)
+	if err != nil {
+		return 0, nil, err
+	}
+	var randomID [8]byte
+	if _, err := rand.Read(randomID[:]); err != nil {
+		return 0, nil, err
+	}
+	updates, err := Bot.API().MessagesSendMedia(ctx, &tg.MessagesSendMediaRequest{
+		Peer: &tg.InputPeerChannel{ChannelID: channel.ChannelID, AccessHash: channel.AccessHash},
+		Media: &tg.InputMediaUploadedDocument{
+			File:       file,
+			MimeType:   mimeType,
+			Attributes: attributes,
+		},
+		RandomID: int64(binary.LittleEndian.Uint64(randomID[:])),
+	})
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to send document: %w", err)
+	}
+	return utils.FileFromUpdates(updates)
+}
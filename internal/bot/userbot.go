@@ -2,10 +2,17 @@ package bot
 
 import (
 	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/utils"
+	"EverythingSuckz/fsb/pkg/qrlogin"
 	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/celestix/gotgproto"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/functions"
 	"github.com/celestix/gotgproto/sessionMaker"
+	"github.com/celestix/gotgproto/storage"
 	"github.com/gotd/td/tg"
 	"go.uber.org/zap"
 )
@@ -24,12 +31,18 @@ func StartUserBot(l *zap.Logger) {
 		return
 	}
 	log.Sugar().Infoln("Starting userbot")
+	sessionType, format, err := userSession(config.ValueOf.UserSession)
+	if err != nil {
+		log.Error("Invalid user session", zap.String("format", format), zap.Error(err))
+		return
+	}
+	log.Sugar().Infof("Using %s user session", format)
 	client, err := gotgproto.NewClient(
 		int(config.ValueOf.ApiID),
 		config.ValueOf.ApiHash,
 		gotgproto.ClientTypePhone(""),
 		&gotgproto.ClientOpts{
-			Session:          sessionMaker.PyrogramSession(config.ValueOf.UserSession),
+			Session:          sessionType,
 			DisableCopyright: true,
 		},
 	)
@@ -46,27 +59,99 @@ func StartUserBot(l *zap.Logger) {
 	}
 }
 
+// userSession returns the session constructor for a pyrogram, telethon or gotd
+// session string along with the detected format.
+func userSession(value string) (sessionMaker.SessionConstructor, string, error) {
+	value = strings.TrimSpace(value)
+	format := qrlogin.DetectSessionFormat(value)
+	switch format {
+	case qrlogin.FormatTelethon:
+		return sessionMaker.TelethonSession(value), format, nil
+	case qrlogin.FormatGotd:
+		if _, err := qrlogin.DecodeGotdSession(value); err != nil {
+			return nil, format, err
+		}
+		encoded, err := functions.EncodeSessionToString(&storage.Session{
+			Version: storage.LatestVersion,
+			Data:    []byte(value),
+		})
+		if err != nil {
+			return nil, format, err
+		}
+		return sessionMaker.StringSession(encoded), format, nil
+	default:
+		if _, err := qrlogin.DecodePyrogramSession(value); err != nil {
+			return nil, format, err
+		}
+		return sessionMaker.PyrogramSession(value), format, nil
+	}
+}
+
 func (u *UserBotStruct) AddBotsAsAdmins() error {
 	u.log.Info("Preparing to add bots as admins")
 	ctx := u.client.CreateContext()
-	channel := config.ValueOf.LogChannelID
+	inputChannel, currentAdmins, err := u.logChannelAdmins(ctx)
+	if err != nil {
+		return err
+	}
+	for _, bot := range Workers.List() {
+		if utils.Contains(currentAdmins, bot.Self.ID) {
+			u.log.Sugar().Infof("Bot @%s is already an admin", bot.Self.Username)
+			continue
+		}
+		if err := u.addAdmin(ctx, inputChannel, bot); err != nil {
+			u.log.Sugar().Warnf("Failed to add @%s as admin", bot.Self.Username)
+			u.log.Warn(err.Error())
+			continue
+		}
+		u.log.Sugar().Infof("Added @%s as admin", bot.Self.Username)
+	}
+	return nil
+}
+
+// AddBotAsAdmin makes a worker started at runtime an admin of LOG_CHANNEL,
+// unless it already is one. It does nothing if the user bot isn't running.
+func (u *UserBotStruct) AddBotAsAdmin(bot *Worker) error {
+	if u.client == nil {
+		return nil
+	}
+	ctx := u.client.CreateContext()
+	inputChannel, currentAdmins, err := u.logChannelAdmins(ctx)
+	if err != nil {
+		return err
+	}
+	if utils.Contains(currentAdmins, bot.Self.ID) {
+		return nil
+	}
+	if err := u.addAdmin(ctx, inputChannel, bot); err != nil {
+		return err
+	}
+	u.log.Sugar().Infof("Added @%s as admin", bot.Self.Username)
+	return nil
+}
+
+// logChannelAdmins returns LOG_CHANNEL and the user IDs of its admins.
+func (u *UserBotStruct) logChannelAdmins(ctx *ext.Context) (*tg.InputChannel, []int64, error) {
 	channelInfos, err := u.client.API().ChannelsGetChannels(
 		ctx,
 		[]tg.InputChannelClass{
 			&tg.InputChannel{
-				ChannelID: channel,
+				ChannelID: config.ValueOf.LogChannelID,
 			},
 		},
 	)
 	if err != nil {
 		u.log.Error("Failed to get channel info", zap.Error(err))
-		return errors.New("failed to get channel info")
+		return nil, nil, errors.New("failed to get channel info")
 	}
 	if len(channelInfos.GetChats()) == 0 {
-		return errors.New("no channels found")
+		return nil, nil, errors.New("no channels found")
 	}
-	inputChannel := channelInfos.GetChats()[0].(*tg.Channel).AsInput()
-	currentAdmins := []int64{}
+	channel, ok := channelInfos.GetChats()[0].(*tg.Channel)
+	if !ok {
+		return nil, nil, errors.New("type assertion to *tg.Channel failed")
+	}
+	inputChannel := channel.AsInput()
 	admins, err := u.client.API().ChannelsGetParticipants(ctx, &tg.ChannelsGetParticipantsRequest{
 		Channel: inputChannel,
 		Filter:  &tg.ChannelParticipantsAdmins{},
@@ -75,45 +160,40 @@ func (u *UserBotStruct) AddBotsAsAdmins() error {
 	})
 	if err != nil {
 		u.log.Error("Failed to get admins", zap.Error(err))
-		return err
+		return nil, nil, err
 	}
-	for _, admin := range admins.(*tg.ChannelsChannelParticipants).Participants {
-		if user, ok := admin.(*tg.ChannelParticipantAdmin); ok {
-			currentAdmins = append(currentAdmins, user.UserID)
-		}
+	participants, ok := admins.(*tg.ChannelsChannelParticipants)
+	if !ok {
+		return nil, nil, fmt.Errorf("unexpected type %T", admins)
 	}
-	for _, bot := range Workers.Bots {
-		isAdmin := false
-		for _, admin := range currentAdmins {
-			if admin == bot.Self.ID {
-				u.log.Sugar().Infof("Bot @%s is already an admin", bot.Self.Username)
-				isAdmin = true
-				continue
-			}
-		}
-		if isAdmin {
-			continue
-		}
-		botInfo, err := ctx.ResolveUsername(bot.Self.Username)
-		if err != nil {
-			u.log.Warn(err.Error())
-		}
-		_, err = u.client.API().ChannelsEditAdmin(
-			u.client.CreateContext().Context,
-			&tg.ChannelsEditAdminRequest{
-				Channel: inputChannel,
-				UserID:  botInfo.GetInputUser(),
-				AdminRights: tg.ChatAdminRights{
-					PostMessages: true,
-				},
-				Rank: "admin",
-			},
-		)
-		if err != nil {
-			u.log.Sugar().Warnf("Failed to add @%s as admin", bot.Self.Username)
-			u.log.Warn(err.Error())
+	currentAdmins := []int64{}
+	for _, admin := range participants.Participants {
+		switch admin := admin.(type) {
+		case *tg.ChannelParticipantAdmin:
+			currentAdmins = append(currentAdmins, admin.UserID)
+		case *tg.ChannelParticipantCreator:
+			currentAdmins = append(currentAdmins, admin.UserID)
 		}
-		u.log.Sugar().Infof("Added @%s as admin", bot.Self.Username)
 	}
-	return nil
+	return inputChannel, currentAdmins, nil
+}
+
+// addAdmin makes bot an admin of channel that can post messages.
+func (u *UserBotStruct) addAdmin(ctx *ext.Context, channel *tg.InputChannel, bot *Worker) error {
+	botInfo, err := ctx.ResolveUsername(bot.Self.Username)
+	if err != nil {
+		return err
+	}
+	_, err = u.client.API().ChannelsEditAdmin(
+		ctx,
+		&tg.ChannelsEditAdminRequest{
+			Channel: channel,
+			UserID:  botInfo.GetInputUser(),
+			AdminRights: tg.ChatAdminRights{
+				PostMessages: true,
+			},
+			Rank: "admin",
+		},
+	)
+	return err
 }
@@ -2,8 +2,11 @@ package bot
 
 import (
 	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/types"
 	"context"
+	"errors"
 	"fmt"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"sync"
@@ -22,12 +25,55 @@ type Worker struct {
 	Client *gotgproto.Client
 	Self   *tg.User
 	log    *zap.Logger
+	health *workerHealth
+	token  string
 }
 
 func (w *Worker) String() string {
 	return fmt.Sprintf("{Worker (%d|@%s)}", w.ID, w.Self.Username)
 }
 
+// StartStream marks the beginning of a stream served by the worker. Every
+// call must be paired with EndStream.
+func (w *Worker) StartStream() {
+	w.health.inFlight.Add(1)
+}
+
+func (w *Worker) EndStream() {
+	w.health.inFlight.Add(-1)
+}
+
+// Stats returns the current health statistics of the worker.
+func (w *Worker) Stats() WorkerStats {
+	return w.health.stats()
+}
+
+// Info describes the worker and its current health.
+func (w *Worker) Info() types.WorkerInfo {
+	stats := w.Stats()
+	info := types.WorkerInfo{
+		ID:        w.ID,
+		Username:  w.Self.Username,
+		Status:    "ok",
+		InFlight:  stats.InFlight,
+		ErrorRate: stats.ErrorRate,
+		LatencyMs: stats.Latency.Milliseconds(),
+	}
+	now := time.Now()
+	if stats.QuarantinedUntil.After(now) {
+		info.Status = "quarantined"
+		info.AvailableAt = &stats.QuarantinedUntil
+	}
+	if stats.FloodUntil.After(now) && (info.AvailableAt == nil || stats.FloodUntil.After(*info.AvailableAt)) {
+		info.Status = "flood-wait"
+		info.AvailableAt = &stats.FloodUntil
+	}
+	return info
+}
+
+// BotWorkers holds the running worker bots. Bots is replaced rather than
+// modified in place, so a slice obtained from List stays valid while workers
+// are added or removed.
 type BotWorkers struct {
 	Bots     []*Worker
 	starting int
@@ -36,6 +82,16 @@ type BotWorkers struct {
 	log      *zap.Logger
 }
 
+var (
+	ErrWorkerNotFound  = errors.New("worker not found")
+	ErrWorkerExists    = errors.New("a worker with this token is already running")
+	ErrDefaultWorker   = errors.New("the default bot can't be removed")
+	ErrWorkerTimeout   = errors.New("timed out starting worker")
+	ErrWorkerNotAdmin  = errors.New("failed to make worker an admin of the log channel")
+	workerDrainTimeout = 10 * time.Minute
+	workerStartTimeout = 30 * time.Second
+)
+
 var Workers *BotWorkers = &BotWorkers{
 	log:  nil,
 	Bots: make([]*Worker, 0),
@@ -46,96 +102,277 @@ func (w *BotWorkers) Init(log *zap.Logger) {
 }
 
 func (w *BotWorkers) AddDefaultClient(client *gotgproto.Client, self *tg.User) {
-	if w.Bots == nil {
-		w.Bots = make([]*Worker, 0)
-	}
-	w.incStarting()
-	w.Bots = append(w.Bots, &Worker{
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	w.starting++
+	w.Bots = append(w.Bots[:len(w.Bots):len(w.Bots)], &Worker{
 		Client: client,
 		ID:     w.starting,
 		Self:   self,
 		log:    w.log,
+		health: defaultClientHealth,
+		token:  config.ValueOf.BotToken,
 	})
 	w.log.Sugar().Info("Default bot loaded")
 }
 
-func (w *BotWorkers) incStarting() {
+func (w *BotWorkers) incStarting() int {
 	w.mut.Lock()
 	defer w.mut.Unlock()
 	w.starting++
+	return w.starting
+}
+
+// List returns a snapshot of the running workers.
+func (w *BotWorkers) List() []*Worker {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	return w.Bots
+}
+
+func (w *BotWorkers) hasToken(token string) bool {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	for _, worker := range w.Bots {
+		if worker.token == token {
+			return true
+		}
+	}
+	return false
 }
 
-func (w *BotWorkers) Add(token string) (err error) {
-	w.incStarting()
-	var botID int = w.starting
-	client, err := startWorker(w.log, token, botID)
+// Add starts a worker bot for token and makes it available for new requests.
+// The start is given up on after 30 seconds or when ctx is done, and the
+// client is stopped then, so a worker reported as failed never joins later.
+// If the user bot is running, it makes the worker an admin of LOG_CHANNEL;
+// when that fails the running worker is returned with ErrWorkerNotAdmin.
+func (w *BotWorkers) Add(ctx context.Context, token string) (*Worker, error) {
+	if w.hasToken(token) {
+		return nil, ErrWorkerExists
+	}
+	ctx, cancel := context.WithTimeout(ctx, workerStartTimeout)
+	defer cancel()
+	botID := w.incStarting()
+	health := newWorkerHealth()
+	client, err := startWorker(ctx, w.log, token, botID, health)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, ErrWorkerTimeout
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-	w.log.Sugar().Infof("Bot @%s loaded with ID %d", client.Self.Username, botID)
-	w.Bots = append(w.Bots, &Worker{
+	worker := &Worker{
 		Client: client,
 		ID:     botID,
 		Self:   client.Self,
 		log:    w.log,
-	})
+		health: health,
+		token:  token,
+	}
+	if err := w.append(ctx, worker); err != nil {
+		client.Stop()
+		return nil, err
+	}
+	w.log.Sugar().Infof("Bot @%s loaded with ID %d", client.Self.Username, botID)
+	if err := UserBot.AddBotAsAdmin(worker); err != nil {
+		w.log.Sugar().Warnf("Failed to add @%s as admin: %v", client.Self.Username, err)
+		return worker, fmt.Errorf("%w: %v", ErrWorkerNotAdmin, err)
+	}
+	return worker, nil
+}
+
+// append adds a started worker, unless the start was given up on meanwhile
+// or another worker with its token was started first.
+func (w *BotWorkers) append(ctx context.Context, worker *Worker) error {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	if err := ctx.Err(); errors.Is(err, context.DeadlineExceeded) {
+		return ErrWorkerTimeout
+	} else if err != nil {
+		return err
+	}
+	for _, existing := range w.Bots {
+		if existing.token == worker.token {
+			return ErrWorkerExists
+		}
+	}
+	w.Bots = append(w.Bots[:len(w.Bots):len(w.Bots)], worker)
 	return nil
 }
 
+// Remove takes the worker with the given ID out of rotation right away and
+// stops its client in the background once the streams it serves have drained.
+func (w *BotWorkers) Remove(id int) (*Worker, error) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	for i, worker := range w.Bots {
+		if worker.ID != id {
+			continue
+		}
+		if worker.Client == Bot {
+			return nil, ErrDefaultWorker
+		}
+		bots := make([]*Worker, 0, len(w.Bots)-1)
+		bots = append(bots, w.Bots[:i]...)
+		w.Bots = append(bots, w.Bots[i+1:]...)
+		go worker.drain()
+		return worker, nil
+	}
+	return nil, ErrWorkerNotFound
+}
+
+// RemoveToken removes the worker started with token, see Remove.
+func (w *BotWorkers) RemoveToken(token string) (*Worker, error) {
+	for _, worker := range w.List() {
+		if worker.token == token {
+			return w.Remove(worker.ID)
+		}
+	}
+	return nil, ErrWorkerNotFound
+}
+
+func (w *Worker) drain() {
+	log := w.log.Sugar()
+	deadline := time.Now().Add(workerDrainTimeout)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for w.health.inFlight.Load() > 0 && time.Now().Before(deadline) {
+		<-ticker.C
+	}
+	if n := w.health.inFlight.Load(); n > 0 {
+		log.Warnf("Stopping worker %d with %d streams still running", w.ID, n)
+	}
+	w.Client.Stop()
+	log.Infof("Worker %d (@%s) stopped", w.ID, w.Self.Username)
+}
+
+// GetNextWorker picks a worker for a new request using WORKER_POLICY. Flood
+// waited and quarantined workers are skipped unless no other worker is left.
 func GetNextWorker() *Worker {
 	Workers.mut.Lock()
 	defer Workers.mut.Unlock()
-	index := (Workers.index + 1) % len(Workers.Bots)
-	Workers.index = index
-	worker := Workers.Bots[index]
+	now := time.Now()
+	var worker *Worker
+	switch config.ValueOf.WorkerPolicy {
+	case "least-loaded":
+		worker = Workers.leastLoaded(now)
+	case "weighted":
+		worker = Workers.weighted(now)
+	default:
+		worker = Workers.roundRobin(now)
+	}
+	if worker == nil {
+		Workers.log.Sugar().Warn("No healthy worker available, falling back to round-robin")
+		Workers.index = (Workers.index + 1) % len(Workers.Bots)
+		worker = Workers.Bots[Workers.index]
+	}
 	Workers.log.Sugar().Debugf("Using worker %d", worker.ID)
 	return worker
 }
 
+func (w *BotWorkers) roundRobin(now time.Time) *Worker {
+	for i := 1; i <= len(w.Bots); i++ {
+		index := (w.index + i) % len(w.Bots)
+		if w.Bots[index].health.available(now) {
+			w.index = index
+			return w.Bots[index]
+		}
+	}
+	return nil
+}
+
+func (w *BotWorkers) leastLoaded(now time.Time) *Worker {
+	var best *Worker
+	var bestCost float64
+	for _, worker := range w.Bots {
+		if !worker.health.available(now) {
+			continue
+		}
+		if cost := worker.health.cost(); best == nil || cost < bestCost {
+			best, bestCost = worker, cost
+		}
+	}
+	return best
+}
+
+func (w *BotWorkers) weighted(now time.Time) *Worker {
+	candidates := make([]*Worker, 0, len(w.Bots))
+	weights := make([]float64, 0, len(w.Bots))
+	var total float64
+	for _, worker := range w.Bots {
+		if !worker.health.available(now) {
+			continue
+		}
+		weight := 1 / worker.health.cost()
+		candidates = append(candidates, worker)
+		weights = append(weights, weight)
+		total += weight
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	pick := rand.Float64() * total
+	for i, weight := range weights {
+		if pick < weight {
+			return candidates[i]
+		}
+		pick -= weight
+	}
+	return candidates[len(candidates)-1]
+}
+
+// GetStripeClients returns the clients of every healthy worker, beginning with
+// first, so that the chunks of a single download can be spread over all of them.
+// The extra workers count as busy until release is called.
+func GetStripeClients(first *Worker) (clients []*gotgproto.Client, release func()) {
+	Workers.mut.Lock()
+	defer Workers.mut.Unlock()
+	now := time.Now()
+	clients = []*gotgproto.Client{first.Client}
+	var used []*Worker
+	for _, worker := range Workers.Bots {
+		if worker != first && worker.health.available(now) {
+			clients = append(clients, worker.Client)
+			used = append(used, worker)
+			worker.StartStream()
+		}
+	}
+	var once sync.Once
+	return clients, func() {
+		once.Do(func() {
+			for _, worker := range used {
+				worker.EndStream()
+			}
+		})
+	}
+}
+
 func StartWorkers(log *zap.Logger) (*BotWorkers, error) {
 	Workers.Init(log)
 
-	if len(config.ValueOf.MultiTokens) == 0 {
+	tokens := append(config.ValueOf.MultiTokens[:len(config.ValueOf.MultiTokens):len(config.ValueOf.MultiTokens)], config.ValueOf.FileTokens...)
+	if len(tokens) == 0 {
 		Workers.log.Sugar().Info("No worker bot tokens provided, skipping worker initialization")
 		return Workers, nil
 	}
 	Workers.log.Sugar().Info("Starting")
 	if config.ValueOf.UseSessionFile {
 		Workers.log.Sugar().Info("Using session file for workers")
-		newpath := filepath.Join(".", "sessions")
-		if err := os.MkdirAll(newpath, os.ModePerm); err != nil {
-			Workers.log.Error("Failed to create sessions directory", zap.Error(err))
-			return nil, err
-		}
 	}
 
 	var wg sync.WaitGroup
 	var successfulStarts int32
-	totalBots := len(config.ValueOf.MultiTokens)
+	totalBots := len(tokens)
 
 	for i := 0; i < totalBots; i++ {
 		wg.Add(1)
 		go func(i int) {
 			defer wg.Done()
 
-			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-			defer cancel()
-
-			done := make(chan error, 1)
-			go func() {
-				err := Workers.Add(config.ValueOf.MultiTokens[i])
-				done <- err
-			}()
-
-			select {
-			case err := <-done:
-				if err != nil {
-					Workers.log.Error("Failed to start worker", zap.Int("index", i), zap.Error(err))
-				} else {
-					atomic.AddInt32(&successfulStarts, 1)
-				}
-			case <-ctx.Done():
-				Workers.log.Error("Timed out starting worker", zap.Int("index", i))
+			if _, err := Workers.Add(context.Background(), tokens[i]); err != nil && !errors.Is(err, ErrWorkerNotAdmin) {
+				Workers.log.Error("Failed to start worker", zap.Int("index", i), zap.Error(err))
+			} else {
+				atomic.AddInt32(&successfulStarts, 1)
 			}
 		}(i)
 	}
@@ -145,15 +382,29 @@ func StartWorkers(log *zap.Logger) (*BotWorkers, error) {
 	return Workers, nil
 }
 
-func startWorker(l *zap.Logger, botToken string, index int) (*gotgproto.Client, error) {
+// startWorker logs in a worker bot. ctx only bounds the start: the client
+// runs on after it, but is stopped if ctx is done before it has started.
+func startWorker(ctx context.Context, l *zap.Logger, botToken string, index int, health *workerHealth) (*gotgproto.Client, error) {
 	log := l.Named("Worker").Sugar()
 	log.Infof("Starting worker with index - %d", index)
 	var sessionType sessionMaker.SessionConstructor
 	if config.ValueOf.UseSessionFile {
+		if err := os.MkdirAll(filepath.Join(".", "sessions"), os.ModePerm); err != nil {
+			return nil, err
+		}
 		sessionType = sessionMaker.SqlSession(sqlite.Open(fmt.Sprintf("sessions/worker-%d.session", index)))
 	} else {
 		sessionType = sessionMaker.SimpleSession()
 	}
+	clientCtx, stopClient := context.WithCancel(context.Background())
+	// either the start or ctx settles the outcome, never both
+	var settled atomic.Bool
+	stop := context.AfterFunc(ctx, func() {
+		if settled.CompareAndSwap(false, true) {
+			stopClient()
+		}
+	})
+	defer stop()
 	client, err := gotgproto.NewClient(
 		int(config.ValueOf.ApiID),
 		config.ValueOf.ApiHash,
@@ -161,10 +412,18 @@ func startWorker(l *zap.Logger, botToken string, index int) (*gotgproto.Client,
 		&gotgproto.ClientOpts{
 			Session:          sessionType,
 			DisableCopyright: true,
-			Middlewares:      GetFloodMiddleware(log.Desugar()),
+			Middlewares:      append(GetFloodMiddleware(log.Desugar()), health),
+			Context:          clientCtx,
 		},
 	)
+	if !settled.CompareAndSwap(false, true) {
+		if err == nil {
+			client.Stop()
+		}
+		return nil, ctx.Err()
+	}
 	if err != nil {
+		stopClient()
 		return nil, err
 	}
 	return client, nil
@@ -66,3 +66,18 @@ func (c *Cache) Delete(key string) error {
 	cache.cache.Del([]byte(key))
 	return nil
 }
+
+// GetBytes returns the raw value stored under key.
+func (c *Cache) GetBytes(key string) ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return cache.cache.Get([]byte(key))
+}
+
+// SetBytes stores a raw value under key. Values larger than 1/1024 of the
+// cache size are rejected with freecache.ErrLargeEntry.
+func (c *Cache) SetBytes(key string, value []byte, expireSeconds int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return cache.cache.Set([]byte(key), value, expireSeconds)
+}
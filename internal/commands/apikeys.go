@@ -0,0 +1,131 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/database"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/telegram/message/styling"
+)
+
+func (m *command) LoadAPIKeys(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("apikeys")
+	defer log.Sugar().Info("Loaded")
+
+	dispatcher.AddHandler(
+		handlers.NewCommand("apikeys", m.apiKeysHandler),
+	)
+	dispatcher.AddHandler(
+		handlers.NewCommand("addapikey", m.addAPIKeyHandler),
+	)
+	dispatcher.AddHandler(
+		handlers.NewCommand("removeapikey", m.removeAPIKeyHandler),
+	)
+}
+
+func (m *command) apiKeysHandler(ctx *ext.Context, u *ext.Update) error {
+	if u.EffectiveChat().GetID() != config.ValueOf.AdminUserID {
+		ctx.Reply(u, "❌ You are not authorized to use this command.", nil)
+		return dispatcher.EndGroups
+	}
+	keys, err := database.DB.GetAPIKeys()
+	if err != nil {
+		ctx.Reply(u, "❌ Failed to look up the API keys.", nil)
+		return dispatcher.EndGroups
+	}
+	if len(keys) == 0 {
+		ctx.Reply(u, "No API keys yet. Create one with /addapikey <name>.", nil)
+		return dispatcher.EndGroups
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "🔑 API keys: %d\n\n", len(keys))
+	for _, key := range keys {
+		lastUsed := "never"
+		if key.LastUsedAt != nil {
+			lastUsed = key.LastUsedAt.UTC().Format("2006-01-02 15:04 MST")
+		}
+		fmt.Fprintf(&sb, "#%d %s (%s…)\nuser: %d | last used: %s\n\n", key.ID, key.Name, key.Prefix, key.UserID, lastUsed)
+	}
+	ctx.Reply(u, sb.String(), nil)
+	return dispatcher.EndGroups
+}
+
+func (m *command) addAPIKeyHandler(ctx *ext.Context, u *ext.Update) error {
+	chatID := u.EffectiveChat().GetID()
+	if chatID != config.ValueOf.AdminUserID {
+		ctx.Reply(u, "❌ You are not authorized to use this command.", nil)
+		return dispatcher.EndGroups
+	}
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) != 2 && len(args) != 3 {
+		ctx.Reply(u, "Usage: /addapikey <name> [user id]\n\nFiles linked with the key belong to the user, by default you.", nil)
+		return dispatcher.EndGroups
+	}
+	userID := chatID
+	if len(args) == 3 {
+		var err error
+		userID, err = strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			ctx.Reply(u, "❌ User ID must be a number.", nil)
+			return dispatcher.EndGroups
+		}
+	}
+	key, err := utils.NewAPIKey()
+	if err != nil {
+		m.log.Sugar().Errorf("Failed to generate API key: %v", err)
+		ctx.Reply(u, "❌ Failed to generate an API key.", nil)
+		return dispatcher.EndGroups
+	}
+	apiKey := &database.APIKey{
+		Name:    args[1],
+		KeyHash: utils.HashAPIKey(key),
+		Prefix:  key[:8],
+		UserID:  userID,
+	}
+	if err := database.DB.AddAPIKey(apiKey); err != nil {
+		ctx.Reply(u, "❌ Failed to store the API key.", nil)
+		return dispatcher.EndGroups
+	}
+	ctx.Reply(u, []styling.StyledTextOption{
+		styling.Plain(fmt.Sprintf("✅ API key #%d (%s) created:\n\n", apiKey.ID, apiKey.Name)),
+		styling.Code(key),
+		styling.Plain("\n\nIt won't be shown again. Send it as \"Authorization: Bearer <key>\" to /api/v1."),
+	}, nil)
+	return dispatcher.EndGroups
+}
+
+func (m *command) removeAPIKeyHandler(ctx *ext.Context, u *ext.Update) error {
+	if u.EffectiveChat().GetID() != config.ValueOf.AdminUserID {
+		ctx.Reply(u, "❌ You are not authorized to use this command.", nil)
+		return dispatcher.EndGroups
+	}
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) != 2 {
+		ctx.Reply(u, "Usage: /removeapikey <key id>", nil)
+		return dispatcher.EndGroups
+	}
+	id, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		ctx.Reply(u, "❌ Key ID must be a number, see /apikeys.", nil)
+		return dispatcher.EndGroups
+	}
+	err = database.DB.DeleteAPIKey(uint(id))
+	if errors.Is(err, database.ErrAPIKeyNotFound) {
+		ctx.Reply(u, "❌ No API key with that ID, see /apikeys.", nil)
+		return dispatcher.EndGroups
+	}
+	if err != nil {
+		ctx.Reply(u, "❌ Failed to remove the API key.", nil)
+		return dispatcher.EndGroups
+	}
+	ctx.Reply(u, fmt.Sprintf("✅ API key #%d removed. It no longer works.", id), nil)
+	return dispatcher.EndGroups
+}
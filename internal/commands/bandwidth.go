@@ -0,0 +1,124 @@
+package commands
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/database"
+	"EverythingSuckz/fsb/internal/throttle"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+)
+
+const bandwidthUsage = "Usage:\n" +
+	"/bandwidth - show the current limits\n" +
+	"/bandwidth global <rate> - total bandwidth of all streams\n" +
+	"/bandwidth stream <rate> - bandwidth of a single stream\n" +
+	"/bandwidth tier <name> <rate> - bandwidth of all streams of a user in the tier\n" +
+	"/bandwidth user <user id> <tier> - put a user in a tier\n\n" +
+	"Rates are per second, e.g. 5MB, use 0 for unlimited. Changes to the rates last until the next restart."
+
+func (m *command) LoadBandwidth(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("bandwidth")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(
+		handlers.NewCommand("bandwidth", bandwidthHandler),
+	)
+}
+
+func bandwidthHandler(ctx *ext.Context, u *ext.Update) error {
+	if u.EffectiveChat().GetID() != config.ValueOf.AdminUserID {
+		ctx.Reply(u, "❌ You are not authorized to use this command.", nil)
+		return dispatcher.EndGroups
+	}
+	t := throttle.GetThrottle()
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) == 1 {
+		ctx.Reply(u, bandwidthText(t.Limits()), nil)
+		return dispatcher.EndGroups
+	}
+	parseRate := func(value string) (int64, bool) {
+		bytesPerSecond, err := utils.ParseSize(strings.TrimSuffix(strings.ToLower(value), "/s"))
+		if err != nil {
+			ctx.Reply(u, fmt.Sprintf("❌ Invalid rate %q.\n\n%s", value, bandwidthUsage), nil)
+			return 0, false
+		}
+		return bytesPerSecond, true
+	}
+	switch {
+	case args[1] == "global" && len(args) == 3:
+		bytesPerSecond, ok := parseRate(args[2])
+		if !ok {
+			return dispatcher.EndGroups
+		}
+		t.SetGlobal(bytesPerSecond)
+	case args[1] == "stream" && len(args) == 3:
+		bytesPerSecond, ok := parseRate(args[2])
+		if !ok {
+			return dispatcher.EndGroups
+		}
+		t.SetPerStream(bytesPerSecond)
+	case args[1] == "tier" && len(args) == 4:
+		bytesPerSecond, ok := parseRate(args[3])
+		if !ok {
+			return dispatcher.EndGroups
+		}
+		t.SetTier(args[2], bytesPerSecond)
+	case args[1] == "user" && len(args) == 4:
+		userID, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			ctx.Reply(u, "❌ Invalid user ID.", nil)
+			return dispatcher.EndGroups
+		}
+		tier := args[3]
+		if !t.HasTier(tier) {
+			ctx.Reply(u, fmt.Sprintf("❌ Unknown tier %q, create it with /bandwidth tier first.", tier), nil)
+			return dispatcher.EndGroups
+		}
+		if tier == throttle.DefaultTier {
+			err = database.DB.DeleteUserTier(userID)
+		} else {
+			err = database.DB.SetUserTier(userID, tier)
+		}
+		if err != nil {
+			ctx.Reply(u, "❌ Failed to set the tier.", nil)
+			return dispatcher.EndGroups
+		}
+		ctx.Reply(u, fmt.Sprintf("✅ User %d is now in the %s tier. This applies to new streams.", userID, tier), nil)
+		return dispatcher.EndGroups
+	default:
+		ctx.Reply(u, bandwidthUsage, nil)
+		return dispatcher.EndGroups
+	}
+	ctx.Reply(u, "✅ Updated.\n\n"+bandwidthText(t.Limits()), nil)
+	return dispatcher.EndGroups
+}
+
+func bandwidthText(limits throttle.Limits) string {
+	rate := func(bytesPerSecond int64) string {
+		if bytesPerSecond == 0 {
+			return "unlimited"
+		}
+		return utils.SizeFormat(bytesPerSecond) + "/s"
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "📶 Bandwidth\nGlobal: %s\nPer stream: %s\n\nTiers:\n", rate(limits.Global), rate(limits.PerStream))
+	if _, ok := limits.Tiers[throttle.DefaultTier]; !ok {
+		limits.Tiers[throttle.DefaultTier] = 0
+	}
+	names := make([]string, 0, len(limits.Tiers))
+	for name := range limits.Tiers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(&sb, "• %s: %s\n", name, rate(limits.Tiers[name]))
+	}
+	return sb.String()
+}
@@ -96,7 +96,7 @@ func (m *command) sendFileToTelegram(ctx *ext.Context, chatID int64, fileData []
 
 	// Read file in chunks and upload
 	const chunkSize = 512 * 1024 // 512KB chunks
-	var part int32 = 0
+	var part int = 0
 	
 	for {
 		chunk := make([]byte, chunkSize)
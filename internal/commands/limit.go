@@ -0,0 +1,106 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/database"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+)
+
+const limitUsage = "Usage: /limit <link or message id> <max downloads> [max size]\n\n" +
+	"Use 0 for no limit, e.g. /limit 123 5 10GB or /limit 123 0 0 to remove the limits.\n" +
+	"Send /limit <link or message id> alone to see how much of the limits is used."
+
+func (m *command) LoadLimit(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("limit")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(
+		handlers.NewCommand("limit", limitHandler),
+	)
+}
+
+func limitHandler(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) < 2 || len(args) > 4 {
+		ctx.Reply(u, limitUsage, nil)
+		return dispatcher.EndGroups
+	}
+	messageID, ok := parseMessageID(args[1])
+	if !ok {
+		ctx.Reply(u, "❌ That doesn't look like a link generated by this bot.", nil)
+		return dispatcher.EndGroups
+	}
+	file, err := database.DB.GetFileByMessageID(messageID)
+	if errors.Is(err, database.ErrFileNotFound) || err == nil && file.UserID != chatId && chatId != config.ValueOf.AdminUserID {
+		ctx.Reply(u, "❌ You can only limit links to your own files.", nil)
+		return dispatcher.EndGroups
+	}
+	if err != nil {
+		ctx.Reply(u, "❌ Failed to look up the file.", nil)
+		return dispatcher.EndGroups
+	}
+	if len(args) == 2 {
+		ctx.Reply(u, fmt.Sprintf("📄 %s\n%s", file.FileName, limitsText(file)), nil)
+		return dispatcher.EndGroups
+	}
+	if file.FileSize == 0 {
+		ctx.Reply(u, "❌ Limits are not supported for photos.", nil)
+		return dispatcher.EndGroups
+	}
+	maxDownloads, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || maxDownloads < 0 {
+		ctx.Reply(u, "❌ Invalid number of downloads.\n\n"+limitUsage, nil)
+		return dispatcher.EndGroups
+	}
+	var maxBytes int64
+	if len(args) == 4 {
+		if maxBytes, err = utils.ParseSize(args[3]); err != nil {
+			ctx.Reply(u, "❌ Invalid size.\n\n"+limitUsage, nil)
+			return dispatcher.EndGroups
+		}
+	}
+	if err := database.DB.SetFileLimits(messageID, maxDownloads, maxBytes); err != nil {
+		ctx.Reply(u, "❌ Failed to set the limits.", nil)
+		return dispatcher.EndGroups
+	}
+	file.MaxDownloads, file.MaxBytes = maxDownloads, maxBytes
+	ctx.Reply(u, fmt.Sprintf("✅ Limits updated for %s\n%s", file.FileName, limitsText(file)), nil)
+	return dispatcher.EndGroups
+}
+
+// limitsText describes the limits of a file and how much of them is used.
+func limitsText(file *database.File) string {
+	downloads := "unlimited"
+	if file.MaxDownloads > 0 {
+		downloads = strconv.FormatInt(file.MaxDownloads, 10)
+	}
+	bandwidth := "unlimited"
+	if file.MaxBytes > 0 {
+		bandwidth = utils.SizeFormat(file.MaxBytes)
+	}
+	var used float64
+	if file.FileSize > 0 {
+		used = float64(file.BytesServed) / float64(file.FileSize)
+	}
+	return fmt.Sprintf(
+		"⬇️ Downloads: %.1f / %s\n📶 Served: %s / %s",
+		used,
+		downloads,
+		utils.SizeFormat(file.BytesServed),
+		bandwidth,
+	)
+}
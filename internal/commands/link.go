@@ -0,0 +1,107 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/database"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/telegram/message/styling"
+)
+
+const linkUsage = "Usage: /link <link or message id> <ttl> [ip]\n\n" +
+	"ttl is a duration like 30m, 12h or 7d, or \"never\".\n" +
+	"If ip is given, the link only works from that address."
+
+func (m *command) LoadLink(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("link")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(
+		handlers.NewCommand("link", linkHandler),
+	)
+}
+
+func linkHandler(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) != 3 && len(args) != 4 {
+		ctx.Reply(u, linkUsage, nil)
+		return dispatcher.EndGroups
+	}
+	messageID, ok := parseMessageID(args[1])
+	if !ok {
+		ctx.Reply(u, "❌ That doesn't look like a link generated by this bot.", nil)
+		return dispatcher.EndGroups
+	}
+	ttl, err := parseTTL(args[2])
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("❌ %s\n\n%s", err.Error(), linkUsage), nil)
+		return dispatcher.EndGroups
+	}
+	var clientIP string
+	if len(args) == 4 {
+		ip := net.ParseIP(args[3])
+		if ip == nil {
+			ctx.Reply(u, "❌ Invalid IP address.", nil)
+			return dispatcher.EndGroups
+		}
+		clientIP = ip.String()
+	}
+	file, err := database.DB.GetFileByMessageID(messageID)
+	if errors.Is(err, database.ErrFileNotFound) || err == nil && file.UserID != chatId && chatId != config.ValueOf.AdminUserID {
+		ctx.Reply(u, "❌ You can only create links to your own files.", nil)
+		return dispatcher.EndGroups
+	}
+	if err != nil {
+		ctx.Reply(u, "❌ Failed to look up the file.", nil)
+		return dispatcher.EndGroups
+	}
+	link := streamLink(file.MessageID, file.FileID, ttl, clientIP)
+	text := []styling.StyledTextOption{styling.Code(link)}
+	if ttl > 0 {
+		text = append(text, styling.Plain(fmt.Sprintf("\n\n⏳ Expires %s", time.Now().Add(ttl).UTC().Format("2006-01-02 15:04 MST"))))
+	}
+	if clientIP != "" {
+		text = append(text, styling.Plain(fmt.Sprintf("\n🔒 Only works from %s", clientIP)))
+	}
+	ctx.Reply(u, text, &ext.ReplyOpts{Markup: linkMarkup(link, file.MimeType)})
+	return dispatcher.EndGroups
+}
+
+// parseTTL parses a time.Duration, additionally accepting a "d" suffix for
+// days and "never" for links that don't expire.
+func parseTTL(value string) (time.Duration, error) {
+	if value == "never" {
+		return 0, nil
+	}
+	var ttl time.Duration
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, errors.New("invalid ttl")
+		}
+		ttl = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if ttl, err = time.ParseDuration(value); err != nil {
+			return 0, errors.New("invalid ttl")
+		}
+	}
+	if ttl < time.Minute {
+		return 0, errors.New("ttl must be at least one minute")
+	}
+	return ttl, nil
+}
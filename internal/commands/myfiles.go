@@ -0,0 +1,228 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/database"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/tg"
+)
+
+const (
+	myFilesPageSize = 5
+	myFilesPrefix   = "myfiles:"
+)
+
+func (m *command) LoadMyFiles(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("myfiles")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(
+		handlers.NewCommand("myfiles", myFilesHandler),
+	)
+}
+
+func myFilesHandler(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	if len(config.ValueOf.AllowedUsers) != 0 && !utils.Contains(config.ValueOf.AllowedUsers, chatId) {
+		ctx.Reply(u, "You are not allowed to use this bot.", nil)
+		return dispatcher.EndGroups
+	}
+	text, markup, err := filesPage(chatId, 0)
+	if err != nil {
+		ctx.Reply(u, "❌ Failed to load your files.", nil)
+		return dispatcher.EndGroups
+	}
+	ctx.Reply(u, text, &ext.ReplyOpts{Markup: markup})
+	return dispatcher.EndGroups
+}
+
+// handleMyFilesCallback handles the inline buttons of /myfiles. The callback
+// data looks like "myfiles:<action>:<args>".
+func handleMyFilesCallback(ctx *ext.Context, u *ext.Update) error {
+	callbackQuery := u.CallbackQuery
+	chatID := callbackQuery.UserID
+	args := strings.Split(strings.TrimPrefix(string(callbackQuery.Data), myFilesPrefix), ":")
+	answer := func(message string) {
+		ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{
+			QueryID: callbackQuery.QueryID,
+			Message: message,
+		})
+	}
+	numbers := make([]int, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			answer("Invalid button.")
+			return dispatcher.EndGroups
+		}
+		numbers = append(numbers, n)
+	}
+	edit := func(text string, markup tg.ReplyMarkupClass) error {
+		_, err := ctx.EditMessage(chatID, &tg.MessagesEditMessageRequest{
+			Peer:        ctx.PeerStorage.GetInputPeerById(chatID),
+			ID:          callbackQuery.MsgID,
+			Message:     text,
+			ReplyMarkup: markup,
+		})
+		return err
+	}
+
+	if args[0] == "page" && len(numbers) == 1 {
+		text, markup, err := filesPage(chatID, numbers[0])
+		if err != nil {
+			answer("Failed to load your files.")
+			return dispatcher.EndGroups
+		}
+		answer("")
+		return edit(text, markup)
+	}
+	if len(numbers) == 0 {
+		answer("Invalid button.")
+		return dispatcher.EndGroups
+	}
+
+	file, err := database.DB.GetFileByMessageID(numbers[0])
+	if errors.Is(err, database.ErrFileNotFound) || err == nil && file.UserID != chatID {
+		answer("This file is no longer in your files.")
+		return dispatcher.EndGroups
+	}
+	if err != nil {
+		answer("Failed to load the file.")
+		return dispatcher.EndGroups
+	}
+	page := 0
+	if len(numbers) > 1 {
+		page = numbers[1]
+	}
+
+	switch args[0] {
+	case "file":
+		answer("")
+		return edit(fileDetails(file), fileDetailsMarkup(file, page))
+	case "link":
+		answer("")
+		link := streamLink(file.MessageID, file.FileID, 0, "")
+		_, err := ctx.SendMessage(chatID, &tg.MessagesSendMessageRequest{
+			Peer:        ctx.PeerStorage.GetInputPeerById(chatID),
+			Message:     link,
+			Entities:    []tg.MessageEntityClass{&tg.MessageEntityCode{Offset: 0, Length: len(link)}},
+			ReplyMarkup: linkMarkup(link, file.MimeType),
+		})
+		return err
+	case "del":
+		answer("")
+		return edit(
+			fmt.Sprintf("🗑 Remove %s from your files and revoke its links?", file.FileName),
+			&tg.ReplyInlineMarkup{Rows: []tg.KeyboardButtonRow{{
+				Buttons: []tg.KeyboardButtonClass{
+					myFilesButton("✅ Yes, remove", "delyes", file.MessageID, page),
+					myFilesButton("« Cancel", "file", file.MessageID, page),
+				},
+			}}},
+		)
+	case "delyes":
+		if err := bot.RevokeFile(ctx, file.MessageID, chatID); err != nil {
+			utils.Logger.Sugar().Errorf("Failed to revoke %d: %v", file.MessageID, err)
+			answer(fmt.Sprintf("⚠️ %s", err.Error()))
+		} else {
+			answer("Removed, its links no longer work.")
+		}
+		text, markup, err := filesPage(chatID, page)
+		if err != nil {
+			return err
+		}
+		return edit(text, markup)
+	}
+	answer("Invalid button.")
+	return dispatcher.EndGroups
+}
+
+// filesPage renders one page of the user's files. Out of range pages are
+// clamped so that removing the last file of a page still shows something.
+func filesPage(userID int64, page int) (string, tg.ReplyMarkupClass, error) {
+	total, err := database.DB.CountUserFiles(userID)
+	if err != nil {
+		return "", nil, err
+	}
+	if total == 0 {
+		return "📂 You haven't generated any links yet. Send me a file to get started.", nil, nil
+	}
+	pages := int((total + myFilesPageSize - 1) / myFilesPageSize)
+	page = max(0, min(page, pages-1))
+	files, err := database.DB.GetUserFiles(userID, page*myFilesPageSize, myFilesPageSize)
+	if err != nil {
+		return "", nil, err
+	}
+	rows := make([]tg.KeyboardButtonRow, 0, len(files)+1)
+	for _, file := range files {
+		rows = append(rows, tg.KeyboardButtonRow{Buttons: []tg.KeyboardButtonClass{
+			myFilesButton(fmt.Sprintf("📄 %s · %s", file.FileName, utils.SizeFormat(file.FileSize)), "file", file.MessageID, page),
+		}})
+	}
+	var nav []tg.KeyboardButtonClass
+	if page > 0 {
+		nav = append(nav, myFilesButton("« Prev", "page", page-1))
+	}
+	if page < pages-1 {
+		nav = append(nav, myFilesButton("Next »", "page", page+1))
+	}
+	if len(nav) > 0 {
+		rows = append(rows, tg.KeyboardButtonRow{Buttons: nav})
+	}
+	text := fmt.Sprintf("📂 Your files: %d (page %d/%d)\n\nTap a file to get its link again.", total, page+1, pages)
+	return text, &tg.ReplyInlineMarkup{Rows: rows}, nil
+}
+
+func fileDetails(file *database.File) string {
+	text := fmt.Sprintf(
+		"📄 %s\n💾 %s\n🗂 %s\n📅 %s",
+		file.FileName,
+		utils.SizeFormat(file.FileSize),
+		file.MimeType,
+		file.CreatedAt.Format("2006-01-02 15:04"),
+	)
+	if file.PasswordHash != "" {
+		text += "\n🔒 Password protected"
+	}
+	if file.MaxDownloads > 0 || file.MaxBytes > 0 {
+		text += "\n" + limitsText(file)
+	}
+	return text
+}
+
+func fileDetailsMarkup(file *database.File, page int) tg.ReplyMarkupClass {
+	return &tg.ReplyInlineMarkup{Rows: []tg.KeyboardButtonRow{
+		{Buttons: []tg.KeyboardButtonClass{
+			myFilesButton("🔗 Get link", "link", file.MessageID),
+			myFilesButton("🗑 Delete", "del", file.MessageID, page),
+		}},
+		{Buttons: []tg.KeyboardButtonClass{
+			myFilesButton("« Back", "page", page),
+		}},
+	}}
+}
+
+func myFilesButton(text string, action string, args ...int) tg.KeyboardButtonClass {
+	data := myFilesPrefix + action
+	for _, arg := range args {
+		data += ":" + strconv.Itoa(arg)
+	}
+	return &tg.KeyboardButtonCallback{
+		Text: text,
+		Data: []byte(data),
+	}
+}
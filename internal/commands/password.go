@@ -0,0 +1,114 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/database"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/tg"
+)
+
+const (
+	passwordUsage = "Reply to a link with /password <password> to protect it, or /password off to remove the password.\n\n" +
+		"You can also use /password <link or message id> <password|off>."
+	minPasswordLength = 4
+)
+
+func (m *command) LoadPassword(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("password")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(
+		handlers.NewCommand("password", m.passwordHandler),
+	)
+}
+
+func (m *command) passwordHandler(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	_, rest, _ := strings.Cut(u.EffectiveMessage.Text, " ")
+	rest = strings.TrimSpace(rest)
+
+	var target, password string
+	if reply, ok := u.EffectiveMessage.ReplyTo.(*tg.MessageReplyHeader); ok && reply.ReplyToMsgID != 0 {
+		target, password = repliedText(ctx, chatId, reply.ReplyToMsgID), rest
+	} else {
+		target, password, _ = strings.Cut(rest, " ")
+		password = strings.TrimSpace(password)
+	}
+	if password == "" {
+		ctx.Reply(u, passwordUsage, nil)
+		return dispatcher.EndGroups
+	}
+	// the message holds the password, don't leave it in the chat history
+	if err := ctx.DeleteMessages(chatId, []int{u.EffectiveMessage.ID}); err != nil {
+		m.log.Sugar().Warnf("Failed to delete /password message: %v", err)
+	}
+	send := func(text string) {
+		ctx.SendMessage(chatId, newMessageRequest(ctx, chatId, text))
+	}
+
+	messageID, ok := parseMessageID(target)
+	if !ok {
+		send("❌ That doesn't look like a link generated by this bot.\n\n" + passwordUsage)
+		return dispatcher.EndGroups
+	}
+	file, err := database.DB.GetFileByMessageID(messageID)
+	if errors.Is(err, database.ErrFileNotFound) || err == nil && file.UserID != chatId && chatId != config.ValueOf.AdminUserID {
+		send("❌ You can only protect links to your own files.")
+		return dispatcher.EndGroups
+	}
+	if err != nil {
+		send("❌ Failed to look up the file.")
+		return dispatcher.EndGroups
+	}
+
+	if password == "off" {
+		if err := database.DB.SetFilePassword(messageID, ""); err != nil {
+			send("❌ Failed to remove the password.")
+			return dispatcher.EndGroups
+		}
+		send("🔓 Password removed from " + file.FileName + ".")
+		return dispatcher.EndGroups
+	}
+	if len(password) < minPasswordLength {
+		send(fmt.Sprintf("❌ The password must be at least %d characters long.", minPasswordLength))
+		return dispatcher.EndGroups
+	}
+	passwordHash, err := utils.HashPassword(password)
+	if err != nil {
+		m.log.Sugar().Errorf("Failed to hash password: %v", err)
+		send("❌ Failed to set the password.")
+		return dispatcher.EndGroups
+	}
+	if err := database.DB.SetFilePassword(messageID, passwordHash); err != nil {
+		send("❌ Failed to set the password.")
+		return dispatcher.EndGroups
+	}
+	send("🔒 " + file.FileName + " is now password protected. Links to it ask for the password before downloading.")
+	return dispatcher.EndGroups
+}
+
+// repliedText returns the text of the message with the given ID, which is
+// expected to be one of the link messages sent by the bot.
+func repliedText(ctx *ext.Context, chatID int64, messageID int) string {
+	messages, err := ctx.GetMessages(chatID, []tg.InputMessageClass{&tg.InputMessageID{ID: messageID}})
+	if err != nil || len(messages) == 0 {
+		return ""
+	}
+	message, ok := messages[0].(*tg.Message)
+	if !ok {
+		return ""
+	}
+	return message.Message
+}
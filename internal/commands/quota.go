@@ -0,0 +1,166 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/database"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+)
+
+const quotaUsage = "Usage:\n" +
+	"/quota - show your quota\n" +
+	"/quota <user id> - show the quota of a user\n" +
+	"/quota <user id> <files per hour> <files per day> <size per day> - override the quota of a user\n" +
+	"/quota <user id> reset - go back to the default quota\n\n" +
+	"Use 0 for unlimited and - to keep the default, e.g. /quota 12345 10 - 5GB"
+
+func (m *command) LoadQuota(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("quota")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(
+		handlers.NewCommand("quota", quotaHandler),
+	)
+}
+
+func quotaHandler(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) == 1 {
+		text, err := quotaText(chatId)
+		if err != nil {
+			ctx.Reply(u, "❌ Failed to load your quota.", nil)
+			return dispatcher.EndGroups
+		}
+		ctx.Reply(u, text, nil)
+		return dispatcher.EndGroups
+	}
+	if chatId != config.ValueOf.AdminUserID {
+		ctx.Reply(u, "❌ You are not authorized to use this command.", nil)
+		return dispatcher.EndGroups
+	}
+	userID, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || (len(args) != 2 && len(args) != 3 && len(args) != 5) || len(args) == 3 && args[2] != "reset" {
+		ctx.Reply(u, quotaUsage, nil)
+		return dispatcher.EndGroups
+	}
+	switch len(args) {
+	case 3:
+		if err := database.DB.DeleteUserQuota(userID); err != nil {
+			ctx.Reply(u, "❌ Failed to reset the quota.", nil)
+			return dispatcher.EndGroups
+		}
+	case 5:
+		quota, err := parseQuotaOverride(userID, args[2:])
+		if err != nil {
+			ctx.Reply(u, fmt.Sprintf("❌ %s\n\n%s", err.Error(), quotaUsage), nil)
+			return dispatcher.EndGroups
+		}
+		if err := database.DB.SetUserQuota(quota); err != nil {
+			ctx.Reply(u, "❌ Failed to set the quota.", nil)
+			return dispatcher.EndGroups
+		}
+	}
+	text, err := quotaText(userID)
+	if err != nil {
+		ctx.Reply(u, "❌ Failed to load the quota.", nil)
+		return dispatcher.EndGroups
+	}
+	ctx.Reply(u, fmt.Sprintf("👤 User %d\n%s", userID, text), nil)
+	return dispatcher.EndGroups
+}
+
+func parseQuotaOverride(userID int64, args []string) (*database.UserQuota, error) {
+	quota := &database.UserQuota{UserID: userID}
+	for i, dest := range []**int{&quota.FilesPerHour, &quota.FilesPerDay} {
+		if args[i] == "-" {
+			continue
+		}
+		n, err := strconv.Atoi(args[i])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid number of files %q", args[i])
+		}
+		*dest = &n
+	}
+	if args[2] != "-" {
+		n, err := utils.ParseSize(args[2])
+		if err != nil {
+			return nil, err
+		}
+		quota.BytesPerDay = &n
+	}
+	return quota, nil
+}
+
+// quotaExceededText is the friendly message sent when bot.CheckQuota refused a file.
+func quotaExceededText(reason string, resetAt time.Time) string {
+	if resetAt.IsZero() {
+		return fmt.Sprintf("⏳ Sorry, %s.", reason)
+	}
+	return fmt.Sprintf(
+		"⏳ Sorry, %s.\n\nYou can generate links again at %s (in %s).",
+		reason,
+		resetAt.UTC().Format("15:04 MST"),
+		formatWait(time.Until(resetAt)),
+	)
+}
+
+// formatWait formats d like "2h5m" or "45m".
+func formatWait(d time.Duration) string {
+	d = d.Round(time.Minute)
+	if d < time.Minute {
+		return "less than a minute"
+	}
+	text := strings.TrimSuffix(d.String(), "0s")
+	return strings.Replace(text, "h0m", "h", 1)
+}
+
+// quotaText describes the quotas of a user and how much of them is used.
+func quotaText(userID int64) (string, error) {
+	if userID == config.ValueOf.AdminUserID {
+		return "📊 Admins have no quota.", nil
+	}
+	limits, err := bot.UserQuotaLimits(userID)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	day, err := database.DB.GetLinkGenerations(userID, now.Add(-24*time.Hour))
+	if err != nil {
+		return "", err
+	}
+	var lastHour int
+	var bytes int64
+	for _, generation := range day {
+		if generation.CreatedAt.After(now.Add(-time.Hour)) {
+			lastHour++
+		}
+		bytes += generation.FileSize
+	}
+	limit := func(n int64, format func(int64) string) string {
+		if n == 0 {
+			return "unlimited"
+		}
+		return format(n)
+	}
+	count := func(n int64) string { return strconv.FormatInt(n, 10) }
+	return fmt.Sprintf(
+		"📊 Quota\nLinks in the last hour: %d / %s\nLinks in the last 24 hours: %d / %s\nSize in the last 24 hours: %s / %s",
+		lastHour, limit(int64(limits.FilesPerHour), count),
+		len(day), limit(int64(limits.FilesPerDay), count),
+		utils.SizeFormat(bytes), limit(limits.BytesPerDay, utils.SizeFormat),
+	), nil
+}
@@ -0,0 +1,78 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/database"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+)
+
+// matches the message ID in links like https://host/stream/123?hash=abcdef
+var linkMessageIDRegex = regexp.MustCompile(`/[a-z]+/(\d+)`)
+
+func (m *command) LoadRevoke(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("revoke")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(
+		handlers.NewCommand("revoke", m.revokeHandler),
+	)
+}
+
+func (m *command) revokeHandler(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) != 2 {
+		ctx.Reply(u, "Usage: /revoke <link or message id>", nil)
+		return dispatcher.EndGroups
+	}
+	messageID, ok := parseMessageID(args[1])
+	if !ok {
+		ctx.Reply(u, "❌ That doesn't look like a link generated by this bot.", nil)
+		return dispatcher.EndGroups
+	}
+	if chatId != config.ValueOf.AdminUserID {
+		file, err := database.DB.GetFileByMessageID(messageID)
+		if errors.Is(err, database.ErrFileNotFound) || err == nil && file.UserID != chatId {
+			ctx.Reply(u, "❌ You can only revoke links to your own files.", nil)
+			return dispatcher.EndGroups
+		}
+		if err != nil {
+			ctx.Reply(u, "❌ Failed to look up the file.", nil)
+			return dispatcher.EndGroups
+		}
+	}
+	if err := bot.RevokeFile(ctx, messageID, chatId); err != nil {
+		m.log.Sugar().Errorf("Failed to revoke %d: %v", messageID, err)
+		ctx.Reply(u, fmt.Sprintf("⚠️ %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	ctx.Reply(u, "✅ Link revoked. It will no longer work.", nil)
+	return dispatcher.EndGroups
+}
+
+// parseMessageID accepts either a bare log channel message ID or a link.
+func parseMessageID(value string) (int, bool) {
+	if id, err := strconv.Atoi(value); err == nil {
+		return id, id > 0
+	}
+	match := linkMessageIDRegex.FindStringSubmatch(value)
+	if match == nil {
+		return 0, false
+	}
+	id, err := strconv.Atoi(match[1])
+	return id, err == nil
+}
@@ -5,6 +5,7 @@ import (
         "EverythingSuckz/fsb/internal/database"
         "EverythingSuckz/fsb/internal/utils"
         "fmt"
+        "strings"
 
         "github.com/celestix/gotgproto/dispatcher" // This is the package
         "github.com/celestix/gotgproto/dispatcher/handlers"
@@ -140,6 +141,10 @@ func handleCallbacks(ctx *ext.Context, u *ext.Update) error {
         callbackData := string(callbackQuery.Data)
         chatID := callbackQuery.UserID
 
+        if strings.HasPrefix(callbackData, myFilesPrefix) {
+                return handleMyFilesCallback(ctx, u)
+        }
+
         switch callbackData {
         case "check_membership":
                 ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{
@@ -3,8 +3,11 @@ package commands
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/database"
 	"EverythingSuckz/fsb/internal/utils"
 
 	"github.com/celestix/gotgproto/dispatcher"
@@ -19,8 +22,10 @@ import (
 func (m *command) LoadStream(dispatcher dispatcher.Dispatcher) {
 	log := m.log.Named("start")
 	defer log.Sugar().Info("Loaded")
-	dispatcher.AddHandler(
+	// catches every message, so it runs after the command handlers in group 0
+	dispatcher.AddHandlerToGroup(
 		handlers.NewMessage(nil, sendLink),
+		1,
 	)
 }
 
@@ -58,6 +63,17 @@ func sendLink(ctx *ext.Context, u *ext.Update) error {
 		ctx.Reply(u, "Sorry, this message type is unsupported.", nil)
 		return dispatcher.EndGroups
 	}
+	var fileSize int64
+	if media, err := utils.FileFromMedia(u.EffectiveMessage.Media); err == nil {
+		fileSize = media.FileSize
+	}
+	reason, resetAt, err := bot.CheckQuota(chatId, fileSize)
+	if err != nil {
+		utils.Logger.Sugar().Errorf("Failed to check quota of %d: %v", chatId, err)
+	} else if reason != "" {
+		ctx.Reply(u, quotaExceededText(reason, resetAt), nil)
+		return dispatcher.EndGroups
+	}
 	update, err := utils.ForwardMessages(ctx, chatId, config.ValueOf.LogChannelID, u.EffectiveMessage.ID)
 	if err != nil {
 		utils.Logger.Sugar().Error(err)
@@ -78,8 +94,65 @@ func sendLink(ctx *ext.Context, u *ext.Update) error {
 		file.ID,
 	)
 	hash := utils.GetShortHash(fullHash)
-	link := fmt.Sprintf("%s/stream/%d?hash=%s", config.ValueOf.Host, messageID, hash)
+	err = database.DB.AddFile(&database.File{
+		MessageID: messageID,
+		UserID:    chatId,
+		FileName:  file.FileName,
+		FileSize:  file.FileSize,
+		MimeType:  file.MimeType,
+		FileID:    file.ID,
+		Hash:      hash,
+	})
+	if err != nil {
+		utils.Logger.Sugar().Errorf("Failed to record file %d: %v", messageID, err)
+	}
+	if err := database.DB.AddLinkGeneration(chatId, file.FileSize); err != nil {
+		utils.Logger.Sugar().Errorf("Failed to record link generation of %d: %v", chatId, err)
+	}
+	link := streamLink(messageID, file.ID, 0, "")
 	text := []styling.StyledTextOption{styling.Code(link)}
+	var details []string
+	if file.Performer != "" && file.Title != "" {
+		details = append(details, file.Performer+" – "+file.Title)
+	} else if file.Title != "" {
+		details = append(details, file.Title)
+	}
+	if file.Duration > 0 {
+		details = append(details, utils.DurationFormat(file.Duration))
+	}
+	if file.Width > 0 && file.Height > 0 {
+		details = append(details, fmt.Sprintf("%d×%d", file.Width, file.Height))
+	}
+	if file.SupportsStreaming {
+		details = append(details, "streamable")
+	}
+	if len(details) > 0 {
+		text = append(text, styling.Plain("\n\n🎞 "+strings.Join(details, " · ")))
+	}
+	_, err = ctx.Reply(u, text, &ext.ReplyOpts{
+		Markup:           linkMarkup(link, file.MimeType),
+		NoWebpage:        false,
+		ReplyToMessageId: u.EffectiveMessage.ID,
+	})
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+	}
+	return dispatcher.EndGroups
+}
+
+// streamLink returns a signed link to messageID that is valid for ttl (0 for
+// ever) and, if clientIP is set, only from that address.
+func streamLink(messageID int, fileID int64, ttl time.Duration, clientIP string) string {
+	return fmt.Sprintf("%s/stream/%d?%s", config.ValueOf.Host, messageID, utils.LinkQuery(messageID, fileID, ttl, clientIP))
+}
+
+// linkMarkup returns the Download and Stream buttons for link, or nil when
+// link points to localhost which Telegram doesn't accept in buttons.
+func linkMarkup(link string, mimeType string) tg.ReplyMarkupClass {
+	if strings.Contains(link, "http://localhost") {
+		return nil
+	}
 	row := tg.KeyboardButtonRow{
 		Buttons: []tg.KeyboardButtonClass{
 			&tg.KeyboardButtonURL{
@@ -88,30 +161,14 @@ func sendLink(ctx *ext.Context, u *ext.Update) error {
 			},
 		},
 	}
-	if strings.Contains(file.MimeType, "video") || strings.Contains(file.MimeType, "audio") || strings.Contains(file.MimeType, "pdf") {
+	if strings.Contains(mimeType, "video") || strings.Contains(mimeType, "audio") || strings.Contains(mimeType, "pdf") {
+		// the watch page takes the same parameters as the stream link
 		row.Buttons = append(row.Buttons, &tg.KeyboardButtonURL{
 			Text: "Stream",
-			URL:  link,
+			URL:  strings.Replace(link, "/stream/", "/watch/", 1),
 		})
 	}
-	markup := &tg.ReplyInlineMarkup{
+	return &tg.ReplyInlineMarkup{
 		Rows: []tg.KeyboardButtonRow{row},
 	}
-	if strings.Contains(link, "http://localhost") {
-		_, err = ctx.Reply(u, text, &ext.ReplyOpts{
-			NoWebpage:        false,
-			ReplyToMessageId: u.EffectiveMessage.ID,
-		})
-	} else {
-		_, err = ctx.Reply(u, text, &ext.ReplyOpts{
-			Markup:           markup,
-			NoWebpage:        false,
-			ReplyToMessageId: u.EffectiveMessage.ID,
-		})
-	}
-	if err != nil {
-		utils.Logger.Sugar().Error(err)
-		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
-	}
-	return dispatcher.EndGroups
 }
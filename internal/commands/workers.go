@@ -0,0 +1,129 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/types"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/tg"
+)
+
+func (m *command) LoadWorkers(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("workers")
+	defer log.Sugar().Info("Loaded")
+
+	dispatcher.AddHandler(
+		handlers.NewCommand("workers", m.workersHandler),
+	)
+	dispatcher.AddHandler(
+		handlers.NewCommand("addworker", m.addWorkerHandler),
+	)
+	dispatcher.AddHandler(
+		handlers.NewCommand("removeworker", m.removeWorkerHandler),
+	)
+}
+
+func (m *command) workersHandler(ctx *ext.Context, u *ext.Update) error {
+	if u.EffectiveChat().GetID() != config.ValueOf.AdminUserID {
+		ctx.Reply(u, "❌ You are not authorized to use this command.", nil)
+		return dispatcher.EndGroups
+	}
+	workers := bot.Workers.List()
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "🤖 Running workers: %d\n\n", len(workers))
+	for _, worker := range workers {
+		sb.WriteString(formatWorkerInfo(worker.Info()))
+		sb.WriteString("\n")
+	}
+	ctx.Reply(u, sb.String(), nil)
+	return dispatcher.EndGroups
+}
+
+func (m *command) addWorkerHandler(ctx *ext.Context, u *ext.Update) error {
+	chatID := u.EffectiveChat().GetID()
+	if chatID != config.ValueOf.AdminUserID {
+		ctx.Reply(u, "❌ You are not authorized to use this command.", nil)
+		return dispatcher.EndGroups
+	}
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) != 2 {
+		ctx.Reply(u, "Usage: /addworker <bot token>", nil)
+		return dispatcher.EndGroups
+	}
+	// the message holds a bot token, don't leave it in the chat history
+	if err := ctx.DeleteMessages(chatID, []int{u.EffectiveMessage.ID}); err != nil {
+		m.log.Sugar().Warnf("Failed to delete /addworker message: %v", err)
+	}
+	ctx.SendMessage(chatID, newMessageRequest(ctx, chatID, "🔄 Starting worker..."))
+	worker, err := bot.Workers.Add(ctx, args[1])
+	if errors.Is(err, bot.ErrWorkerNotAdmin) {
+		ctx.SendMessage(chatID, newMessageRequest(ctx, chatID, fmt.Sprintf("⚠️ Worker %d (@%s) started, but: %s\nAdd it to the log channel as an admin by hand.", worker.ID, worker.Self.Username, err.Error())))
+		return dispatcher.EndGroups
+	}
+	if err != nil {
+		m.log.Sugar().Errorf("Failed to add worker: %v", err)
+		ctx.SendMessage(chatID, newMessageRequest(ctx, chatID, fmt.Sprintf("❌ Failed to start worker: %s", err.Error())))
+		return dispatcher.EndGroups
+	}
+	ctx.SendMessage(chatID, newMessageRequest(ctx, chatID, fmt.Sprintf("✅ Worker %d (@%s) started.", worker.ID, worker.Self.Username)))
+	return dispatcher.EndGroups
+}
+
+func (m *command) removeWorkerHandler(ctx *ext.Context, u *ext.Update) error {
+	if u.EffectiveChat().GetID() != config.ValueOf.AdminUserID {
+		ctx.Reply(u, "❌ You are not authorized to use this command.", nil)
+		return dispatcher.EndGroups
+	}
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) != 2 {
+		ctx.Reply(u, "Usage: /removeworker <worker id>", nil)
+		return dispatcher.EndGroups
+	}
+	id, err := strconv.Atoi(args[1])
+	if err != nil {
+		ctx.Reply(u, "❌ Worker ID must be a number, see /workers.", nil)
+		return dispatcher.EndGroups
+	}
+	worker, err := bot.Workers.Remove(id)
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("❌ %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	ctx.Reply(u, fmt.Sprintf("✅ Worker %d (@%s) removed. It will stop after %d running stream(s) finish.", worker.ID, worker.Self.Username, worker.Stats().InFlight), nil)
+	return dispatcher.EndGroups
+}
+
+func formatWorkerInfo(info types.WorkerInfo) string {
+	status := "🟢 ok"
+	switch info.Status {
+	case "flood-wait":
+		status = fmt.Sprintf("🟡 flood wait for %s", time.Until(*info.AvailableAt).Truncate(time.Second))
+	case "quarantined":
+		status = fmt.Sprintf("🔴 quarantined for %s", time.Until(*info.AvailableAt).Truncate(time.Second))
+	}
+	return fmt.Sprintf(
+		"#%d @%s\n%s | streams: %d | errors: %.0f%% | latency: %dms\n",
+		info.ID,
+		info.Username,
+		status,
+		info.InFlight,
+		info.ErrorRate*100,
+		info.LatencyMs,
+	)
+}
+
+func newMessageRequest(ctx *ext.Context, chatID int64, text string) *tg.MessagesSendMessageRequest {
+	return &tg.MessagesSendMessageRequest{
+		Peer:    ctx.PeerStorage.GetInputPeerById(chatID),
+		Message: text,
+	}
+}
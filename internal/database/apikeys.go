@@ -0,0 +1,88 @@
+package database
+
+import (
+        "errors"
+        "time"
+
+        "go.uber.org/zap"
+)
+
+// APIKey grants access to the REST API. Only a hash of the key is stored.
+type APIKey struct {
+        ID         uint       `gorm:"primaryKey" json:"id"`
+        Name       string     `gorm:"size:255;not null" json:"name"`
+        KeyHash    string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+        Prefix     string     `gorm:"size:16" json:"prefix"`   // start of the key, to tell keys apart
+        UserID     int64      `gorm:"not null" json:"user_id"` // owner of the files linked through the key
+        LastUsedAt *time.Time `json:"last_used_at"`
+        CreatedAt  time.Time  `json:"created_at"`
+}
+
+// ErrAPIKeyNotFound is returned when no API key matches
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+// AddAPIKey stores a new API key
+func (db *Database) AddAPIKey(key *APIKey) error {
+        err := db.db.Create(key).Error
+        if err != nil {
+                db.log.Error("Failed to add API key", zap.Error(err), zap.String("name", key.Name))
+                return err
+        }
+
+        db.log.Info("API key added", zap.Uint("id", key.ID), zap.String("name", key.Name))
+        return nil
+}
+
+// GetAPIKeyByHash returns the API key with the given hash
+func (db *Database) GetAPIKeyByHash(keyHash string) (*APIKey, error) {
+        // checked for every API request, so don't use First which logs missing records
+        var keys []APIKey
+        err := db.db.Where("key_hash = ?", keyHash).Limit(1).Find(&keys).Error
+        if err != nil {
+                db.log.Error("Failed to get API key", zap.Error(err))
+                return nil, err
+        }
+        if len(keys) == 0 {
+                return nil, ErrAPIKeyNotFound
+        }
+
+        return &keys[0], nil
+}
+
+// GetAPIKeys returns all API keys, oldest first
+func (db *Database) GetAPIKeys() ([]APIKey, error) {
+        var keys []APIKey
+        err := db.db.Order("id").Find(&keys).Error
+        if err != nil {
+                db.log.Error("Failed to get API keys", zap.Error(err))
+                return nil, err
+        }
+
+        return keys, nil
+}
+
+// TouchAPIKey records that an API key was used
+func (db *Database) TouchAPIKey(id uint, usedAt time.Time) error {
+        err := db.db.Model(&APIKey{}).Where("id = ?", id).Update("last_used_at", usedAt).Error
+        if err != nil {
+                db.log.Error("Failed to update API key", zap.Error(err), zap.Uint("id", id))
+                return err
+        }
+
+        return nil
+}
+
+// DeleteAPIKey removes an API key, which stops working right away
+func (db *Database) DeleteAPIKey(id uint) error {
+        result := db.db.Where("id = ?", id).Delete(&APIKey{})
+        if result.Error != nil {
+                db.log.Error("Failed to delete API key", zap.Error(result.Error), zap.Uint("id", id))
+                return result.Error
+        }
+        if result.RowsAffected == 0 {
+                return ErrAPIKeyNotFound
+        }
+
+        db.log.Info("API key deleted", zap.Uint("id", id))
+        return nil
+}
@@ -40,7 +40,7 @@ func Connect(log *zap.Logger) error {
         }
 
         // Auto-migrate the schema
-        if err := db.AutoMigrate(&User{}); err != nil {
+        if err := db.AutoMigrate(&User{}, &File{}, &Revocation{}, &LinkGeneration{}, &UserQuota{}, &UserTier{}, &APIKey{}); err != nil {
                 log.Error("Failed to migrate database", zap.Error(err))
                 return err
         }
@@ -141,10 +141,18 @@ func (db *Database) ExportData() ([]byte, error) {
                 return nil, err
         }
 
+        files, err := db.GetAllFiles()
+        if err != nil {
+                db.log.Error("Failed to get files for export", zap.Error(err))
+                return nil, err
+        }
+
         exportData := map[string]interface{}{
                 "exported_at": time.Now().UTC(),
                 "total_users": len(users),
                 "users": users,
+                "total_files": len(files),
+                "files": files,
         }
 
         jsonData, err := json.MarshalIndent(exportData, "", "  ")
@@ -153,6 +161,6 @@ func (db *Database) ExportData() ([]byte, error) {
                 return nil, err
         }
 
-        db.log.Info("Database exported successfully", zap.Int("user_count", len(users)))
+        db.log.Info("Database exported successfully", zap.Int("user_count", len(users)), zap.Int("file_count", len(files)))
         return jsonData, nil
 }
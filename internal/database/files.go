@@ -0,0 +1,153 @@
+package database
+
+import (
+        "errors"
+        "time"
+
+        "go.uber.org/zap"
+)
+
+// File represents a file a user generated a link for
+type File struct {
+        ID           uint      `gorm:"primaryKey" json:"-"`
+        MessageID    int       `gorm:"uniqueIndex;not null" json:"message_id"`
+        UserID       int64     `gorm:"index;not null" json:"user_id"`
+        FileName     string    `gorm:"size:255" json:"file_name"`
+        FileSize     int64     `json:"file_size"`
+        MimeType     string    `gorm:"size:255" json:"mime_type"`
+        FileID       int64     `gorm:"index" json:"file_id"`
+        Hash         string    `gorm:"size:64" json:"hash"`
+        PasswordHash string    `gorm:"size:72" json:"-"`                        // bcrypt, empty if the links aren't password protected
+        MaxDownloads int64     `gorm:"not null;default:0" json:"max_downloads"` // 0 for unlimited
+        MaxBytes     int64     `gorm:"not null;default:0" json:"max_bytes"`     // 0 for unlimited
+        BytesServed  int64     `gorm:"not null;default:0" json:"bytes_served"`
+        CreatedAt    time.Time `gorm:"index" json:"created_at"`
+}
+
+// ErrFileNotFound is returned when no file is stored for a message
+var ErrFileNotFound = errors.New("file not found")
+
+// AddFile records a file forwarded to the log channel
+func (db *Database) AddFile(file *File) error {
+        err := db.db.Create(file).Error
+        if err != nil {
+                db.log.Error("Failed to add file", zap.Error(err), zap.Int("message_id", file.MessageID))
+                return err
+        }
+
+        return nil
+}
+
+// GetFileByMessageID returns the file stored for a log channel message
+func (db *Database) GetFileByMessageID(messageID int) (*File, error) {
+        // checked for every stream and HLS segment, also of files without a
+        // record, so don't use First which logs missing records
+        var files []File
+        err := db.db.Where("message_id = ?", messageID).Limit(1).Find(&files).Error
+        if err != nil {
+                db.log.Error("Failed to get file", zap.Error(err), zap.Int("message_id", messageID))
+                return nil, err
+        }
+        if len(files) == 0 {
+                return nil, ErrFileNotFound
+        }
+
+        return &files[0], nil
+}
+
+// GetUserFiles returns a page of the files of a user, newest first
+func (db *Database) GetUserFiles(userID int64, offset int, limit int) ([]File, error) {
+        var files []File
+        err := db.db.Where("user_id = ?", userID).Order("created_at DESC, id DESC").Offset(offset).Limit(limit).Find(&files).Error
+        if err != nil {
+                db.log.Error("Failed to get user files", zap.Error(err), zap.Int64("user_id", userID))
+                return nil, err
+        }
+
+        return files, nil
+}
+
+// CountUserFiles returns the number of files of a user
+func (db *Database) CountUserFiles(userID int64) (int64, error) {
+        var count int64
+        err := db.db.Model(&File{}).Where("user_id = ?", userID).Count(&count).Error
+        if err != nil {
+                db.log.Error("Failed to count user files", zap.Error(err), zap.Int64("user_id", userID))
+                return 0, err
+        }
+
+        return count, nil
+}
+
+// SearchFiles returns the newest files whose name contains query
+func (db *Database) SearchFiles(query string, limit int) ([]File, error) {
+        var files []File
+        err := db.db.Where("file_name LIKE ?", "%"+query+"%").Order("created_at DESC, id DESC").Limit(limit).Find(&files).Error
+        if err != nil {
+                db.log.Error("Failed to search files", zap.Error(err), zap.String("query", query))
+                return nil, err
+        }
+
+        return files, nil
+}
+
+// GetFilesBetween returns the files created in [from, to), for auditing
+func (db *Database) GetFilesBetween(from time.Time, to time.Time) ([]File, error) {
+        var files []File
+        err := db.db.Where("created_at >= ? AND created_at < ?", from, to).Order("created_at").Find(&files).Error
+        if err != nil {
+                db.log.Error("Failed to get files", zap.Error(err), zap.Time("from", from), zap.Time("to", to))
+                return nil, err
+        }
+
+        return files, nil
+}
+
+// GetTotalFileCount returns the total number of files
+func (db *Database) GetTotalFileCount() (int64, error) {
+        var count int64
+        err := db.db.Model(&File{}).Count(&count).Error
+        if err != nil {
+                db.log.Error("Failed to get total file count", zap.Error(err))
+                return 0, err
+        }
+
+        return count, nil
+}
+
+// GetAllFiles returns all files (for admin purposes)
+func (db *Database) GetAllFiles() ([]File, error) {
+        var files []File
+        err := db.db.Find(&files).Error
+        if err != nil {
+                db.log.Error("Failed to get all files", zap.Error(err))
+                return nil, err
+        }
+
+        return files, nil
+}
+
+// SetFilePassword sets the password hash of a file, an empty hash removes the password
+func (db *Database) SetFilePassword(messageID int, passwordHash string) error {
+        res := db.db.Model(&File{}).Where("message_id = ?", messageID).Update("password_hash", passwordHash)
+        if res.Error != nil {
+                db.log.Error("Failed to set file password", zap.Error(res.Error), zap.Int("message_id", messageID))
+                return res.Error
+        }
+        if res.RowsAffected == 0 {
+                return ErrFileNotFound
+        }
+
+        return nil
+}
+
+// DeleteFile removes the file stored for a log channel message
+func (db *Database) DeleteFile(messageID int) error {
+        err := db.db.Where("message_id = ?", messageID).Delete(&File{}).Error
+        if err != nil {
+                db.log.Error("Failed to delete file", zap.Error(err), zap.Int("message_id", messageID))
+                return err
+        }
+
+        return nil
+}
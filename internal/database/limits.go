@@ -0,0 +1,87 @@
+package database
+
+import (
+        "errors"
+
+        "go.uber.org/zap"
+        "gorm.io/gorm"
+)
+
+var (
+        // ErrDownloadLimitReached is returned once a link served its maximum number of downloads
+        ErrDownloadLimitReached = errors.New("the download limit of this link has been reached")
+        // ErrByteLimitReached is returned once a link served its maximum number of bytes
+        ErrByteLimitReached = errors.New("the bandwidth limit of this link has been reached")
+        // ErrLimitExceeded is returned when a request asks for more bytes than a link has left
+        ErrLimitExceeded = errors.New("the requested range is larger than what is left of the limit of this link")
+)
+
+// Downloads are counted in bytes so that range requests (seeking in a video)
+// don't count as separate downloads: a link limited to n downloads can serve
+// n times the size of its file.
+
+// SetFileLimits sets the download and byte limits of a file, 0 means unlimited
+func (db *Database) SetFileLimits(messageID int, maxDownloads int64, maxBytes int64) error {
+        res := db.db.Model(&File{}).Where("message_id = ?", messageID).Updates(map[string]interface{}{
+                "max_downloads": maxDownloads,
+                "max_bytes":     maxBytes,
+        })
+        if res.Error != nil {
+                db.log.Error("Failed to set file limits", zap.Error(res.Error), zap.Int("message_id", messageID))
+                return res.Error
+        }
+        if res.RowsAffected == 0 {
+                return ErrFileNotFound
+        }
+
+        return nil
+}
+
+// ReserveBytes counts n bytes as served before they are sent, failing if that
+// would go over the limits of the file. Files without a record are unlimited.
+func (db *Database) ReserveBytes(messageID int, n int64) error {
+        res := db.db.Model(&File{}).
+                Where("message_id = ?", messageID).
+                Where("max_bytes = 0 OR bytes_served + ? <= max_bytes", n).
+                Where("max_downloads = 0 OR bytes_served + ? <= max_downloads * file_size", n).
+                Update("bytes_served", gorm.Expr("bytes_served + ?", n))
+        if res.Error != nil {
+                db.log.Error("Failed to reserve bytes", zap.Error(res.Error), zap.Int("message_id", messageID))
+                return res.Error
+        }
+        if res.RowsAffected == 1 {
+                return nil
+        }
+
+        file, err := db.GetFileByMessageID(messageID)
+        if errors.Is(err, ErrFileNotFound) {
+                return nil
+        }
+        if err != nil {
+                return err
+        }
+        switch {
+        case file.MaxDownloads > 0 && file.BytesServed >= file.MaxDownloads*file.FileSize:
+                return ErrDownloadLimitReached
+        case file.MaxBytes > 0 && file.BytesServed >= file.MaxBytes:
+                return ErrByteLimitReached
+        default:
+                return ErrLimitExceeded
+        }
+}
+
+// ReleaseBytes gives back reserved bytes that were not sent, e.g. because the client disconnected
+func (db *Database) ReleaseBytes(messageID int, n int64) error {
+        if n <= 0 {
+                return nil
+        }
+        err := db.db.Model(&File{}).
+                Where("message_id = ?", messageID).
+                Update("bytes_served", gorm.Expr("MAX(bytes_served - ?, 0)", n)).Error
+        if err != nil {
+                db.log.Error("Failed to release bytes", zap.Error(err), zap.Int("message_id", messageID))
+                return err
+        }
+
+        return nil
+}
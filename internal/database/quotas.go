@@ -0,0 +1,113 @@
+package database
+
+import (
+        "time"
+
+        "go.uber.org/zap"
+        "gorm.io/gorm/clause"
+)
+
+// LinkGeneration records a link generated by a user, for enforcing quotas
+type LinkGeneration struct {
+        ID        uint      `gorm:"primaryKey" json:"-"`
+        UserID    int64     `gorm:"index;not null" json:"user_id"`
+        FileSize  int64     `json:"file_size"`
+        CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// UserQuota overrides the default quotas for a user. A nil field uses the
+// default and 0 means unlimited.
+type UserQuota struct {
+        UserID       int64     `gorm:"primaryKey;autoIncrement:false" json:"user_id"`
+        FilesPerHour *int      `json:"files_per_hour"`
+        FilesPerDay  *int      `json:"files_per_day"`
+        BytesPerDay  *int64    `json:"bytes_per_day"`
+        UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// quotas only look at the last day, older generations are pruned
+const linkGenerationRetention = 48 * time.Hour
+
+// AddLinkGeneration records that a user generated a link for a file of the given size
+func (db *Database) AddLinkGeneration(userID int64, fileSize int64) error {
+        _, err := db.ReserveLinkGeneration(userID, fileSize)
+        return err
+}
+
+// ReserveLinkGeneration records a link generation before the link exists, e.g. for an upload in progress, and returns its ID for DeleteLinkGeneration
+func (db *Database) ReserveLinkGeneration(userID int64, fileSize int64) (uint, error) {
+        generation := &LinkGeneration{UserID: userID, FileSize: fileSize}
+        err := db.db.Create(generation).Error
+        if err != nil {
+                db.log.Error("Failed to add link generation", zap.Error(err), zap.Int64("user_id", userID))
+                return 0, err
+        }
+
+        err = db.db.Where("created_at < ?", time.Now().Add(-linkGenerationRetention)).Delete(&LinkGeneration{}).Error
+        if err != nil {
+                db.log.Warn("Failed to prune link generations", zap.Error(err))
+        }
+
+        return generation.ID, nil
+}
+
+// DeleteLinkGeneration gives back a reserved link generation whose link was never created
+func (db *Database) DeleteLinkGeneration(id uint) error {
+        err := db.db.Delete(&LinkGeneration{}, id).Error
+        if err != nil {
+                db.log.Error("Failed to delete link generation", zap.Error(err), zap.Uint("id", id))
+                return err
+        }
+
+        return nil
+}
+
+// GetLinkGenerations returns the links a user generated since the given time, oldest first
+func (db *Database) GetLinkGenerations(userID int64, since time.Time) ([]LinkGeneration, error) {
+        var generations []LinkGeneration
+        err := db.db.Where("user_id = ? AND created_at >= ?", userID, since).Order("created_at").Find(&generations).Error
+        if err != nil {
+                db.log.Error("Failed to get link generations", zap.Error(err), zap.Int64("user_id", userID))
+                return nil, err
+        }
+
+        return generations, nil
+}
+
+// GetUserQuota returns the quota override of a user, or nil if there is none
+func (db *Database) GetUserQuota(userID int64) (*UserQuota, error) {
+        // checked for every link, so don't use First which logs missing records
+        var quotas []UserQuota
+        err := db.db.Where("user_id = ?", userID).Limit(1).Find(&quotas).Error
+        if err != nil {
+                db.log.Error("Failed to get user quota", zap.Error(err), zap.Int64("user_id", userID))
+                return nil, err
+        }
+        if len(quotas) == 0 {
+                return nil, nil
+        }
+
+        return &quotas[0], nil
+}
+
+// SetUserQuota creates or replaces the quota override of a user
+func (db *Database) SetUserQuota(quota *UserQuota) error {
+        err := db.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(quota).Error
+        if err != nil {
+                db.log.Error("Failed to set user quota", zap.Error(err), zap.Int64("user_id", quota.UserID))
+                return err
+        }
+
+        return nil
+}
+
+// DeleteUserQuota removes the quota override of a user
+func (db *Database) DeleteUserQuota(userID int64) error {
+        err := db.db.Where("user_id = ?", userID).Delete(&UserQuota{}).Error
+        if err != nil {
+                db.log.Error("Failed to delete user quota", zap.Error(err), zap.Int64("user_id", userID))
+                return err
+        }
+
+        return nil
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+        "time"
+
+        "go.uber.org/zap"
+        "gorm.io/gorm/clause"
+)
+
+// Revocation marks a log channel message whose links were revoked
+type Revocation struct {
+        ID        uint      `gorm:"primaryKey" json:"-"`
+        MessageID int       `gorm:"uniqueIndex;not null" json:"message_id"`
+        FileName  string    `gorm:"size:255" json:"file_name"`
+        RevokedBy int64     `gorm:"not null" json:"revoked_by"`
+        CreatedAt time.Time `json:"created_at"`
+}
+
+// AddRevocation marks the links of a log channel message as revoked
+func (db *Database) AddRevocation(messageID int, fileName string, revokedBy int64) error {
+        revocation := Revocation{
+                MessageID: messageID,
+                FileName:  fileName,
+                RevokedBy: revokedBy,
+        }
+
+        err := db.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&revocation).Error
+        if err != nil {
+                db.log.Error("Failed to add revocation", zap.Error(err), zap.Int("message_id", messageID))
+                return err
+        }
+
+        db.log.Info("Links revoked", zap.Int("message_id", messageID), zap.Int64("revoked_by", revokedBy))
+        return nil
+}
+
+// IsRevoked checks if the links of a log channel message were revoked
+func (db *Database) IsRevoked(messageID int) (bool, error) {
+        var count int64
+        err := db.db.Model(&Revocation{}).Where("message_id = ?", messageID).Count(&count).Error
+        if err != nil {
+                db.log.Error("Failed to check revocation", zap.Error(err), zap.Int("message_id", messageID))
+                return false, err
+        }
+
+        return count > 0, nil
+}
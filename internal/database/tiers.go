@@ -0,0 +1,53 @@
+package database
+
+import (
+        "time"
+
+        "go.uber.org/zap"
+        "gorm.io/gorm/clause"
+)
+
+// UserTier assigns a user to a bandwidth tier for the links they generated
+type UserTier struct {
+        UserID    int64     `gorm:"primaryKey;autoIncrement:false" json:"user_id"`
+        Tier      string    `gorm:"size:64;not null" json:"tier"`
+        UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GetUserTier returns the bandwidth tier of a user, or "" if none was assigned
+func (db *Database) GetUserTier(userID int64) (string, error) {
+        // checked for every stream, so don't use First which logs missing records
+        var tiers []UserTier
+        err := db.db.Where("user_id = ?", userID).Limit(1).Find(&tiers).Error
+        if err != nil {
+                db.log.Error("Failed to get user tier", zap.Error(err), zap.Int64("user_id", userID))
+                return "", err
+        }
+        if len(tiers) == 0 {
+                return "", nil
+        }
+
+        return tiers[0].Tier, nil
+}
+
+// SetUserTier assigns a user to a bandwidth tier
+func (db *Database) SetUserTier(userID int64, tier string) error {
+        err := db.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&UserTier{UserID: userID, Tier: tier}).Error
+        if err != nil {
+                db.log.Error("Failed to set user tier", zap.Error(err), zap.Int64("user_id", userID))
+                return err
+        }
+
+        return nil
+}
+
+// DeleteUserTier puts a user back in the default bandwidth tier
+func (db *Database) DeleteUserTier(userID int64) error {
+        err := db.db.Where("user_id = ?", userID).Delete(&UserTier{}).Error
+        if err != nil {
+                db.log.Error("Failed to delete user tier", zap.Error(err), zap.Int64("user_id", userID))
+                return err
+        }
+
+        return nil
+}
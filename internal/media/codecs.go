@@ -0,0 +1,157 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// parseAVCC reads the NAL unit length size and the parameter sets of an
+// AVCDecoderConfigurationRecord, the codec private data of H.264 tracks in
+// both MP4 and Matroska.
+func (t *Track) parseAVCC(b []byte) error {
+	if len(b) < 7 || b[0] != 1 {
+		return fmt.Errorf("%w: invalid avcC", ErrUnsupported)
+	}
+	t.nalLength = int(b[4]&0x03) + 1
+	count := int(b[5] & 0x1F)
+	b = b[6:]
+	for set := 0; set < 2; set++ {
+		for i := 0; i < count; i++ {
+			if len(b) < 2 {
+				return fmt.Errorf("%w: invalid avcC", ErrUnsupported)
+			}
+			n := int(binary.BigEndian.Uint16(b))
+			if len(b) < 2+n {
+				return fmt.Errorf("%w: invalid avcC", ErrUnsupported)
+			}
+			// copied so that the container index can be freed
+			if set == 0 {
+				t.sps = append(t.sps, bytes.Clone(b[2:2+n]))
+			} else {
+				t.pps = append(t.pps, bytes.Clone(b[2:2+n]))
+			}
+			b = b[2+n:]
+		}
+		if set == 0 {
+			if len(b) < 1 {
+				return fmt.Errorf("%w: invalid avcC", ErrUnsupported)
+			}
+			count = int(b[0])
+			b = b[1:]
+		}
+	}
+	return nil
+}
+
+// annexB converts a length prefixed H.264 access unit to the start code
+// format of MPEG-TS, starting it with an access unit delimiter and, for
+// keyframes, the parameter sets.
+func (t *Track) annexB(s Sample) []byte {
+	startCode := []byte{0, 0, 0, 1}
+	out := make([]byte, 0, len(s.Data)+64)
+	out = append(out, 0, 0, 0, 1, 0x09, 0xF0)
+	if s.Keyframe {
+		for _, sets := range [][][]byte{t.sps, t.pps} {
+			for _, ps := range sets {
+				out = append(out, startCode...)
+				out = append(out, ps...)
+			}
+		}
+	}
+	data := s.Data
+	for len(data) > t.nalLength {
+		n := 0
+		for _, c := range data[:t.nalLength] {
+			n = n<<8 | int(c)
+		}
+		data = data[t.nalLength:]
+		if n == 0 || n > len(data) {
+			break
+		}
+		// the delimiter was written above
+		if data[0]&0x1F != 9 {
+			out = append(out, startCode...)
+			out = append(out, data[:n]...)
+		}
+		data = data[n:]
+	}
+	return out
+}
+
+// aacConfig holds the fields of an AudioSpecificConfig that go into ADTS
+// headers.
+type aacConfig struct {
+	profile   int // audio object type
+	freqIndex int
+	channels  int
+}
+
+var aacSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// aacFreqIndex returns the sampling frequency index of rate, or 4 (44.1kHz)
+// if the rate has none.
+func aacFreqIndex(rate int) int {
+	for i, r := range aacSampleRates {
+		if r == rate {
+			return i
+		}
+	}
+	return 4
+}
+
+// parseASC reads an AudioSpecificConfig. For HE-AAC the core AAC layer is
+// kept, as that is what ADTS headers describe.
+func parseASC(b []byte) (aacConfig, error) {
+	if len(b) < 2 {
+		return aacConfig{}, fmt.Errorf("%w: invalid AudioSpecificConfig", ErrUnsupported)
+	}
+	bits := uint64(0)
+	for i := 0; i < 8; i++ {
+		bits <<= 8
+		if i < len(b) {
+			bits |= uint64(b[i])
+		}
+	}
+	pos := 0
+	read := func(n int) int {
+		v := int(bits << pos >> (64 - n))
+		pos += n
+		return v
+	}
+	var c aacConfig
+	c.profile = read(5)
+	if c.profile == 31 {
+		c.profile = 32 + read(6)
+	}
+	c.freqIndex = read(4)
+	if c.freqIndex == 15 {
+		c.freqIndex = aacFreqIndex(read(24))
+	}
+	c.channels = read(4)
+	if c.profile == 5 || c.profile == 29 {
+		// explicit SBR signaling: extension frequency, then the core type
+		if read(4) == 15 {
+			read(24)
+		}
+		c.profile = read(5)
+	}
+	if c.profile < 1 || c.profile > 4 {
+		c.profile = 2
+	}
+	return c, nil
+}
+
+// adts prepends an ADTS header to a raw AAC frame.
+func (c aacConfig) adts(frame []byte) []byte {
+	n := len(frame) + 7
+	out := make([]byte, 7, n)
+	out[0] = 0xFF
+	out[1] = 0xF1
+	out[2] = byte((c.profile-1)<<6 | c.freqIndex<<2 | c.channels>>2&1)
+	out[3] = byte(c.channels&3<<6 | n>>11&3)
+	out[4] = byte(n >> 3)
+	out[5] = byte(n&7<<5 | 0x1F)
+	out[6] = 0xFC
+	return append(out, frame...)
+}
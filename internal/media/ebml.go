@@ -0,0 +1,119 @@
+package media
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/bits"
+)
+
+var errInvalidVint = errors.New("invalid EBML variable length integer")
+
+// unknownSize is returned as the size of elements whose size isn't written,
+// which Matroska allows for Segment and Cluster.
+const unknownSize = -1
+
+// vint decodes the EBML variable length integer at the start of b and returns
+// it with its length. Element IDs keep their length marker, sizes don't.
+func vint(b []byte, keepMarker bool) (uint64, int, error) {
+	if len(b) == 0 {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	n := bits.LeadingZeros8(b[0]) + 1
+	if n > 8 {
+		return 0, 0, errInvalidVint
+	}
+	if len(b) < n {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	v := uint64(b[0])
+	if !keepMarker {
+		v &= 0xFF >> n
+	}
+	for i := 1; i < n; i++ {
+		v = v<<8 | uint64(b[i])
+	}
+	return v, n, nil
+}
+
+// elementHeader decodes the ID and size of the element at the start of b.
+func elementHeader(b []byte) (id uint64, size int64, n int, err error) {
+	id, idLen, err := vint(b, true)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	v, sizeLen, err := vint(b[idLen:], false)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	size = int64(v)
+	if v == 1<<(7*sizeLen)-1 || v > math.MaxInt64/2 {
+		size = unknownSize
+	}
+	return id, size, idLen + sizeLen, nil
+}
+
+// elementHeaderAt reads the header of the element at off in r.
+func elementHeaderAt(r io.ReaderAt, off int64) (id uint64, size int64, n int, err error) {
+	var buf [12]byte
+	read, err := r.ReadAt(buf[:], off)
+	if read == 0 && err != nil {
+		return 0, 0, 0, err
+	}
+	return elementHeader(buf[:read])
+}
+
+// readElementHeader reads the header of the next element in r and returns
+// its length along with the ID and size.
+func readElementHeader(r *bufio.Reader) (id uint64, size int64, n int, err error) {
+	// a header is at most 12 bytes, so peeking less only happens at EOF
+	b, err := r.Peek(12)
+	if len(b) == 0 {
+		return 0, 0, 0, err
+	}
+	id, size, n, err = elementHeader(b)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	r.Discard(n)
+	return id, size, n, nil
+}
+
+// eachElement calls fn with the ID and body of every element in b. An element
+// cut off by the end of b ends the iteration.
+func eachElement(b []byte, fn func(id uint64, body []byte) error) error {
+	for len(b) > 0 {
+		id, size, n, err := elementHeader(b)
+		if err != nil {
+			return err
+		}
+		if size == unknownSize || int64(len(b)-n) < size {
+			return nil
+		}
+		if err := fn(id, b[n:n+int(size)]); err != nil {
+			return err
+		}
+		b = b[n+int(size):]
+	}
+	return nil
+}
+
+func ebmlUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func ebmlFloat(b []byte) float64 {
+	switch len(b) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
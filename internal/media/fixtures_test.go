@@ -0,0 +1,367 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+// Builders of small MP4 and Matroska files for the tests. The frames hold
+// made up bytes, as nothing in this package decodes them.
+
+var (
+	testSPS = []byte{0x67, 0x64, 0x00, 0x1F, 0xAC, 0xD9}
+	testPPS = []byte{0x68, 0xEB, 0x8F}
+	// AAC LC, 44.1kHz, stereo
+	testASC = []byte{0x12, 0x10}
+)
+
+const (
+	testFrameMs      = 40 // 25 fps
+	testAudioRate    = 44100
+	testAudioFrameMs = 1024 * 1000.0 / testAudioRate
+)
+
+func testAVCC() []byte {
+	b := []byte{1, 0x64, 0x00, 0x1F, 0xFF, 0xE1}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(testSPS)))
+	b = append(b, testSPS...)
+	b = append(b, 1)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(testPPS)))
+	return append(b, testPPS...)
+}
+
+// testVideoFrame returns frame n as a length prefixed H.264 access unit.
+func testVideoFrame(n int, keyframe bool) []byte {
+	nal := []byte{0x41, byte(n), byte(n >> 8)}
+	if keyframe {
+		nal[0] = 0x65
+	}
+	nal = append(nal, bytes.Repeat([]byte{0xAB}, 16)...)
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(nal))), nal...)
+}
+
+func testAudioFrame(n int) []byte {
+	return append([]byte{0x21, byte(n), byte(n >> 8)}, bytes.Repeat([]byte{0xCD}, 8)...)
+}
+
+// testMedia describes a file with a 25 fps H.264 track that has a keyframe
+// every keyframeInterval frames and, with audio, an AAC track of the same
+// length.
+type testMedia struct {
+	frames           int
+	keyframeInterval int
+	audio            bool
+}
+
+func (tm testMedia) audioFrames() int {
+	if !tm.audio {
+		return 0
+	}
+	return int(float64(tm.frames*testFrameMs) / testAudioFrameMs)
+}
+
+// mp4 builds an MP4 file with the moov box before or after the media data.
+// Every sample is a chunk of its own.
+func (tm testMedia) mp4(moovFirst bool) []byte {
+	var mdat []byte
+	var videoSizes, audioSizes []uint32
+	var keyframes []uint32
+	for i := 0; i < tm.frames; i++ {
+		keyframe := i%tm.keyframeInterval == 0
+		if keyframe {
+			keyframes = append(keyframes, uint32(i+1))
+		}
+		frame := testVideoFrame(i, keyframe)
+		videoSizes = append(videoSizes, uint32(len(frame)))
+		mdat = append(mdat, frame...)
+	}
+	for i := 0; i < tm.audioFrames(); i++ {
+		frame := testAudioFrame(i)
+		audioSizes = append(audioSizes, uint32(len(frame)))
+		mdat = append(mdat, frame...)
+	}
+	ftyp := box("ftyp", []byte("isom"), u32s(0x200), []byte("isomavc1"))
+
+	moov := func(base uint32) []byte {
+		offsets := func(sizes []uint32) []uint32 {
+			var offsets []uint32
+			for _, size := range sizes {
+				offsets = append(offsets, base)
+				base += size
+			}
+			return offsets
+		}
+		videoOffsets := offsets(videoSizes)
+		audioOffsets := offsets(audioSizes)
+		avc1 := make([]byte, 78)
+		binary.BigEndian.PutUint16(avc1[24:], 640)
+		binary.BigEndian.PutUint16(avc1[26:], 360)
+		tracks := [][]byte{mp4Trak(1, "vide", 12800, 512,
+			box("avc1", avc1, box("avcC", testAVCC())),
+			videoSizes, videoOffsets, keyframes)}
+		if tm.audio {
+			mp4a := make([]byte, 28)
+			binary.BigEndian.PutUint16(mp4a[16:], 2)
+			binary.BigEndian.PutUint32(mp4a[24:], testAudioRate<<16)
+			decoderConfig := append([]byte{0x40, 0x15, 0, 0, 0}, make([]byte, 8)...)
+			decoderConfig = append(decoderConfig, descriptorBytes(0x05, testASC)...)
+			es := append([]byte{0, 1, 0}, descriptorBytes(0x04, decoderConfig)...)
+			esds := append(u32s(0), descriptorBytes(0x03, es)...)
+			tracks = append(tracks, mp4Trak(2, "soun", testAudioRate, 1024,
+				box("mp4a", mp4a, box("esds", esds)),
+				audioSizes, audioOffsets, nil))
+		}
+		return box("moov", append([][]byte{box("mvhd", u32s(0, 0, 0, 1000, uint32(tm.frames*testFrameMs), 0))}, tracks...)...)
+	}
+
+	if !moovFirst {
+		return bytes.Join([][]byte{ftyp, box("mdat", mdat), moov(uint32(len(ftyp) + 8))}, nil)
+	}
+	// stco entries have a fixed size, so the moov box is as long with any base
+	base := uint32(len(ftyp) + len(moov(0)) + 8)
+	return bytes.Join([][]byte{ftyp, moov(base), box("mdat", mdat)}, nil)
+}
+
+// mp4Trak builds a track whose samples all last delta. keyframes are
+// 1-based sample numbers, nil for a track without stss.
+func mp4Trak(id uint32, handler string, timescale, delta uint32, entry []byte, sizes, offsets, keyframes []uint32) []byte {
+	count := uint32(len(sizes))
+	stbl := [][]byte{
+		box("stsd", u32s(0, 1), entry),
+		box("stts", u32s(0, 1, count, delta)),
+		box("stsc", u32s(0, 1, 1, 1, 1)),
+		box("stsz", u32s(0, 0, count), u32s(sizes...)),
+		box("stco", u32s(0, count), u32s(offsets...)),
+	}
+	if keyframes != nil {
+		stbl = append(stbl, box("stss", u32s(0, uint32(len(keyframes))), u32s(keyframes...)))
+	}
+	return box("trak",
+		box("tkhd", u32s(0, 0, 0, id, 0, 0)),
+		box("mdia",
+			box("mdhd", u32s(0, 0, 0, timescale, count*delta, 0)),
+			box("hdlr", u32s(0, 0), []byte(handler), u32s(0, 0, 0), []byte{0}),
+			box("minf", box("stbl", stbl...)),
+		),
+	)
+}
+
+func box(typ string, parts ...[]byte) []byte {
+	body := bytes.Join(parts, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(b, typ...), body...)
+}
+
+func u32s(values ...uint32) []byte {
+	var b []byte
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+func descriptorBytes(tag byte, body []byte) []byte {
+	return append([]byte{tag, byte(len(body))}, body...)
+}
+
+// mkv builds a Matroska file with a cluster per keyframe and, with cues, a
+// cue point for every keyframe.
+func (tm testMedia) mkv(cues bool) []byte {
+	f := &mkvFile{
+		durationMs: float64(tm.frames * testFrameMs),
+		tracks:     []mkvTrack{{number: 1, typ: trackVideo, codec: "V_MPEG4/ISO/AVC", private: testAVCC()}},
+	}
+	if cues {
+		f.cueTracks = []uint64{1}
+	}
+	if tm.audio {
+		f.tracks = append(f.tracks, mkvTrack{number: 2, typ: trackAudio, codec: "A_AAC", private: testASC, sampleRate: testAudioRate, channels: 2})
+	}
+	audio := 0
+	for i := 0; i < tm.frames; i++ {
+		ms := int64(i * testFrameMs)
+		keyframe := i%tm.keyframeInterval == 0
+		if keyframe {
+			f.clusters = append(f.clusters, mkvCluster{timecode: ms})
+		}
+		c := &f.clusters[len(f.clusters)-1]
+		c.blocks = append(c.blocks, mkvBlock{track: 1, ms: ms, keyframe: keyframe, data: testVideoFrame(i, keyframe)})
+		// audio frames are interleaved with the video frame they start with
+		for ; audio < tm.audioFrames() && float64(audio)*testAudioFrameMs < float64(ms+testFrameMs); audio++ {
+			c.blocks = append(c.blocks, mkvBlock{track: 2, ms: int64(float64(audio) * testAudioFrameMs), keyframe: true, data: testAudioFrame(audio)})
+		}
+	}
+	return f.build()
+}
+
+type mkvFile struct {
+	durationMs float64
+	tracks     []mkvTrack
+	clusters   []mkvCluster
+	// cueTracks get a cue point for each of their keyframes
+	cueTracks []uint64
+	// relativeCues have a CueRelativePosition
+	relativeCues bool
+}
+
+type mkvTrack struct {
+	number, typ uint64
+	codec       string
+	language    string
+	private     []byte
+	sampleRate  float64
+	channels    uint64
+}
+
+type mkvCluster struct {
+	timecode int64 // ms
+	blocks   []mkvBlock
+}
+
+// mkvBlock is written as a SimpleBlock, or as a BlockGroup if it has a
+// duration.
+type mkvBlock struct {
+	track    uint64
+	ms       int64
+	duration int64 // ms
+	keyframe bool
+	data     []byte
+}
+
+// build writes the file with a seek head pointing at the cues, which come
+// after the clusters.
+func (f *mkvFile) build() []byte {
+	header := ebmlElement(idEBML, ebmlElement(idDocType, []byte("matroska")))
+	info := ebmlElement(idInfo,
+		ebmlElement(idTimecodeScale, ebmlUintBytes(1000000)),
+		ebmlElement(idDuration, binary.BigEndian.AppendUint64(nil, math.Float64bits(f.durationMs))),
+	)
+	var entries [][]byte
+	for _, t := range f.tracks {
+		entry := [][]byte{
+			ebmlElement(idTrackNumber, ebmlUintBytes(t.number)),
+			ebmlElement(idTrackType, ebmlUintBytes(t.typ)),
+			ebmlElement(idCodecID, []byte(t.codec)),
+		}
+		if t.language != "" {
+			entry = append(entry, ebmlElement(idLanguage, []byte(t.language)))
+		}
+		if t.private != nil {
+			entry = append(entry, ebmlElement(idCodecPrivate, t.private))
+		}
+		if t.sampleRate > 0 {
+			entry = append(entry, ebmlElement(idAudio,
+				ebmlElement(idSamplingFreq, binary.BigEndian.AppendUint64(nil, math.Float64bits(t.sampleRate))),
+				ebmlElement(idChannels, ebmlUintBytes(t.channels)),
+			))
+		}
+		entries = append(entries, ebmlElement(idTrackEntry, entry...))
+	}
+	tracks := ebmlElement(idTracks, entries...)
+
+	seekHead := func(cues int64) []byte {
+		return ebmlElement(idSeekHead, ebmlElement(idSeek,
+			ebmlElement(idSeekID, ebmlUintBytes(idCues)),
+			ebmlElement(idSeekPosition, ebmlUintBytes(uint64(cues))),
+		))
+	}
+	pos := int64(len(seekHead(0)) + len(info) + len(tracks))
+	var clusters, points []byte
+	for _, c := range f.clusters {
+		body := ebmlElement(idTimecode, ebmlUintBytes(uint64(c.timecode)))
+		for _, b := range c.blocks {
+			relative := len(body)
+			block := append([]byte{0x80 | byte(b.track)}, byte(uint16(b.ms-c.timecode)>>8), byte(b.ms-c.timecode))
+			if b.duration > 0 {
+				block = append(block, 0)
+				block = append(block, b.data...)
+				body = append(body, ebmlElement(idBlockGroup,
+					ebmlElement(idBlock, block),
+					ebmlElement(idBlockDuration, ebmlUintBytes(uint64(b.duration))),
+				)...)
+			} else {
+				flags := byte(0)
+				if b.keyframe {
+					flags = 0x80
+				}
+				block = append(block, flags)
+				block = append(block, b.data...)
+				body = append(body, ebmlElement(idSimpleBlock, block)...)
+			}
+			if !b.keyframe || !containsTrack(f.cueTracks, b.track) {
+				continue
+			}
+			positions := [][]byte{
+				ebmlElement(idCueTrack, ebmlUintBytes(b.track)),
+				ebmlElement(idCueClusterPos, ebmlUintBytes(uint64(pos+int64(len(clusters))))),
+			}
+			if f.relativeCues {
+				positions = append(positions, ebmlElement(idCueRelPos, ebmlUintBytes(uint64(relative))))
+			}
+			points = append(points, ebmlElement(idCuePoint,
+				ebmlElement(idCueTime, ebmlUintBytes(uint64(b.ms))),
+				ebmlElement(idCuePositions, positions...),
+			)...)
+		}
+		clusters = append(clusters, ebmlElement(idCluster, body)...)
+	}
+	segment := [][]byte{seekHead(pos + int64(len(clusters))), info, tracks, clusters}
+	if points != nil {
+		segment = append(segment, ebmlElement(idCues, points))
+	}
+	return append(header, ebmlElement(idSegment, segment...)...)
+}
+
+func containsTrack(tracks []uint64, track uint64) bool {
+	for _, t := range tracks {
+		if t == track {
+			return true
+		}
+	}
+	return false
+}
+
+// ebmlElement writes an element with an 8 byte size, so that its length
+// doesn't depend on the values in it.
+func ebmlElement(id uint64, parts ...[]byte) []byte {
+	var b []byte
+	for shift := 24; shift > 0; shift -= 8 {
+		if id>>shift != 0 {
+			b = append(b, byte(id>>shift))
+		}
+	}
+	b = append(b, byte(id))
+	body := bytes.Join(parts, nil)
+	b = binary.BigEndian.AppendUint64(b, uint64(len(body)))
+	b[len(b)-8] = 0x01
+	return append(b, body...)
+}
+
+func ebmlUintBytes(v uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, v)
+}
+
+// rangeOpener serves byte ranges of data and counts the reads and the bytes
+// of the ranges opened.
+type rangeOpener struct {
+	data   []byte
+	mu     sync.Mutex
+	reads  int
+	opened int64
+}
+
+func (o *rangeOpener) open(start, end int64) (io.ReadCloser, error) {
+	if start < 0 || start > end || start >= int64(len(o.data)) {
+		return nil, fmt.Errorf("range %d-%d out of %d bytes", start, end, len(o.data))
+	}
+	end = min(end, int64(len(o.data))-1)
+	o.mu.Lock()
+	o.reads++
+	o.opened += end - start + 1
+	o.mu.Unlock()
+	return io.NopCloser(bytes.NewReader(o.data[start : end+1])), nil
+}
@@ -0,0 +1,219 @@
+// Package media reads the index of MP4 and Matroska files and remuxes slices
+// of them into MPEG-TS segments for HLS, without calling out to ffmpeg.
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	CodecH264 = "h264"
+	CodecAAC  = "aac"
+	CodecMP3  = "mp3"
+)
+
+// Timescale is the clock of all timestamps in this package, the 90kHz clock
+// of MPEG-TS.
+const Timescale = 90000
+
+// SegmentDuration is the minimum duration of a segment. Segments are cut at
+// the first keyframe after it.
+const SegmentDuration = 6 * Timescale
+
+// maxSegmentSize bounds the samples of a segment, which are held in memory
+// while it is remuxed.
+const maxSegmentSize = 64 * 1024 * 1024
+
+var (
+	// ErrUnsupported is returned for files whose container or codecs can't
+	// be remuxed.
+	ErrUnsupported = errors.New("unsupported media")
+	// ErrNoSegment is returned for segment numbers out of range.
+	ErrNoSegment = errors.New("no such segment")
+	// ErrNoTrack is returned for track numbers that aren't in the file.
+	ErrNoTrack = errors.New("no such track")
+
+	errSegmentSize = fmt.Errorf("%w: segment larger than %d MiB", ErrUnsupported, maxSegmentSize>>20)
+)
+
+// Opener returns a reader for the byte range [start, end] of the file.
+type Opener func(start, end int64) (io.ReadCloser, error)
+
+// Track is an elementary stream that can be muxed into MPEG-TS.
+type Track struct {
+	Codec      string
+	Width      int
+	Height     int
+	SampleRate int
+	Channels   int
+
+	// h264
+	nalLength int
+	sps, pps  [][]byte
+	// aac
+	aac aacConfig
+}
+
+// Sample is one frame of a track. Timestamps are in Timescale units.
+type Sample struct {
+	PTS      int64
+	DTS      int64
+	Keyframe bool
+	Data     []byte
+}
+
+// Segment is a slice of the file starting at a video keyframe.
+type Segment struct {
+	Start int64
+	End   int64
+
+	// video samples [first, last) of an MP4 file
+	first, last int
+	// byte range [offset, limit) of the clusters of a Matroska file
+	offset, limit int64
+}
+
+// Duration returns the playing time of the segment.
+func (s Segment) Duration() time.Duration {
+	return time.Duration(s.End-s.Start) * time.Second / Timescale
+}
+
+// Index is what's needed to cut a file into segments: its tracks and where
+// every segment starts.
+type Index struct {
+	Container string
+	Duration  int64
+	Video     *Track
+	Audio     *Track // nil if the file has no audio track that can be muxed
+	Segments  []Segment
+	demuxer   demuxer
+}
+
+type demuxer interface {
+	// samples returns the video and audio samples of seg in decoding order.
+	samples(seg Segment, open Opener) (video, audio []Sample, err error)
+	// span returns how many bytes samples fetches for seg at most.
+	span(seg Segment) int64
+}
+
+// NewIndex reads the index of the MP4 or Matroska file in r.
+func NewIndex(r io.ReaderAt, size int64) (*Index, error) {
+	head := make([]byte, 8)
+	if _, err := r.ReadAt(head, 0); err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.Equal(head[:4], []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return indexMatroska(r, size)
+	case string(head[4:8]) == "ftyp":
+		return indexMP4(r, size)
+	}
+	return nil, fmt.Errorf("%w: unknown container", ErrUnsupported)
+}
+
+// WriteSegment remuxes segment n into MPEG-TS, fetching its samples with open.
+func (idx *Index) WriteSegment(w io.Writer, n int, open Opener) error {
+	s, err := idx.ReadSegment(n, open)
+	if err != nil {
+		return err
+	}
+	_, err = s.WriteTo(w)
+	return err
+}
+
+// SegmentSamples are the samples of a segment, ready to be remuxed.
+type SegmentSamples struct {
+	idx          *Index
+	video, audio []Sample
+}
+
+// ReadSegment fetches the samples of segment n with open.
+func (idx *Index) ReadSegment(n int, open Opener) (*SegmentSamples, error) {
+	if n < 0 || n >= len(idx.Segments) {
+		return nil, ErrNoSegment
+	}
+	video, audio, err := idx.demuxer.samples(idx.Segments[n], open)
+	if err != nil {
+		return nil, err
+	}
+	return &SegmentSamples{idx: idx, video: video, audio: audio}, nil
+}
+
+// SegmentSpan returns how many bytes of the file ReadSegment fetches for
+// segment n at most.
+func (idx *Index) SegmentSpan(n int) (int64, error) {
+	if n < 0 || n >= len(idx.Segments) {
+		return 0, ErrNoSegment
+	}
+	return idx.demuxer.span(idx.Segments[n]), nil
+}
+
+// Size returns the length of the MPEG-TS stream WriteTo writes, without
+// holding it in memory.
+func (s *SegmentSamples) Size() int64 {
+	n, _ := s.WriteTo(&countingWriter{})
+	return n
+}
+
+// WriteTo remuxes the samples into MPEG-TS.
+func (s *SegmentSamples) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	err := writeTS(cw, s.idx.Video, s.idx.Audio, s.video, s.audio)
+	return cw.n, err
+}
+
+// countingWriter counts the bytes written through it to w, or discards them
+// if w is nil.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	var err error
+	if c.w != nil {
+		n, err = c.w.Write(p)
+	}
+	c.n += int64(n)
+	return n, err
+}
+
+// Durations returns the duration of every segment.
+func (idx *Index) Durations() []time.Duration {
+	durations := make([]time.Duration, len(idx.Segments))
+	for i, segment := range idx.Segments {
+		durations[i] = segment.Duration()
+	}
+	return durations
+}
+
+// cutPoints returns the indexes of the keyframes segments start at, given the
+// sorted presentation times of all keyframes.
+func cutPoints(keyframes []int64) []int {
+	var cuts []int
+	for i, t := range keyframes {
+		if len(cuts) == 0 || t-keyframes[cuts[len(cuts)-1]] >= SegmentDuration {
+			cuts = append(cuts, i)
+		}
+	}
+	return cuts
+}
+
+// readRange reads the byte range [start, end) with open.
+func readRange(open Opener, start, end int64) ([]byte, error) {
+	rc, err := open(start, end-1)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	buf := make([]byte, end-start)
+	if _, err := io.ReadFull(rc, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,210 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// 12 seconds with a keyframe every 2 seconds, cut into two 6 second segments
+var (
+	avMedia    = testMedia{frames: 300, keyframeInterval: 50, audio: true}
+	videoMedia = testMedia{frames: 300, keyframeInterval: 50}
+)
+
+func TestNewIndex(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []byte
+		err       error
+		container string
+		audio     bool
+		segments  []int64 // start times in seconds
+		end       int64   // in seconds
+	}{
+		{name: "mp4", data: avMedia.mp4(false), container: "mp4", audio: true, segments: []int64{0, 6}, end: 12},
+		{name: "mp4 with moov first", data: avMedia.mp4(true), container: "mp4", audio: true, segments: []int64{0, 6}, end: 12},
+		{name: "mp4 without audio", data: videoMedia.mp4(false), container: "mp4", segments: []int64{0, 6}, end: 12},
+		{name: "mp4 with a keyframe every 7s", data: testMedia{frames: 525, keyframeInterval: 175}.mp4(false), container: "mp4", segments: []int64{0, 7, 14}, end: 21},
+		{name: "mkv", data: avMedia.mkv(true), container: "matroska", audio: true, segments: []int64{0, 6}, end: 12},
+		{name: "mkv without audio", data: videoMedia.mkv(true), container: "matroska", segments: []int64{0, 6}, end: 12},
+		{name: "mkv without cues", data: avMedia.mkv(false), err: ErrUnsupported},
+		{name: "mp4 cut short", data: avMedia.mp4(true)[:2000], err: errInvalidMP4},
+		{name: "not media", data: bytes.Repeat([]byte("not a video "), 10), err: ErrUnsupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idx, err := NewIndex(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if idx.Container != tt.container {
+				t.Errorf("container %q, want %q", idx.Container, tt.container)
+			}
+			if idx.Video.Codec != CodecH264 || !bytes.Equal(idx.Video.sps[0], testSPS) || !bytes.Equal(idx.Video.pps[0], testPPS) {
+				t.Errorf("video track %+v", idx.Video)
+			}
+			if (idx.Audio != nil) != tt.audio {
+				t.Fatalf("audio track %+v, want audio %v", idx.Audio, tt.audio)
+			}
+			if tt.audio && (idx.Audio.Codec != CodecAAC || idx.Audio.aac != (aacConfig{profile: 2, freqIndex: 4, channels: 2})) {
+				t.Errorf("audio track %+v", idx.Audio)
+			}
+			var starts []int64
+			for _, s := range idx.Segments {
+				starts = append(starts, s.Start/Timescale)
+			}
+			if !equalInts(starts, tt.segments) {
+				t.Errorf("segments start at %v s, want %v s", starts, tt.segments)
+			}
+			if end := idx.Segments[len(idx.Segments)-1].End; end < (tt.end-1)*Timescale || end > tt.end*Timescale {
+				t.Errorf("last segment ends at %d, want about %ds", end, tt.end)
+			}
+		})
+	}
+}
+
+func TestWriteSegment(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		media testMedia
+	}{
+		{name: "mp4", data: avMedia.mp4(false), media: avMedia},
+		{name: "mp4 with moov first", data: avMedia.mp4(true), media: avMedia},
+		{name: "mp4 without audio", data: videoMedia.mp4(true), media: videoMedia},
+		{name: "mkv", data: avMedia.mkv(true), media: avMedia},
+		{name: "mkv without audio", data: videoMedia.mkv(true), media: videoMedia},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idx, err := NewIndex(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var videoFrames, audioFrames int
+			for n, segment := range idx.Segments {
+				opener := &rangeOpener{data: tt.data}
+				samples, err := idx.ReadSegment(n, opener.open)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if span, err := idx.SegmentSpan(n); err != nil || opener.opened > span {
+					t.Errorf("segment %d: SegmentSpan() = %d, %v, but %d bytes were fetched", n, span, err, opener.opened)
+				}
+				var buf bytes.Buffer
+				written, err := samples.WriteTo(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if size := samples.Size(); size != written || size != int64(buf.Len()) {
+					t.Errorf("segment %d: Size() = %d, wrote %d bytes", n, size, buf.Len())
+				}
+				video, audio := parseTS(t, buf.Bytes())
+				if len(video) == 0 || !video[0].keyframe || video[0].pts != segment.Start+tsOffset {
+					t.Fatalf("segment %d doesn't start with a keyframe at %d: %+v", n, segment.Start, video[:min(1, len(video))])
+				}
+				for i, frame := range video {
+					want := testVideoFrame(videoFrames+i, frame.keyframe)[4:]
+					if !bytes.HasSuffix(frame.data, want) {
+						t.Fatalf("segment %d: video frame %d doesn't end with frame %d", n, i, videoFrames+i)
+					}
+				}
+				videoFrames += len(video)
+				audioFrames += len(audio)
+			}
+			if videoFrames != tt.media.frames {
+				t.Errorf("%d video frames in all segments, want %d", videoFrames, tt.media.frames)
+			}
+			if audioFrames != tt.media.audioFrames() {
+				t.Errorf("%d audio frames in all segments, want %d", audioFrames, tt.media.audioFrames())
+			}
+			if err := idx.WriteSegment(io.Discard, len(idx.Segments), (&rangeOpener{data: tt.data}).open); !errors.Is(err, ErrNoSegment) {
+				t.Errorf("segment past the end: got %v, want ErrNoSegment", err)
+			}
+		})
+	}
+}
+
+func FuzzNewIndex(f *testing.F) {
+	short := testMedia{frames: 60, keyframeInterval: 25, audio: true}
+	f.Add(short.mp4(false))
+	f.Add(short.mp4(true))
+	f.Add(short.mkv(true))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		idx, err := NewIndex(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return
+		}
+		for n := range idx.Segments[:min(len(idx.Segments), 4)] {
+			idx.WriteSegment(io.Discard, n, (&rangeOpener{data: data}).open)
+		}
+	})
+}
+
+type tsFrame struct {
+	pts      int64
+	keyframe bool
+	data     []byte
+}
+
+// parseTS returns the video and audio PES packets of an MPEG-TS stream
+// written by writeTS.
+func parseTS(t *testing.T, ts []byte) (video, audio []tsFrame) {
+	t.Helper()
+	if len(ts)%tsPacketSize != 0 {
+		t.Fatalf("%d bytes aren't whole TS packets", len(ts))
+	}
+	frames := map[uint16]*[]tsFrame{videoPID: &video, audioPID: &audio}
+	for ; len(ts) > 0; ts = ts[tsPacketSize:] {
+		p := ts[:tsPacketSize]
+		if p[0] != 0x47 {
+			t.Fatal("lost TS sync")
+		}
+		pid := uint16(p[1]&0x1F)<<8 | uint16(p[2])
+		start := p[1]&0x40 != 0
+		payload := p[4:]
+		keyframe := false
+		if p[3]&0x20 != 0 {
+			keyframe = payload[0] > 0 && payload[1]&0x40 != 0
+			payload = payload[1+int(payload[0]):]
+		}
+		list, ok := frames[pid]
+		if !ok {
+			continue
+		}
+		if start {
+			if !bytes.HasPrefix(payload, []byte{0, 0, 1}) {
+				t.Fatal("PES packet without start code")
+			}
+			pts := int64(payload[9]>>1&7)<<30 | int64(payload[10])<<22 | int64(payload[11]>>1)<<15 | int64(payload[12])<<7 | int64(payload[13]>>1)
+			*list = append(*list, tsFrame{pts: pts, keyframe: keyframe})
+			payload = payload[9+int(payload[8]):]
+		}
+		if len(*list) == 0 {
+			t.Fatal("payload before the first PES packet")
+		}
+		last := &(*list)[len(*list)-1]
+		last.data = append(last.data, payload...)
+	}
+	return video, audio
+}
+
+func equalInts(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,896 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Matroska element IDs, with their length marker.
+const (
+	idEBML          = 0x1A45DFA3
+	idDocType       = 0x4282
+	idSegment       = 0x18538067
+	idSeekHead      = 0x114D9B74
+	idSeek          = 0x4DBB
+	idSeekID        = 0x53AB
+	idSeekPosition  = 0x53AC
+	idInfo          = 0x1549A966
+	idTimecodeScale = 0x2AD7B1
+	idDuration      = 0x4489
+	idTracks        = 0x1654AE6B
+	idTrackEntry    = 0xAE
+	idTrackNumber   = 0xD7
+	idTrackType     = 0x83
+	idFlagDefault   = 0x88
+	idFlagForced    = 0x55AA
+	idDefaultDur    = 0x23E383
+	idName          = 0x536E
+	idLanguage      = 0x22B59C
+	idCodecID       = 0x86
+	idCodecPrivate  = 0x63A2
+	idVideo         = 0xE0
+	idPixelWidth    = 0xB0
+	idPixelHeight   = 0xBA
+	idAudio         = 0xE1
+	idSamplingFreq  = 0xB5
+	idChannels      = 0x9F
+	idEncodings     = 0x6D80
+	idEncoding      = 0x6240
+	idCompression   = 0x5034
+	idCompAlgo      = 0x4254
+	idCompSettings  = 0x4255
+	idEncryption    = 0x5035
+	idCues          = 0x1C53BB6B
+	idCuePoint      = 0xBB
+	idCueTime       = 0xB3
+	idCuePositions  = 0xB7
+	idCueTrack      = 0xF7
+	idCueClusterPos = 0xF1
+	idCueRelPos     = 0xF0
+	idCueDuration   = 0xB2
+	idCluster       = 0x1F43B675
+	idTimecode      = 0xE7
+	idSimpleBlock   = 0xA3
+	idBlockGroup    = 0xA0
+	idBlock         = 0xA1
+	idBlockDuration = 0x9B
+	idReferenceBlk  = 0xFB
+)
+
+// Matroska track types.
+const (
+	trackVideo    = 1
+	trackAudio    = 2
+	trackSubtitle = 0x11
+)
+
+const (
+	// maxHeaderElement bounds the level 1 elements read into memory to
+	// build the index.
+	maxHeaderElement = 32 * 1024 * 1024
+	// maxBlockSize bounds the blocks read into memory.
+	maxBlockSize = 64 * 1024 * 1024
+)
+
+var errInvalidMatroska = errors.New("invalid Matroska file")
+
+// MatroskaTrack is a track entry of a Matroska file.
+type MatroskaTrack struct {
+	Number   uint64
+	Type     uint64
+	CodecID  string
+	Name     string
+	Language string
+	Default  bool
+	Forced   bool
+
+	private         []byte
+	defaultDuration uint64 // ns
+	width, height   int
+	sampleRate      float64
+	channels        int
+	compression     int64 // -1 for none, else ContentCompAlgo
+	stripped        []byte
+	encrypted       bool
+}
+
+// Matroska holds what's read from the head of a Matroska file.
+type Matroska struct {
+	Tracks        []*MatroskaTrack
+	segmentStart  int64
+	segmentEnd    int64
+	timecodeScale uint64 // ns
+	duration      float64
+	cues          []cuePoint
+	firstCluster  int64
+}
+
+type cuePoint struct {
+	time     int64 // Timescale units
+	duration int64 // Timescale units, 0 if unknown
+	track    uint64
+	position int64 // of the cluster, relative to segmentStart
+	relative int64 // of the block, relative to the cluster data, or -1
+}
+
+// Block is a frame of a Matroska track.
+type Block struct {
+	Track    uint64
+	PTS      int64 // Timescale units
+	Duration int64 // Timescale units, 0 if unknown
+	Keyframe bool
+	Data     []byte
+}
+
+// ReadMatroska reads the EBML header, track entries and cues of the Matroska
+// file in r. Elements that come after the first cluster are found through
+// the seek head.
+func ReadMatroska(r io.ReaderAt, size int64) (*Matroska, error) {
+	id, headerSize, n, err := elementHeaderAt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if id != idEBML || headerSize == unknownSize {
+		return nil, errInvalidMatroska
+	}
+	header, err := readElementBody(r, int64(n), headerSize, size)
+	if err != nil {
+		return nil, err
+	}
+	docType := "matroska"
+	eachElement(header, func(id uint64, body []byte) error {
+		if id == idDocType {
+			docType = string(body)
+		}
+		return nil
+	})
+	if docType != "matroska" && docType != "webm" {
+		return nil, fmt.Errorf("%w: %s document", ErrUnsupported, docType)
+	}
+
+	pos := int64(n) + headerSize
+	id, segmentSize, n, err := elementHeaderAt(r, pos)
+	if err != nil {
+		return nil, err
+	}
+	if id != idSegment {
+		return nil, errInvalidMatroska
+	}
+	m := &Matroska{segmentStart: pos + int64(n), segmentEnd: size, timecodeScale: 1000000}
+	if segmentSize != unknownSize {
+		m.segmentEnd = min(size, m.segmentStart+segmentSize)
+	}
+
+	seen := map[uint64]bool{}
+	visited := map[int64]bool{}
+	var seeks []int64
+	read := func(pos int64) (stop bool, next int64, err error) {
+		if visited[pos] {
+			return true, 0, nil
+		}
+		visited[pos] = true
+		id, size, n, err := elementHeaderAt(r, pos)
+		if err == nil && id == idCluster && m.firstCluster == 0 {
+			m.firstCluster = pos
+		}
+		if err != nil || size == unknownSize || id == idCluster {
+			return true, 0, err
+		}
+		next = pos + int64(n) + size
+		switch id {
+		case idSeekHead, idInfo, idTracks, idCues:
+		default:
+			return false, next, nil
+		}
+		if seen[id] && id != idSeekHead {
+			return false, next, nil
+		}
+		seen[id] = true
+		body, err := readElementBody(r, pos+int64(n), size, m.segmentEnd)
+		if err != nil {
+			return true, 0, err
+		}
+		switch id {
+		case idSeekHead:
+			seeks = append(seeks, m.parseSeekHead(body)...)
+		case idInfo:
+			m.parseInfo(body)
+		case idTracks:
+			m.parseTracks(body)
+		case idCues:
+			m.parseCues(body)
+		}
+		return false, next, nil
+	}
+	for pos := m.segmentStart; pos < m.segmentEnd; {
+		stop, next, err := read(pos)
+		if err != nil {
+			return nil, err
+		}
+		if stop {
+			break
+		}
+		pos = next
+	}
+	// seek heads may point to more seek heads
+	for i := 0; i < len(seeks); i++ {
+		if _, _, err := read(seeks[i]); err != nil {
+			return nil, err
+		}
+	}
+	if len(m.Tracks) == 0 {
+		return nil, fmt.Errorf("%w: no tracks", ErrUnsupported)
+	}
+	return m, nil
+}
+
+// readElementBody reads the body of size bytes at off, cut off at end.
+func readElementBody(r io.ReaderAt, off int64, size int64, end int64) ([]byte, error) {
+	if size > maxHeaderElement {
+		return nil, fmt.Errorf("%w: element too large", ErrUnsupported)
+	}
+	if off > end {
+		return nil, errInvalidMatroska
+	}
+	body := make([]byte, min(size, end-off))
+	if _, err := r.ReadAt(body, off); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return body, nil
+}
+
+// parseSeekHead returns the positions of the elements the index is built from.
+func (m *Matroska) parseSeekHead(b []byte) []int64 {
+	var positions []int64
+	eachElement(b, func(id uint64, seek []byte) error {
+		if id != idSeek {
+			return nil
+		}
+		var seekID uint64
+		position := int64(-1)
+		eachElement(seek, func(id uint64, body []byte) error {
+			switch id {
+			case idSeekID:
+				seekID = ebmlUint(body)
+			case idSeekPosition:
+				position = int64(ebmlUint(body))
+			}
+			return nil
+		})
+		switch seekID {
+		case idSeekHead, idInfo, idTracks, idCues:
+			if position >= 0 && m.segmentStart+position < m.segmentEnd {
+				positions = append(positions, m.segmentStart+position)
+			}
+		}
+		return nil
+	})
+	return positions
+}
+
+func (m *Matroska) parseInfo(b []byte) {
+	eachElement(b, func(id uint64, body []byte) error {
+		switch id {
+		case idTimecodeScale:
+			if scale := ebmlUint(body); scale > 0 {
+				m.timecodeScale = scale
+			}
+		case idDuration:
+			m.duration = ebmlFloat(body)
+		}
+		return nil
+	})
+}
+
+func (m *Matroska) parseTracks(b []byte) {
+	eachElement(b, func(id uint64, entry []byte) error {
+		if id != idTrackEntry {
+			return nil
+		}
+		t := &MatroskaTrack{Language: "eng", Default: true, compression: -1}
+		eachElement(entry, func(id uint64, body []byte) error {
+			switch id {
+			case idTrackNumber:
+				t.Number = ebmlUint(body)
+			case idTrackType:
+				t.Type = ebmlUint(body)
+			case idFlagDefault:
+				t.Default = ebmlUint(body) != 0
+			case idFlagForced:
+				t.Forced = ebmlUint(body) != 0
+			case idDefaultDur:
+				t.defaultDuration = ebmlUint(body)
+			case idName:
+				t.Name = string(body)
+			case idLanguage:
+				t.Language = string(body)
+			case idCodecID:
+				t.CodecID = string(body)
+			case idCodecPrivate:
+				t.private = body
+			case idVideo:
+				eachElement(body, func(id uint64, body []byte) error {
+					switch id {
+					case idPixelWidth:
+						t.width = int(ebmlUint(body))
+					case idPixelHeight:
+						t.height = int(ebmlUint(body))
+					}
+					return nil
+				})
+			case idAudio:
+				eachElement(body, func(id uint64, body []byte) error {
+					switch id {
+					case idSamplingFreq:
+						t.sampleRate = ebmlFloat(body)
+					case idChannels:
+						t.channels = int(ebmlUint(body))
+					}
+					return nil
+				})
+			case idEncodings:
+				t.parseEncodings(body)
+			}
+			return nil
+		})
+		m.Tracks = append(m.Tracks, t)
+		return nil
+	})
+}
+
+// parseEncodings reads the compression of a track's frames. Encrypted
+// tracks are flagged and can't be read.
+func (t *MatroskaTrack) parseEncodings(b []byte) {
+	eachElement(b, func(id uint64, encoding []byte) error {
+		if id != idEncoding {
+			return nil
+		}
+		eachElement(encoding, func(id uint64, body []byte) error {
+			switch id {
+			case idCompression:
+				t.compression = 0
+				eachElement(body, func(id uint64, body []byte) error {
+					switch id {
+					case idCompAlgo:
+						t.compression = int64(ebmlUint(body))
+					case idCompSettings:
+						t.stripped = body
+					}
+					return nil
+				})
+			case idEncryption:
+				t.encrypted = true
+			}
+			return nil
+		})
+		return nil
+	})
+}
+
+func (m *Matroska) parseCues(b []byte) {
+	eachElement(b, func(id uint64, point []byte) error {
+		if id != idCuePoint {
+			return nil
+		}
+		var time int64
+		var positions []cuePoint
+		eachElement(point, func(id uint64, body []byte) error {
+			switch id {
+			case idCueTime:
+				time = m.timestamp(int64(ebmlUint(body)))
+			case idCuePositions:
+				c := cuePoint{position: -1, relative: -1}
+				eachElement(body, func(id uint64, body []byte) error {
+					switch id {
+					case idCueTrack:
+						c.track = ebmlUint(body)
+					case idCueClusterPos:
+						c.position = int64(ebmlUint(body))
+					case idCueRelPos:
+						c.relative = int64(ebmlUint(body))
+					case idCueDuration:
+						c.duration = m.timestamp(int64(ebmlUint(body)))
+					}
+					return nil
+				})
+				if c.position >= 0 && m.segmentStart+c.position < m.segmentEnd {
+					positions = append(positions, c)
+				}
+			}
+			return nil
+		})
+		for _, c := range positions {
+			c.time = time
+			m.cues = append(m.cues, c)
+		}
+		return nil
+	})
+}
+
+// timestamp converts a time in timecode scale units to Timescale units.
+func (m *Matroska) timestamp(timecode int64) int64 {
+	ns := timecode * int64(m.timecodeScale)
+	return ns/1e9*Timescale + ns%1e9*Timescale/1e9
+}
+
+// Duration returns the duration of the file in Timescale units, or 0 if it
+// isn't known.
+func (m *Matroska) Duration() int64 {
+	return int64(m.duration * float64(m.timecodeScale) * Timescale / 1e9)
+}
+
+// Track returns the track with the given number.
+func (m *Matroska) Track(number uint64) *MatroskaTrack {
+	for _, t := range m.Tracks {
+		if t.Number == number {
+			return t
+		}
+	}
+	return nil
+}
+
+// ReadBlocks reads the clusters in the byte range [offset, limit) of the
+// file and calls fn for every frame of the given tracks, in file order. It
+// stops when fn returns false, or in the first cluster starting at or after
+// end (in Timescale units) once the frames are a second past end: muxers
+// interleave frames by decoding time, so frames presented just before a
+// keyframe may follow it. offset must be the position of a cluster.
+func (m *Matroska) ReadBlocks(open Opener, offset, limit int64, end int64, tracks []uint64, fn func(b Block) bool) error {
+	rc, err := open(offset, limit-1)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	r := bufio.NewReaderSize(rc, 64*1024)
+	// the range ends wherever the caller bounded it, possibly inside an element
+	truncated := func(err error) error {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		return err
+	}
+	// pos is where r is in the file
+	pos := offset
+	for {
+		id, size, n, err := readElementHeader(r)
+		if err != nil {
+			return truncated(err)
+		}
+		pos += int64(n)
+		if size == unknownSize {
+			return fmt.Errorf("%w: element of unknown size", ErrUnsupported)
+		}
+		if id != idCluster {
+			if size > limit-pos {
+				return nil
+			}
+			if _, err := r.Discard(int(size)); err != nil {
+				return truncated(err)
+			}
+			pos += size
+			continue
+		}
+
+		// clusters are read element by element, as the one at end only
+		// contributes its first frames
+		var timecode int64
+		past := false
+		stop := false
+		emit := func(b Block) bool {
+			if past && b.PTS >= end+Timescale {
+				stop = true
+				return false
+			}
+			stop = !fn(b)
+			return !stop
+		}
+		for remaining := size; remaining > 0 && !stop; {
+			id, size, n, err := readElementHeader(r)
+			if err != nil {
+				return truncated(err)
+			}
+			if size == unknownSize || int64(n)+size > remaining {
+				return errInvalidMatroska
+			}
+			remaining -= int64(n) + size
+			pos += int64(n)
+			if size > limit-pos {
+				return nil
+			}
+			switch id {
+			case idTimecode, idSimpleBlock, idBlockGroup:
+			default:
+				if _, err := r.Discard(int(size)); err != nil {
+					return truncated(err)
+				}
+				pos += size
+				continue
+			}
+			if size > maxBlockSize {
+				return fmt.Errorf("%w: block too large", ErrUnsupported)
+			}
+			body := make([]byte, size)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return truncated(err)
+			}
+			pos += size
+			switch id {
+			case idTimecode:
+				timecode = int64(ebmlUint(body))
+				past = m.timestamp(timecode) >= end
+			default:
+				m.blockElement(id, body, timecode, tracks, emit)
+			}
+		}
+		if stop {
+			return nil
+		}
+	}
+}
+
+// blockElement calls fn for every frame of the SimpleBlock or BlockGroup
+// element with the given body, as block does.
+func (m *Matroska) blockElement(id uint64, body []byte, clusterTimecode int64, tracks []uint64, fn func(b Block) bool) bool {
+	if id == idSimpleBlock {
+		return m.block(body, clusterTimecode, true, false, 0, tracks, fn)
+	}
+	var block []byte
+	var duration int64
+	keyframe := true
+	eachElement(body, func(id uint64, body []byte) error {
+		switch id {
+		case idBlock:
+			block = body
+		case idBlockDuration:
+			duration = int64(ebmlUint(body))
+		case idReferenceBlk:
+			keyframe = false
+		}
+		return nil
+	})
+	if block == nil {
+		return true
+	}
+	return m.block(block, clusterTimecode, false, keyframe, duration, tracks, fn)
+}
+
+// block calls fn for every frame of a (Simple)Block of one of tracks and
+// returns false if fn asked to stop.
+func (m *Matroska) block(b []byte, clusterTimecode int64, simple bool, keyframe bool, duration int64, tracks []uint64, fn func(b Block) bool) bool {
+	number, n, err := vint(b, false)
+	if err != nil || len(b) < n+3 {
+		return true
+	}
+	wanted := false
+	for _, t := range tracks {
+		wanted = wanted || t == number
+	}
+	track := m.Track(number)
+	if !wanted || track == nil || track.encrypted {
+		return true
+	}
+	timecode := clusterTimecode + int64(int16(binary.BigEndian.Uint16(b[n:])))
+	flags := b[n+2]
+	if simple {
+		keyframe = flags&0x80 != 0
+	}
+	frames, err := unlace(flags>>1&3, b[n+3:])
+	if err != nil {
+		return true
+	}
+	pts := m.timestamp(timecode)
+	frameDuration := track.frameDuration()
+	for i, frame := range frames {
+		data, err := track.decompress(frame)
+		if err != nil {
+			continue
+		}
+		block := Block{
+			Track:    number,
+			PTS:      pts + int64(i)*frameDuration,
+			Duration: m.timestamp(duration),
+			Keyframe: keyframe,
+			Data:     data,
+		}
+		if !fn(block) {
+			return false
+		}
+	}
+	return true
+}
+
+// unlace splits the frames of a block with the given lacing.
+func unlace(lacing byte, b []byte) ([][]byte, error) {
+	if lacing == 0 {
+		return [][]byte{b}, nil
+	}
+	if len(b) < 1 {
+		return nil, errInvalidMatroska
+	}
+	count := int(b[0]) + 1
+	b = b[1:]
+	sizes := make([]int, count)
+	switch lacing {
+	case 1: // Xiph
+		for i := 0; i < count-1; i++ {
+			for {
+				if len(b) == 0 {
+					return nil, errInvalidMatroska
+				}
+				c := b[0]
+				b = b[1:]
+				sizes[i] += int(c)
+				if c != 255 {
+					break
+				}
+			}
+		}
+	case 2: // fixed
+		for i := range sizes {
+			sizes[i] = len(b) / count
+		}
+	case 3: // EBML
+		v, n, err := vint(b, false)
+		if err != nil {
+			return nil, err
+		}
+		sizes[0] = int(v)
+		b = b[n:]
+		for i := 1; i < count-1; i++ {
+			v, n, err := vint(b, false)
+			if err != nil {
+				return nil, err
+			}
+			// signed: the stored value minus half the range
+			sizes[i] = sizes[i-1] + int(int64(v)-(int64(1)<<(7*n-1)-1))
+			b = b[n:]
+		}
+	}
+	if lacing != 2 {
+		used := 0
+		for _, size := range sizes[:count-1] {
+			if size < 0 {
+				return nil, errInvalidMatroska
+			}
+			used += size
+		}
+		sizes[count-1] = len(b) - used
+		if sizes[count-1] < 0 {
+			return nil, errInvalidMatroska
+		}
+	}
+	frames := make([][]byte, count)
+	for i, size := range sizes {
+		if size > len(b) {
+			return nil, errInvalidMatroska
+		}
+		frames[i] = b[:size]
+		b = b[size:]
+	}
+	return frames, nil
+}
+
+// frameDuration returns the duration of one frame in Timescale units, used
+// to time the frames of laced blocks.
+func (t *MatroskaTrack) frameDuration() int64 {
+	if t.defaultDuration > 0 {
+		return int64(t.defaultDuration) * Timescale / 1e9
+	}
+	if t.sampleRate > 0 {
+		switch {
+		case strings.HasPrefix(t.CodecID, "A_AAC"):
+			return int64(1024 * Timescale / t.sampleRate)
+		case t.CodecID == "A_MPEG/L3":
+			return int64(1152 * Timescale / t.sampleRate)
+		}
+	}
+	return 0
+}
+
+// decompress undoes the content compression of a frame.
+func (t *MatroskaTrack) decompress(frame []byte) ([]byte, error) {
+	switch t.compression {
+	case -1:
+		return frame, nil
+	case 0:
+		zr, err := zlib.NewReader(bytes.NewReader(frame))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		data, err := io.ReadAll(io.LimitReader(zr, maxBlockSize+1))
+		if err == nil && len(data) > maxBlockSize {
+			err = fmt.Errorf("%w: block too large", ErrUnsupported)
+		}
+		return data, err
+	case 3:
+		return append(append([]byte{}, t.stripped...), frame...), nil
+	}
+	return nil, fmt.Errorf("%w: compression %d", ErrUnsupported, t.compression)
+}
+
+// matroskaDemuxer cuts a Matroska file at the cue points of its video track.
+type matroskaDemuxer struct {
+	m     *Matroska
+	video *MatroskaTrack
+	audio *MatroskaTrack
+}
+
+func indexMatroska(r io.ReaderAt, size int64) (*Index, error) {
+	m, err := ReadMatroska(r, size)
+	if err != nil {
+		return nil, err
+	}
+	d := &matroskaDemuxer{m: m}
+	idx := &Index{Container: "matroska", Duration: m.Duration(), demuxer: d}
+	var otherVideo string
+	for _, t := range m.Tracks {
+		switch t.Type {
+		case trackVideo:
+			if t.CodecID != "V_MPEG4/ISO/AVC" {
+				otherVideo = t.CodecID
+				continue
+			}
+			if d.video != nil || t.encrypted {
+				continue
+			}
+			track := &Track{Codec: CodecH264, Width: t.width, Height: t.height}
+			if err := track.parseAVCC(t.private); err != nil {
+				return nil, err
+			}
+			d.video, idx.Video = t, track
+		case trackAudio:
+			if d.audio != nil && (d.audio.Default || !t.Default) || t.encrypted {
+				continue
+			}
+			track := &Track{SampleRate: int(t.sampleRate), Channels: t.channels}
+			switch {
+			case t.CodecID == "A_AAC":
+				c, err := parseASC(t.private)
+				if err != nil {
+					continue
+				}
+				track.Codec, track.aac = CodecAAC, c
+			case strings.HasPrefix(t.CodecID, "A_AAC/"):
+				// old codec IDs carry the profile instead of a config
+				profile := 2
+				switch {
+				case strings.HasSuffix(t.CodecID, "/MAIN"):
+					profile = 1
+				case strings.HasSuffix(t.CodecID, "/SSR"):
+					profile = 3
+				}
+				track.Codec = CodecAAC
+				track.aac = aacConfig{profile: profile, freqIndex: aacFreqIndex(int(t.sampleRate)), channels: t.channels}
+			case t.CodecID == "A_MPEG/L3":
+				track.Codec = CodecMP3
+			default:
+				continue
+			}
+			d.audio, idx.Audio = t, track
+		}
+	}
+	if d.video == nil {
+		if otherVideo != "" {
+			return nil, fmt.Errorf("%w: %s video", ErrUnsupported, otherVideo)
+		}
+		return nil, fmt.Errorf("%w: no H.264 video track", ErrUnsupported)
+	}
+
+	var cues []cuePoint
+	for _, c := range m.cues {
+		if c.track == d.video.Number {
+			cues = append(cues, c)
+		}
+	}
+	if len(cues) == 0 {
+		return nil, fmt.Errorf("%w: no cues for the video track", ErrUnsupported)
+	}
+	sort.Slice(cues, func(i, j int) bool { return cues[i].time < cues[j].time })
+	positions := make([]int64, len(cues))
+	for i, c := range cues {
+		positions[i] = c.position
+	}
+	sort.Slice(positions, func(i, j int) bool { return positions[i] < positions[j] })
+
+	keyframes := make([]int64, len(cues))
+	for i, c := range cues {
+		keyframes[i] = c.time
+	}
+	if last := keyframes[len(keyframes)-1]; idx.Duration <= last {
+		idx.Duration = last + SegmentDuration
+	}
+	cuts := cutPoints(keyframes)
+	for i, cut := range cuts {
+		segment := Segment{
+			Start:  cues[cut].time,
+			End:    idx.Duration,
+			offset: m.segmentStart + cues[cut].position,
+			limit:  m.segmentEnd,
+		}
+		if i+1 < len(cuts) {
+			next := cues[cuts[i+1]]
+			segment.End = next.time
+			// the segment ends inside the cluster of the next cue point,
+			// which ends where the cluster of a later cue point starts
+			j := sort.Search(len(positions), func(j int) bool { return positions[j] > next.position })
+			if j < len(positions) {
+				segment.limit = m.segmentStart + positions[j]
+			}
+		}
+		idx.Segments = append(idx.Segments, segment)
+	}
+	return idx, nil
+}
+
+func (d *matroskaDemuxer) span(seg Segment) int64 {
+	return seg.limit - seg.offset
+}
+
+func (d *matroskaDemuxer) samples(seg Segment, open Opener) ([]Sample, []Sample, error) {
+	tracks := []uint64{d.video.Number}
+	if d.audio != nil {
+		tracks = append(tracks, d.audio.Number)
+	}
+	var video, audio []Sample
+	var size int
+	started, ended := false, false
+	err := d.m.ReadBlocks(open, seg.offset, seg.limit, seg.End, tracks, func(b Block) bool {
+		if size += len(b.Data); size > maxSegmentSize {
+			return false
+		}
+		if b.Track == d.video.Number {
+			// video frames are taken in decoding order from the keyframe
+			// of the cue point to the keyframe of the next one
+			if !started {
+				if !b.Keyframe || b.PTS < seg.Start {
+					return true
+				}
+				started = true
+			} else if b.Keyframe && b.PTS >= seg.End {
+				ended = true
+			}
+			if !ended {
+				video = append(video, Sample{PTS: b.PTS, Keyframe: b.Keyframe, Data: b.Data})
+			}
+			return !ended || d.audio != nil
+		}
+		if b.PTS >= seg.Start && b.PTS < seg.End {
+			audio = append(audio, Sample{PTS: b.PTS, DTS: b.PTS, Keyframe: true, Data: b.Data})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if size > maxSegmentSize {
+		return nil, nil, errSegmentSize
+	}
+	setDecodingTimes(video)
+	return video, audio, nil
+}
+
+// setDecodingTimes derives decoding times for video frames, which Matroska
+// doesn't store: frames are decoded in order at the sorted presentation
+// times, delayed so that no frame is decoded after it is presented.
+func setDecodingTimes(video []Sample) {
+	times := make([]int64, len(video))
+	for i, s := range video {
+		times[i] = s.PTS
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	var delay int64
+	for i, s := range video {
+		delay = max(delay, times[i]-s.PTS)
+	}
+	for i := range video {
+		video[i].DTS = times[i] - delay
+	}
+}
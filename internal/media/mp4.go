@@ -0,0 +1,649 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+const (
+	// maxMoovSize bounds the moov box read into memory.
+	maxMoovSize = 64 * 1024 * 1024
+	// maxSamples bounds the sample table of a track.
+	maxSamples = 4 * 1024 * 1024
+	// maxSpanGap is the largest hole between two samples of a segment that is
+	// read through rather than fetched with another request.
+	maxSpanGap = 512 * 1024
+)
+
+var errInvalidMP4 = errors.New("invalid MP4 file")
+
+type mp4Sample struct {
+	offset   int64
+	size     uint32
+	keyframe bool
+	pts      int64
+	dts      int64
+}
+
+type mp4Track struct {
+	Track
+	id        uint32
+	handler   string
+	timescale uint32
+	duration  int64
+	// shift is the edit list offset in media time units
+	shift   int64
+	tables  sampleTables
+	samples []mp4Sample
+}
+
+type mp4Demuxer struct {
+	video *mp4Track
+	audio *mp4Track
+}
+
+// eachBox calls fn with the type and body of every box in b.
+func eachBox(b []byte, fn func(typ string, body []byte) error) error {
+	for len(b) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(b))
+		typ := string(b[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return errInvalidMP4
+			}
+			size = binary.BigEndian.Uint64(b[8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(b)) {
+			return errInvalidMP4
+		}
+		if err := fn(typ, b[header:size]); err != nil {
+			return err
+		}
+		b = b[size:]
+	}
+	return nil
+}
+
+// readMoov walks the top level boxes of the file and reads the moov box,
+// which may come before or after the media data.
+func readMoov(r io.ReaderAt, size int64) ([]byte, error) {
+	header := make([]byte, 16)
+	for pos := int64(0); pos+8 <= size; {
+		n, err := r.ReadAt(header, pos)
+		if n < 8 {
+			return nil, err
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header))
+		headerSize := int64(8)
+		switch boxSize {
+		case 0:
+			boxSize = size - pos
+		case 1:
+			if n < 16 {
+				return nil, errInvalidMP4
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:]))
+			headerSize = 16
+		}
+		if boxSize < headerSize {
+			return nil, errInvalidMP4
+		}
+		boxSize = min(boxSize, size-pos)
+		switch string(header[4:8]) {
+		case "moov":
+			if boxSize > maxMoovSize {
+				return nil, fmt.Errorf("%w: moov box too large", ErrUnsupported)
+			}
+			if boxSize < headerSize {
+				return nil, errInvalidMP4
+			}
+			moov := make([]byte, boxSize-headerSize)
+			if _, err := r.ReadAt(moov, pos+headerSize); err != nil && err != io.EOF {
+				return nil, err
+			}
+			return moov, nil
+		case "moof":
+			return nil, fmt.Errorf("%w: fragmented MP4", ErrUnsupported)
+		}
+		pos += boxSize
+	}
+	return nil, fmt.Errorf("%w: no moov box", ErrUnsupported)
+}
+
+func indexMP4(r io.ReaderAt, size int64) (*Index, error) {
+	moov, err := readMoov(r, size)
+	if err != nil {
+		return nil, err
+	}
+	var movieTimescale uint32
+	var tracks []*mp4Track
+	err = eachBox(moov, func(typ string, body []byte) error {
+		switch typ {
+		case "mvhd":
+			if len(body) >= 24 {
+				if body[0] == 1 {
+					movieTimescale = binary.BigEndian.Uint32(body[20:])
+				} else {
+					movieTimescale = binary.BigEndian.Uint32(body[12:])
+				}
+			}
+		case "trak":
+			track, err := parseTrak(body, movieTimescale)
+			if err != nil {
+				return err
+			}
+			tracks = append(tracks, track)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d := &mp4Demuxer{}
+	var otherVideo string
+	for _, track := range tracks {
+		switch track.handler {
+		case "vide":
+			if d.video == nil && track.Codec == CodecH264 {
+				d.video = track
+			} else if track.Codec != CodecH264 {
+				otherVideo = track.Codec
+			}
+		case "soun":
+			if d.audio == nil && track.Codec != "" {
+				d.audio = track
+			}
+		}
+	}
+	if d.video == nil {
+		if otherVideo != "" {
+			return nil, fmt.Errorf("%w: %s video", ErrUnsupported, otherVideo)
+		}
+		return nil, fmt.Errorf("%w: no H.264 video track", ErrUnsupported)
+	}
+	for _, track := range []*mp4Track{d.video, d.audio} {
+		if track != nil {
+			if err := track.buildSamples(size); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(d.video.samples) == 0 {
+		return nil, fmt.Errorf("%w: no video samples", ErrUnsupported)
+	}
+
+	var keyframes []int64
+	var keyframeSamples []int
+	for i, s := range d.video.samples {
+		if s.keyframe {
+			keyframes = append(keyframes, s.pts)
+			keyframeSamples = append(keyframeSamples, i)
+		}
+	}
+	if len(keyframes) == 0 {
+		return nil, fmt.Errorf("%w: no keyframes", ErrUnsupported)
+	}
+
+	idx := &Index{
+		Container: "mp4",
+		Duration:  d.video.duration,
+		Video:     &d.video.Track,
+		demuxer:   d,
+	}
+	if d.audio != nil {
+		idx.Audio = &d.audio.Track
+	}
+	for _, s := range d.video.samples {
+		idx.Duration = max(idx.Duration, s.pts)
+	}
+	cuts := cutPoints(keyframes)
+	for i, cut := range cuts {
+		segment := Segment{Start: keyframes[cut], End: idx.Duration, first: keyframeSamples[cut], last: len(d.video.samples)}
+		if i+1 < len(cuts) {
+			segment.End = keyframes[cuts[i+1]]
+			segment.last = keyframeSamples[cuts[i+1]]
+		}
+		idx.Segments = append(idx.Segments, segment)
+	}
+	return idx, nil
+}
+
+// sampleTables holds the stbl boxes a sample table is built from.
+type sampleTables struct {
+	stts, ctts, stss, stsc, stsz, stco []byte
+	co64                               bool
+}
+
+func parseTrak(trak []byte, movieTimescale uint32) (*mp4Track, error) {
+	t := &mp4Track{}
+	var tables sampleTables
+	var elst []byte
+	var walk func(typ string, body []byte) error
+	walk = func(typ string, body []byte) error {
+		switch typ {
+		case "edts", "mdia", "minf", "stbl":
+			return eachBox(body, walk)
+		case "tkhd":
+			if len(body) >= 24 {
+				if body[0] == 1 {
+					t.id = binary.BigEndian.Uint32(body[20:])
+				} else {
+					t.id = binary.BigEndian.Uint32(body[12:])
+				}
+			}
+		case "elst":
+			elst = body
+		case "mdhd":
+			if len(body) >= 32 && body[0] == 1 {
+				t.timescale = binary.BigEndian.Uint32(body[20:])
+				t.duration = int64(binary.BigEndian.Uint64(body[24:]))
+			} else if len(body) >= 20 {
+				t.timescale = binary.BigEndian.Uint32(body[12:])
+				t.duration = int64(binary.BigEndian.Uint32(body[16:]))
+			}
+		case "hdlr":
+			if len(body) >= 12 {
+				t.handler = string(body[8:12])
+			}
+		case "stsd":
+			t.parseStsd(body)
+		case "stts":
+			tables.stts = body
+		case "ctts":
+			tables.ctts = body
+		case "stss":
+			tables.stss = body
+		case "stsc":
+			tables.stsc = body
+		case "stsz":
+			tables.stsz = body
+		case "stco":
+			tables.stco = body
+		case "co64":
+			tables.stco = body
+			tables.co64 = true
+		}
+		return nil
+	}
+	if err := eachBox(trak, walk); err != nil {
+		return nil, err
+	}
+	if t.timescale == 0 {
+		return nil, errInvalidMP4
+	}
+	t.shift = editShift(elst, movieTimescale, t.timescale)
+	t.duration = rescale(t.duration+t.shift, t.timescale)
+	t.tables = tables
+	return t, nil
+}
+
+// editShift returns the offset the edit list applies to media times: empty
+// edits delay the track and the first real edit skips media_time.
+func editShift(elst []byte, movieTimescale, timescale uint32) int64 {
+	if len(elst) < 8 || movieTimescale == 0 {
+		return 0
+	}
+	version := elst[0]
+	count := int(binary.BigEndian.Uint32(elst[4:]))
+	b := elst[8:]
+	var delay int64
+	for i := 0; i < count; i++ {
+		var duration, mediaTime int64
+		if version == 1 {
+			if len(b) < 20 {
+				break
+			}
+			duration = int64(binary.BigEndian.Uint64(b))
+			mediaTime = int64(binary.BigEndian.Uint64(b[8:]))
+			b = b[20:]
+		} else {
+			if len(b) < 12 {
+				break
+			}
+			duration = int64(binary.BigEndian.Uint32(b))
+			mediaTime = int64(int32(binary.BigEndian.Uint32(b[4:])))
+			b = b[12:]
+		}
+		if mediaTime == -1 {
+			delay += duration
+			continue
+		}
+		return delay*int64(timescale)/int64(movieTimescale) - mediaTime
+	}
+	return delay * int64(timescale) / int64(movieTimescale)
+}
+
+// parseStsd reads the codec of the first sample description.
+func (t *mp4Track) parseStsd(stsd []byte) {
+	if len(stsd) < 8 {
+		return
+	}
+	first := true
+	eachBox(stsd[8:], func(typ string, entry []byte) error {
+		if !first {
+			return nil
+		}
+		first = false
+		switch typ {
+		case "avc1", "avc3":
+			if len(entry) < 78 {
+				return nil
+			}
+			t.Width = int(binary.BigEndian.Uint16(entry[24:]))
+			t.Height = int(binary.BigEndian.Uint16(entry[26:]))
+			eachBox(entry[78:], func(typ string, body []byte) error {
+				if typ == "avcC" && t.parseAVCC(body) == nil {
+					t.Codec = CodecH264
+				}
+				return nil
+			})
+		case "hvc1", "hev1":
+			t.Codec = "hevc"
+		case "mp4a", ".mp3":
+			if len(entry) < 28 {
+				return nil
+			}
+			t.Channels = int(binary.BigEndian.Uint16(entry[16:]))
+			t.SampleRate = int(binary.BigEndian.Uint32(entry[24:]) >> 16)
+			if typ == ".mp3" {
+				t.Codec = CodecMP3
+				return nil
+			}
+			children := entry[28:]
+			switch binary.BigEndian.Uint16(entry[8:]) {
+			case 1:
+				children = children[min(16, len(children)):]
+			case 2:
+				children = children[min(36, len(children)):]
+			}
+			var esds func(typ string, body []byte) error
+			esds = func(typ string, body []byte) error {
+				switch typ {
+				case "wave":
+					return eachBox(body, esds)
+				case "esds":
+					t.parseESDS(body)
+				}
+				return nil
+			}
+			eachBox(children, esds)
+		}
+		return nil
+	})
+}
+
+// parseESDS reads the object type and the AudioSpecificConfig from an
+// elementary stream descriptor.
+func (t *mp4Track) parseESDS(esds []byte) {
+	if len(esds) < 4 {
+		return
+	}
+	tag, es, _ := descriptor(esds[4:])
+	if tag != 0x03 || len(es) < 3 {
+		return
+	}
+	flags := es[2]
+	es = es[3:]
+	if flags&0x80 != 0 {
+		es = es[min(2, len(es)):]
+	}
+	if flags&0x40 != 0 && len(es) > 0 {
+		es = es[min(1+int(es[0]), len(es)):]
+	}
+	if flags&0x20 != 0 {
+		es = es[min(2, len(es)):]
+	}
+	tag, config, _ := descriptor(es)
+	if tag != 0x04 || len(config) < 13 {
+		return
+	}
+	switch config[0] {
+	case 0x40, 0x66, 0x67, 0x68:
+		tag, info, _ := descriptor(config[13:])
+		if tag != 0x05 {
+			return
+		}
+		if c, err := parseASC(info); err == nil {
+			t.aac = c
+			t.Codec = CodecAAC
+		}
+	case 0x69, 0x6B:
+		t.Codec = CodecMP3
+	}
+}
+
+// descriptor splits off the first MPEG-4 descriptor of b.
+func descriptor(b []byte) (tag byte, body []byte, rest []byte) {
+	if len(b) < 2 {
+		return 0, nil, nil
+	}
+	n, i := 0, 1
+	for ; i < len(b) && i <= 4; i++ {
+		n = n<<7 | int(b[i]&0x7F)
+		if b[i]&0x80 == 0 {
+			i++
+			break
+		}
+	}
+	if i+n > len(b) {
+		return 0, nil, nil
+	}
+	return b[0], b[i : i+n], b[i+n:]
+}
+
+// buildSamples expands the sample tables into the offset, size and
+// timestamps of every sample, which must lie within the first fileSize bytes.
+func (t *mp4Track) buildSamples(fileSize int64) error {
+	tables := t.tables
+	if len(tables.stsz) < 12 || len(tables.stsc) < 8 || len(tables.stco) < 8 || len(tables.stts) < 8 {
+		return fmt.Errorf("%w: incomplete sample table", ErrUnsupported)
+	}
+	be := binary.BigEndian
+
+	constantSize := be.Uint32(tables.stsz[4:])
+	count := int(be.Uint32(tables.stsz[8:]))
+	if count > maxSamples || constantSize == 0 && len(tables.stsz) < 12+4*count {
+		return errInvalidMP4
+	}
+	// samples don't overlap, so there can't be more of them than bytes
+	if int64(count) > fileSize || int64(count)*int64(constantSize) > fileSize {
+		return errInvalidMP4
+	}
+	samples := make([]mp4Sample, count)
+	for i := range samples {
+		samples[i].size = constantSize
+		if constantSize == 0 {
+			samples[i].size = be.Uint32(tables.stsz[12+4*i:])
+		}
+	}
+
+	// offsets: chunks hold runs of consecutive samples
+	chunkCount := int(be.Uint32(tables.stco[4:]))
+	entrySize := 4
+	if tables.co64 {
+		entrySize = 8
+	}
+	if len(tables.stco) < 8+entrySize*chunkCount {
+		return errInvalidMP4
+	}
+	stscCount := int(be.Uint32(tables.stsc[4:]))
+	if len(tables.stsc) < 8+12*stscCount {
+		return errInvalidMP4
+	}
+	sample, run := 0, 0
+	for chunk := 0; chunk < chunkCount && sample < count; chunk++ {
+		for run+1 < stscCount && int(be.Uint32(tables.stsc[8+12*(run+1):])) <= chunk+1 {
+			run++
+		}
+		perChunk := 0
+		if stscCount > 0 {
+			perChunk = int(be.Uint32(tables.stsc[8+12*run+4:]))
+		}
+		var offset int64
+		if tables.co64 {
+			offset = int64(be.Uint64(tables.stco[8+8*chunk:]))
+		} else {
+			offset = int64(be.Uint32(tables.stco[8+4*chunk:]))
+		}
+		for i := 0; i < perChunk && sample < count; i++ {
+			samples[sample].offset = offset
+			offset += int64(samples[sample].size)
+			if offset > fileSize {
+				return errInvalidMP4
+			}
+			sample++
+		}
+	}
+
+	// decoding times
+	sttsCount := int(be.Uint32(tables.stts[4:]))
+	if len(tables.stts) < 8+8*sttsCount {
+		return errInvalidMP4
+	}
+	var dts int64
+	sample = 0
+	for i := 0; i < sttsCount && sample < count; i++ {
+		n := int(be.Uint32(tables.stts[8+8*i:]))
+		delta := int64(be.Uint32(tables.stts[12+8*i:]))
+		for ; n > 0 && sample < count; n-- {
+			samples[sample].dts = dts
+			dts += delta
+			sample++
+		}
+	}
+
+	// composition offsets, signed in practice even in version 0
+	offsets := make([]int64, count)
+	if len(tables.ctts) >= 8 {
+		cttsCount := int(be.Uint32(tables.ctts[4:]))
+		if len(tables.ctts) < 8+8*cttsCount {
+			return errInvalidMP4
+		}
+		sample = 0
+		for i := 0; i < cttsCount && sample < count; i++ {
+			n := int(be.Uint32(tables.ctts[8+8*i:]))
+			offset := int64(int32(be.Uint32(tables.ctts[12+8*i:])))
+			for ; n > 0 && sample < count; n-- {
+				offsets[sample] = offset
+				sample++
+			}
+		}
+	}
+
+	// all samples are sync samples without stss
+	if len(tables.stss) >= 8 {
+		stssCount := int(be.Uint32(tables.stss[4:]))
+		if len(tables.stss) < 8+4*stssCount {
+			return errInvalidMP4
+		}
+		for i := 0; i < stssCount; i++ {
+			if n := int(be.Uint32(tables.stss[8+4*i:])); n >= 1 && n <= count {
+				samples[n-1].keyframe = true
+			}
+		}
+	} else {
+		for i := range samples {
+			samples[i].keyframe = true
+		}
+	}
+
+	for i := range samples {
+		samples[i].pts = rescale(samples[i].dts+offsets[i]+t.shift, t.timescale)
+		samples[i].dts = rescale(samples[i].dts+t.shift, t.timescale)
+	}
+	t.samples = samples
+	t.tables = sampleTables{}
+	return nil
+}
+
+// rescale converts a time in units of timescale to Timescale units.
+func rescale(v int64, timescale uint32) int64 {
+	return v * Timescale / int64(timescale)
+}
+
+// span is a byte range [start, end) read with one request.
+type span struct {
+	start, end int64
+	data       []byte
+}
+
+func (d *mp4Demuxer) span(seg Segment) int64 {
+	_, _, spans := d.spans(seg)
+	var size int64
+	for _, sp := range spans {
+		size += sp.end - sp.start
+	}
+	return size
+}
+
+func (d *mp4Demuxer) samples(seg Segment, open Opener) ([]Sample, []Sample, error) {
+	video, audio, spans := d.spans(seg)
+	var size int64
+	for _, sp := range spans {
+		size += sp.end - sp.start
+	}
+	if size > maxSegmentSize {
+		return nil, nil, errSegmentSize
+	}
+	for _, sp := range spans {
+		var err error
+		if sp.data, err = readRange(open, sp.start, sp.end); err != nil {
+			return nil, nil, err
+		}
+	}
+	data := func(s mp4Sample) []byte {
+		i := sort.Search(len(spans), func(i int) bool { return spans[i].end >= s.offset+int64(s.size) })
+		sp := spans[i]
+		return sp.data[s.offset-sp.start : s.offset-sp.start+int64(s.size)]
+	}
+
+	videoSamples := make([]Sample, len(video))
+	for i, s := range video {
+		videoSamples[i] = Sample{PTS: s.pts, DTS: s.dts, Keyframe: s.keyframe, Data: data(s)}
+	}
+	audioSamples := make([]Sample, len(audio))
+	for i, s := range audio {
+		audioSamples[i] = Sample{PTS: s.pts, DTS: s.dts, Keyframe: true, Data: data(s)}
+	}
+	return videoSamples, audioSamples, nil
+}
+
+// spans returns the video and audio samples of seg and the byte ranges
+// they are fetched in.
+func (d *mp4Demuxer) spans(seg Segment) (video, audio []mp4Sample, spans []*span) {
+	video = d.video.samples[seg.first:seg.last]
+	if d.audio != nil {
+		all := d.audio.samples
+		lo := sort.Search(len(all), func(i int) bool { return all[i].pts >= seg.Start })
+		hi := sort.Search(len(all), func(i int) bool { return all[i].pts >= seg.End })
+		if seg.last == len(d.video.samples) {
+			hi = len(all)
+		}
+		audio = all[lo:max(lo, hi)]
+	}
+
+	// samples of a segment lie close together in interleaved files, so they
+	// are fetched in as few ranges as possible
+	byOffset := make([]mp4Sample, 0, len(video)+len(audio))
+	byOffset = append(byOffset, video...)
+	byOffset = append(byOffset, audio...)
+	sort.Slice(byOffset, func(i, j int) bool { return byOffset[i].offset < byOffset[j].offset })
+	for _, s := range byOffset {
+		end := s.offset + int64(s.size)
+		if n := len(spans); n > 0 && s.offset <= spans[n-1].end+maxSpanGap {
+			spans[n-1].end = max(spans[n-1].end, end)
+			continue
+		}
+		spans = append(spans, &span{start: s.offset, end: end})
+	}
+	return video, audio, spans
+}
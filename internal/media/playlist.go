@@ -0,0 +1,27 @@
+package media
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// WritePlaylist writes an HLS VOD playlist of segments with the given
+// durations. uri returns the URI of segment n.
+func WritePlaylist(w io.Writer, durations []time.Duration, uri func(n int) string) error {
+	var target time.Duration
+	for _, d := range durations {
+		target = max(target, d)
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprint(bw, "#EXTM3U\n#EXT-X-VERSION:3\n")
+	fmt.Fprintf(bw, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(target.Seconds())))
+	fmt.Fprint(bw, "#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n")
+	for n, d := range durations {
+		fmt.Fprintf(bw, "#EXTINF:%.3f,\n%s\n", d.Seconds(), uri(n))
+	}
+	fmt.Fprint(bw, "#EXT-X-ENDLIST\n")
+	return bw.Flush()
+}
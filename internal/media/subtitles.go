@@ -0,0 +1,292 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// subtitleReads is how many ranges are read at once for a subtitle
+	// track.
+	subtitleReads = 8
+	// maxSubtitleScan bounds the clusters read for subtitle tracks without
+	// cue points.
+	maxSubtitleScan = 256 * 1024 * 1024
+	// blockWindow is read at the position of a block, which is usually
+	// enough for the whole of a subtitle block.
+	blockWindow = 4 * 1024
+	// defaultCueDuration is used for frames of unknown duration that are
+	// the last of a track.
+	defaultCueDuration = 5 * Timescale
+)
+
+// SubtitleTracks returns the subtitle tracks of the file.
+func (m *Matroska) SubtitleTracks() []*MatroskaTrack {
+	var tracks []*MatroskaTrack
+	for _, t := range m.Tracks {
+		if t.Type == trackSubtitle {
+			tracks = append(tracks, t)
+		}
+	}
+	return tracks
+}
+
+// IsText reports whether the track holds text subtitles that WriteWebVTT can
+// convert. Bitmap formats like VobSub and PGS can't be.
+func (t *MatroskaTrack) IsText() bool {
+	switch t.CodecID {
+	case "S_TEXT/UTF8", "S_TEXT/ASCII", "S_TEXT/ASS", "S_TEXT/SSA", "S_ASS", "S_SSA", "S_TEXT/WEBVTT":
+		return true
+	}
+	return false
+}
+
+// ReadSubtitles reads the frames of a text subtitle track, sorted by PTS.
+// Only the blocks or clusters that the cue points of the track lead to are
+// read. Tracks without cue points are read by scanning every cluster, which
+// is only done for files up to maxSubtitleScan.
+func (m *Matroska) ReadSubtitles(number uint64, open Opener) ([]Block, error) {
+	track := m.Track(number)
+	if track == nil || track.Type != trackSubtitle {
+		return nil, ErrNoTrack
+	}
+	if !track.IsText() {
+		return nil, fmt.Errorf("%w: %s subtitles", ErrUnsupported, track.CodecID)
+	}
+
+	var mu sync.Mutex
+	var blocks []Block
+	add := func(b Block) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		blocks = append(blocks, b)
+		return true
+	}
+	tracks := []uint64{number}
+
+	var cues []cuePoint
+	for _, c := range m.cues {
+		if c.track == number {
+			cues = append(cues, c)
+		}
+	}
+	if len(cues) == 0 {
+		if m.firstCluster == 0 || m.segmentEnd-m.firstCluster > maxSubtitleScan {
+			return nil, fmt.Errorf("%w: subtitle track without cue points", ErrUnsupported)
+		}
+		if err := m.ReadBlocks(open, m.firstCluster, m.segmentEnd, math.MaxInt64, tracks, add); err != nil {
+			return nil, err
+		}
+	} else {
+		headers := &clusterHeaders{lengths: make(map[int64]int), sizes: make(map[int64]int64)}
+		clusters := make(map[int64]bool)
+		var g errgroup.Group
+		g.SetLimit(subtitleReads)
+		for _, c := range cues {
+			c := c
+			if c.relative < 0 {
+				clusters[c.position] = true
+				continue
+			}
+			g.Go(func() error {
+				return m.readCueBlock(open, headers, c, tracks, add)
+			})
+		}
+		for position := range clusters {
+			position := m.segmentStart + position
+			g.Go(func() error {
+				n, size, err := headers.read(open, position, m.segmentEnd)
+				if err != nil {
+					return err
+				}
+				return m.ReadBlocks(open, position, position+int64(n)+size, math.MaxInt64, tracks, add)
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(blocks, func(i, j int) bool { return blocks[i].PTS < blocks[j].PTS })
+	// a block can be reached both through its own cue point and through
+	// its cluster
+	unique := blocks[:0]
+	for i, b := range blocks {
+		if i > 0 && b.PTS == blocks[i-1].PTS && bytes.Equal(b.Data, blocks[i-1].Data) {
+			continue
+		}
+		unique = append(unique, b)
+	}
+	return unique, nil
+}
+
+// readCueBlock reads the block a cue point with a relative position points
+// at. Subtitle blocks have a single frame, whose time is that of the cue.
+func (m *Matroska) readCueBlock(open Opener, headers *clusterHeaders, c cuePoint, tracks []uint64, fn func(b Block) bool) error {
+	cluster := m.segmentStart + c.position
+	n, _, err := headers.read(open, cluster, m.segmentEnd)
+	if err != nil {
+		return err
+	}
+	pos := cluster + int64(n) + c.relative
+	if pos >= m.segmentEnd {
+		return errInvalidMatroska
+	}
+	buf, err := readRange(open, pos, min(pos+blockWindow, m.segmentEnd))
+	if err != nil {
+		return err
+	}
+	id, size, n, err := elementHeader(buf)
+	if err != nil {
+		return err
+	}
+	if id != idSimpleBlock && id != idBlockGroup {
+		return errInvalidMatroska
+	}
+	if size == unknownSize || size > maxBlockSize || pos+int64(n)+size > m.segmentEnd {
+		return errInvalidMatroska
+	}
+	if end := int64(n) + size; end > int64(len(buf)) {
+		rest, err := readRange(open, pos+int64(len(buf)), pos+end)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, rest...)
+	}
+	m.blockElement(id, buf[n:int64(n)+size], 0, tracks, func(b Block) bool {
+		b.PTS = c.time
+		if b.Duration == 0 {
+			b.Duration = c.duration
+		}
+		return fn(b)
+	})
+	return nil
+}
+
+// clusterHeaders remembers the header lengths of the clusters read by
+// ReadSubtitles, as most cue points of a track share their cluster with
+// others.
+type clusterHeaders struct {
+	mu      sync.Mutex
+	lengths map[int64]int
+	sizes   map[int64]int64
+}
+
+// read returns the header length and size of the cluster at pos.
+func (h *clusterHeaders) read(open Opener, pos, segmentEnd int64) (int, int64, error) {
+	h.mu.Lock()
+	n, ok := h.lengths[pos]
+	size := h.sizes[pos]
+	h.mu.Unlock()
+	if ok {
+		return n, size, nil
+	}
+	buf, err := readRange(open, pos, min(pos+12, segmentEnd))
+	if err != nil {
+		return 0, 0, err
+	}
+	id, size, n, err := elementHeader(buf)
+	if err != nil {
+		return 0, 0, err
+	}
+	if id != idCluster {
+		return 0, 0, errInvalidMatroska
+	}
+	if size == unknownSize {
+		return 0, 0, fmt.Errorf("%w: cluster of unknown size", ErrUnsupported)
+	}
+	h.mu.Lock()
+	h.lengths[pos] = n
+	h.sizes[pos] = size
+	h.mu.Unlock()
+	return n, size, nil
+}
+
+var (
+	htmlTag      = regexp.MustCompile(`<[^>]*>`)
+	assOverride  = regexp.MustCompile(`\{[^}]*\}`)
+	assDrawing   = regexp.MustCompile(`\{[^}]*\\p[1-9][^}]*\}`)
+	vttEscaper   = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	assLineBreak = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ")
+)
+
+// WriteWebVTT writes the frames of a text subtitle track as a WebVTT file.
+// SRT keeps its italic, bold and underline tags; ASS and SSA lose their
+// styling.
+func WriteWebVTT(w io.Writer, track *MatroskaTrack, blocks []Block) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprint(bw, "WEBVTT\n")
+	for i, b := range blocks {
+		text := cueText(track.CodecID, b.Data)
+		if text == "" {
+			continue
+		}
+		end := b.PTS + b.Duration
+		if b.Duration == 0 {
+			end = b.PTS + defaultCueDuration
+			if i+1 < len(blocks) && blocks[i+1].PTS > b.PTS {
+				end = min(end, blocks[i+1].PTS)
+			}
+		}
+		fmt.Fprintf(bw, "\n%s --> %s\n%s\n", vttTime(b.PTS), vttTime(end), text)
+	}
+	return bw.Flush()
+}
+
+// cueText converts the text of a subtitle frame to a WebVTT cue payload,
+// which can't hold blank lines.
+func cueText(codecID string, data []byte) string {
+	text := strings.ReplaceAll(string(bytes.TrimRight(data, "\x00")), "\r\n", "\n")
+	switch codecID {
+	case "S_TEXT/ASS", "S_TEXT/SSA", "S_ASS", "S_SSA":
+		// ReadOrder, Layer, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+		fields := strings.SplitN(text, ",", 9)
+		if len(fields) < 9 || assDrawing.MatchString(fields[8]) {
+			return ""
+		}
+		text = assLineBreak.Replace(assOverride.ReplaceAllString(fields[8], ""))
+		text = vttEscaper.Replace(text)
+	case "S_TEXT/WEBVTT":
+	default:
+		text = srtText(text)
+	}
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// srtText escapes SRT text for WebVTT, keeping the tags both understand and
+// dropping others like <font>.
+func srtText(text string) string {
+	var sb strings.Builder
+	last := 0
+	for _, loc := range htmlTag.FindAllStringIndex(text, -1) {
+		sb.WriteString(vttEscaper.Replace(text[last:loc[0]]))
+		switch tag := strings.ToLower(strings.ReplaceAll(text[loc[0]:loc[1]], " ", "")); tag {
+		case "<i>", "</i>", "<b>", "</b>", "<u>", "</u>":
+			sb.WriteString(tag)
+		}
+		last = loc[1]
+	}
+	sb.WriteString(vttEscaper.Replace(text[last:]))
+	return sb.String()
+}
+
+// vttTime formats a time in Timescale units as HH:MM:SS.mmm.
+func vttTime(t int64) string {
+	ms := max(t, 0) * 1000 / Timescale
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
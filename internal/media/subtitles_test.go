@@ -0,0 +1,175 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+type testCue struct {
+	ms, duration int64
+	text         string
+}
+
+var testCues = []testCue{
+	{ms: 500, duration: 1000, text: "first"},
+	{ms: 2500, duration: 800, text: "second"},
+	{ms: 3400, duration: 400, text: "third"},
+	{ms: 4200, duration: 1500, text: "fourth"},
+}
+
+// subtitleFile returns a file with a video track 1 and a subtitle track 3
+// holding testCues, in clusters of 2 seconds.
+func subtitleFile(codec string, cueTracks []uint64, relativeCues bool) []byte {
+	f := &mkvFile{
+		durationMs: 6000,
+		tracks: []mkvTrack{
+			{number: 1, typ: trackVideo, codec: "V_MPEG4/ISO/AVC", private: testAVCC()},
+			{number: 3, typ: trackSubtitle, codec: codec, language: "eng"},
+		},
+		cueTracks:    cueTracks,
+		relativeCues: relativeCues,
+	}
+	for ms := int64(0); ms < 6000; ms += 2000 {
+		c := mkvCluster{timecode: ms, blocks: []mkvBlock{{track: 1, ms: ms, keyframe: true, data: testVideoFrame(int(ms/testFrameMs), true)}}}
+		for _, cue := range testCues {
+			if cue.ms >= ms && cue.ms < ms+2000 {
+				c.blocks = append(c.blocks, mkvBlock{track: 3, ms: cue.ms, duration: cue.duration, keyframe: true, data: []byte(cue.text)})
+			}
+		}
+		f.clusters = append(f.clusters, c)
+	}
+	return f.build()
+}
+
+func TestReadSubtitles(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		track uint64
+		err   error
+	}{
+		{name: "cues with relative positions", data: subtitleFile("S_TEXT/UTF8", []uint64{1, 3}, true), track: 3},
+		{name: "cues with cluster positions", data: subtitleFile("S_TEXT/UTF8", []uint64{1, 3}, false), track: 3},
+		{name: "no cues for the track", data: subtitleFile("S_TEXT/UTF8", []uint64{1}, true), track: 3},
+		{name: "no cues at all", data: subtitleFile("S_TEXT/UTF8", nil, false), track: 3},
+		{name: "bitmap subtitles", data: subtitleFile("S_HDMV/PGS", []uint64{3}, true), track: 3, err: ErrUnsupported},
+		{name: "not a subtitle track", data: subtitleFile("S_TEXT/UTF8", []uint64{3}, true), track: 1, err: ErrNoTrack},
+		{name: "no such track", data: subtitleFile("S_TEXT/UTF8", []uint64{3}, true), track: 2, err: ErrNoTrack},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ReadMatroska(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tracks := m.SubtitleTracks(); len(tracks) != 1 || tracks[0].Number != 3 || tracks[0].Language != "eng" {
+				t.Fatalf("subtitle tracks %+v", tracks)
+			}
+			opener := &rangeOpener{data: tt.data}
+			blocks, err := m.ReadSubtitles(tt.track, opener.open)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(blocks) != len(testCues) {
+				t.Fatalf("got %d blocks, want %d", len(blocks), len(testCues))
+			}
+			for i, cue := range testCues {
+				b := blocks[i]
+				if b.Track != 3 || b.PTS != cue.ms*Timescale/1000 || b.Duration != cue.duration*Timescale/1000 || string(b.Data) != cue.text {
+					t.Errorf("block %d: got %+v, want %+v", i, b, cue)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteWebVTT(t *testing.T) {
+	second := int64(Timescale)
+	tests := []struct {
+		name   string
+		codec  string
+		blocks []Block
+		want   string
+	}{
+		{
+			name:  "srt",
+			codec: "S_TEXT/UTF8",
+			blocks: []Block{
+				{PTS: second, Duration: 2 * second, Data: []byte("<i>Hello</i>\r\n<font color=\"red\">world</font>")},
+				{PTS: 3661 * second, Duration: second / 2, Data: []byte("Tom & Jerry <3\x00")},
+			},
+			want: "WEBVTT\n\n00:00:01.000 --> 00:00:03.000\n<i>Hello</i>\nworld\n\n01:01:01.000 --> 01:01:01.500\nTom &amp; Jerry &lt;3\n",
+		},
+		{
+			name:  "srt blank lines and no duration",
+			codec: "S_TEXT/UTF8",
+			blocks: []Block{
+				{PTS: 0, Data: []byte("one\n\n\ntwo")},
+				{PTS: 2 * second, Data: []byte("  ")},
+				{PTS: 4 * second, Data: []byte("three")},
+				{PTS: 10 * second, Data: []byte("last")},
+			},
+			want: "WEBVTT\n\n00:00:00.000 --> 00:00:02.000\none\ntwo\n\n00:00:04.000 --> 00:00:09.000\nthree\n\n00:00:10.000 --> 00:00:15.000\nlast\n",
+		},
+		{
+			name:  "ass",
+			codec: "S_TEXT/ASS",
+			blocks: []Block{
+				{PTS: second, Duration: second, Data: []byte(`1,0,Default,,0,0,0,,{\i1}Hello,{\i0} there\Nnew line\hhere <3`)},
+				{PTS: 2 * second, Duration: second, Data: []byte(`2,0,Default,,0,0,0,,{\p1}m 0 0 l 100 0 100 100{\p0}`)},
+				{PTS: 3 * second, Duration: second, Data: []byte(`not an event`)},
+			},
+			want: "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nHello, there\nnew line here &lt;3\n",
+		},
+		{
+			name:  "webvtt",
+			codec: "S_TEXT/WEBVTT",
+			blocks: []Block{
+				{PTS: second, Duration: second, Data: []byte("<c.yellow>kept</c>")},
+			},
+			want: "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\n<c.yellow>kept</c>\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteWebVTT(&buf, &MatroskaTrack{CodecID: tt.codec}, tt.blocks); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestSubtitlesToWebVTT(t *testing.T) {
+	data := subtitleFile("S_TEXT/UTF8", []uint64{1, 3}, true)
+	m, err := ReadMatroska(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := m.ReadSubtitles(3, (&rangeOpener{data: data}).open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteWebVTT(&buf, m.Track(3), blocks); err != nil {
+		t.Fatal(err)
+	}
+	want := "WEBVTT\n\n" +
+		"00:00:00.500 --> 00:00:01.500\nfirst\n\n" +
+		"00:00:02.500 --> 00:00:03.300\nsecond\n\n" +
+		"00:00:03.400 --> 00:00:03.800\nthird\n\n" +
+		"00:00:04.200 --> 00:00:05.700\nfourth\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,225 @@
+package media
+
+import (
+	"bufio"
+	"io"
+)
+
+const (
+	tsPacketSize = 188
+	pmtPID       = 0x1000
+	videoPID     = 0x100
+	audioPID     = 0x101
+
+	// tsOffset is added to all timestamps so that decoding times computed
+	// for reordered frames never go below zero.
+	tsOffset = Timescale * 14 / 10
+	// pcrDelay is how far the program clock runs behind decoding times.
+	pcrDelay = Timescale * 7 / 10
+)
+
+// tsWriter packetizes PSI tables and PES packets into MPEG-TS.
+type tsWriter struct {
+	w          *bufio.Writer
+	continuity map[uint16]byte
+	packet     [tsPacketSize]byte
+}
+
+// writeTS muxes the samples of a segment into an MPEG-TS stream with a video
+// and an optional audio elementary stream.
+func writeTS(w io.Writer, video *Track, audio *Track, videoSamples, audioSamples []Sample) error {
+	tw := &tsWriter{w: bufio.NewWriterSize(w, 64*1024), continuity: map[uint16]byte{}}
+	if err := tw.writePSI(0, patSection()); err != nil {
+		return err
+	}
+	if err := tw.writePSI(pmtPID, pmtSection(audio)); err != nil {
+		return err
+	}
+	i, j := 0, 0
+	for i < len(videoSamples) || j < len(audioSamples) {
+		var err error
+		if j >= len(audioSamples) || i < len(videoSamples) && videoSamples[i].DTS <= audioSamples[j].PTS {
+			s := videoSamples[i]
+			err = tw.writePES(videoPID, 0xE0, video.annexB(s), s.PTS, s.DTS, s.Keyframe)
+			i++
+		} else {
+			s := audioSamples[j]
+			data := s.Data
+			if audio.Codec == CodecAAC {
+				data = audio.aac.adts(data)
+			}
+			err = tw.writePES(audioPID, 0xC0, data, s.PTS, s.PTS, false)
+			j++
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tw.w.Flush()
+}
+
+func patSection() []byte {
+	return withCRC([]byte{
+		0x00, 0xB0, 13, // table id, section length
+		0x00, 0x01, 0xC1, 0x00, 0x00, // transport stream id, version, section numbers
+		0x00, 0x01, 0xE0 | pmtPID>>8, pmtPID & 0xFF, // program 1
+	})
+}
+
+func pmtSection(audio *Track) []byte {
+	streams := [][]byte{{0x1B, 0xE0 | videoPID>>8, videoPID & 0xFF, 0xF0, 0x00}}
+	if audio != nil {
+		streamType := byte(0x0F)
+		if audio.Codec == CodecMP3 {
+			streamType = 0x03
+		}
+		streams = append(streams, []byte{streamType, 0xE0 | audioPID>>8, audioPID & 0xFF, 0xF0, 0x00})
+	}
+	section := []byte{
+		0x02, 0xB0, byte(13 + 5*len(streams)), // table id, section length
+		0x00, 0x01, 0xC1, 0x00, 0x00, // program number, version, section numbers
+		0xE0 | videoPID>>8, videoPID & 0xFF, 0xF0, 0x00, // PCR PID, program info length
+	}
+	for _, stream := range streams {
+		section = append(section, stream...)
+	}
+	return withCRC(section)
+}
+
+// withCRC appends the CRC-32/MPEG-2 of a PSI section.
+func withCRC(section []byte) []byte {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range section {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return append(section, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+}
+
+func (tw *tsWriter) header(pid uint16, start bool, adaptation bool) {
+	p := tw.packet[:]
+	p[0] = 0x47
+	p[1] = byte(pid>>8) & 0x1F
+	if start {
+		p[1] |= 0x40
+	}
+	p[2] = byte(pid)
+	control := byte(0x10)
+	if adaptation {
+		control = 0x30
+	}
+	cc := tw.continuity[pid]
+	tw.continuity[pid] = (cc + 1) & 0x0F
+	p[3] = control | cc
+}
+
+func (tw *tsWriter) writePSI(pid uint16, section []byte) error {
+	tw.header(pid, true, false)
+	p := tw.packet[4:]
+	p[0] = 0 // pointer field
+	n := copy(p[1:], section)
+	for i := 1 + n; i < len(p); i++ {
+		p[i] = 0xFF
+	}
+	_, err := tw.w.Write(tw.packet[:])
+	return err
+}
+
+// writePES writes payload as one PES packet. The first TS packet of video
+// PES packets carries the program clock, and that of keyframes the random
+// access flag.
+func (tw *tsWriter) writePES(pid uint16, streamID byte, payload []byte, pts, dts int64, keyframe bool) error {
+	pts += tsOffset
+	dts += tsOffset
+	withDTS := dts != pts
+	headerLength := 5
+	if withDTS {
+		headerLength = 10
+	}
+	pes := make([]byte, 9+headerLength, 9+headerLength+len(payload))
+	pes[2] = 1
+	pes[3] = streamID
+	if length := 3 + headerLength + len(payload); length <= 0xFFFF {
+		pes[4] = byte(length >> 8)
+		pes[5] = byte(length)
+	}
+	pes[6] = 0x80
+	pes[8] = byte(headerLength)
+	if withDTS {
+		pes[7] = 0xC0
+		putTimestamp(pes[9:], 0x3, pts)
+		putTimestamp(pes[14:], 0x1, dts)
+	} else {
+		pes[7] = 0x80
+		putTimestamp(pes[9:], 0x2, pts)
+	}
+	data := append(pes, payload...)
+
+	for first := true; len(data) > 0; first = false {
+		var adaptation []byte
+		if first && streamID == 0xE0 {
+			flags := byte(0x10)
+			if keyframe {
+				flags |= 0x40
+			}
+			adaptation = append([]byte{flags}, pcr(dts-pcrDelay)...)
+		}
+		space := tsPacketSize - 4
+		if adaptation != nil {
+			space -= 1 + len(adaptation)
+		}
+		if stuffing := space - len(data); stuffing > 0 {
+			if adaptation == nil {
+				// the length byte alone is one byte of stuffing
+				stuffing--
+				space--
+				adaptation = []byte{}
+				if stuffing > 0 {
+					adaptation = append(adaptation, 0x00)
+					stuffing--
+					space--
+				}
+			}
+			for ; stuffing > 0; stuffing-- {
+				adaptation = append(adaptation, 0xFF)
+				space--
+			}
+		}
+		tw.header(pid, first, adaptation != nil)
+		p := tw.packet[4:]
+		if adaptation != nil {
+			p[0] = byte(len(adaptation))
+			copy(p[1:], adaptation)
+			p = p[1+len(adaptation):]
+		}
+		copy(p, data[:space])
+		data = data[space:]
+		if _, err := tw.w.Write(tw.packet[:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putTimestamp writes a 33 bit PTS or DTS with its 4 bit prefix.
+func putTimestamp(b []byte, prefix byte, ts int64) {
+	b[0] = prefix<<4 | byte(ts>>29)&0x0E | 1
+	b[1] = byte(ts >> 22)
+	b[2] = byte(ts>>14) | 1
+	b[3] = byte(ts >> 7)
+	b[4] = byte(ts<<1) | 1
+}
+
+// pcr encodes a program clock reference with a zero extension.
+func pcr(base int64) []byte {
+	if base < 0 {
+		base = 0
+	}
+	return []byte{byte(base >> 25), byte(base >> 17), byte(base >> 9), byte(base >> 1), byte(base<<7) | 0x7E, 0x00}
+}
@@ -0,0 +1,261 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/database"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// apiKeyContextKey is the gin context key of the *database.APIKey of a
+// request to the API.
+const apiKeyContextKey = "apiKey"
+
+func (e *allRoutes) LoadAPI(r *Route) {
+	log := e.log.Named("API")
+	defer log.Info("Loaded api routes")
+	api := r.Engine.Group("/api/v1", apiKeyAuth)
+	api.POST("/links", createLinkRoute)
+}
+
+// apiKeyAuth only lets requests through that carry an API key created with
+// /addapikey, either as a bearer token or in X-API-Key.
+func apiKeyAuth(ctx *gin.Context) {
+	key, ok := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+	if !ok {
+		key = ctx.GetHeader("X-API-Key")
+	}
+	if key == "" {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, types.ErrorResponse{Error: "unauthorized"})
+		return
+	}
+	apiKey, err := database.DB.GetAPIKeyByHash(utils.HashAPIKey(key))
+	if errors.Is(err, database.ErrAPIKeyNotFound) {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, types.ErrorResponse{Error: "unauthorized"})
+		return
+	}
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, types.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if len(config.ValueOf.AllowedUsers) != 0 && !utils.Contains(config.ValueOf.AllowedUsers, apiKey.UserID) {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, types.ErrorResponse{Error: "the user of this API key is not allowed to use this bot"})
+		return
+	}
+	// last use is only tracked to the minute, so that busy keys don't
+	// write on every request
+	if now := time.Now(); apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > time.Minute {
+		database.DB.TouchAPIKey(apiKey.ID, now)
+	}
+	ctx.Set(apiKeyContextKey, apiKey)
+	ctx.Next()
+}
+
+// createLinkRoute returns the links to a file. The file is either an existing
+// log channel message of the user of the API key, or message_id of chat_id,
+// which the bot forwards to the log channel first like it does with files
+// sent to it. The user must be in chat_id, and the file must fit in their
+// quota. Keys of the admin can link any log channel message.
+func createLinkRoute(ctx *gin.Context) {
+	var body struct {
+		MessageID int    `json:"message_id" binding:"required"`
+		ChatID    int64  `json:"chat_id"`
+		TTL       int64  `json:"ttl"` // seconds, 0 for links that don't expire
+		IP        string `json:"ip"`  // the only address the links work from
+	}
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, types.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if body.TTL < 0 {
+		ctx.JSON(http.StatusBadRequest, types.ErrorResponse{Error: "ttl must not be negative"})
+		return
+	}
+	if body.IP != "" {
+		ip := net.ParseIP(body.IP)
+		if ip == nil {
+			ctx.JSON(http.StatusBadRequest, types.ErrorResponse{Error: "invalid ip"})
+			return
+		}
+		body.IP = ip.String()
+	}
+	apiKey := ctx.MustGet(apiKeyContextKey).(*database.APIKey)
+
+	messageID := body.MessageID
+	var file *types.File
+	if body.ChatID != 0 {
+		tgCtx := bot.Bot.CreateContext()
+		chatID := peerChatID(body.ChatID)
+		if tgCtx.PeerStorage.GetPeerById(chatID).ID == 0 {
+			ctx.JSON(http.StatusBadRequest, types.ErrorResponse{Error: fmt.Sprintf(
+				"unknown chat_id %d: the bot must be in the chat, whose ID can be given as shown by Telegram clients (-100… for channels) or without the prefix", body.ChatID)})
+			return
+		}
+		member, err := utils.IsChatMember(tgCtx, tgCtx.Raw, tgCtx.PeerStorage, chatID, apiKey.UserID)
+		if err != nil {
+			ctx.JSON(http.StatusBadGateway, types.ErrorResponse{Error: err.Error()})
+			return
+		}
+		if !member {
+			ctx.JSON(http.StatusForbidden, types.ErrorResponse{Error: "the user of this API key is not in this chat"})
+			return
+		}
+		message, err := utils.GetChatMessage(tgCtx, tgCtx.Raw, tgCtx.PeerStorage, chatID, body.MessageID)
+		if err != nil {
+			ctx.JSON(http.StatusNotFound, types.ErrorResponse{Error: err.Error()})
+			return
+		}
+		source, err := utils.FileFromMedia(message.Media)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, types.ErrorResponse{Error: err.Error()})
+			return
+		}
+		if !checkAPIQuota(ctx, apiKey.UserID, source.FileSize) {
+			return
+		}
+		messageID, file, err = forwardToLogChannel(tgCtx, chatID, body.MessageID)
+		if err != nil {
+			ctx.JSON(http.StatusBadGateway, types.ErrorResponse{Error: err.Error()})
+			return
+		}
+		recordFile(messageID, file, apiKey.UserID)
+	} else {
+		revoked, err := database.DB.IsRevoked(messageID)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, types.ErrorResponse{Error: err.Error()})
+			return
+		}
+		if revoked {
+			ctx.JSON(http.StatusGone, types.ErrorResponse{Error: "this link has been revoked"})
+			return
+		}
+		if apiKey.UserID != config.ValueOf.AdminUserID {
+			owned, err := database.DB.GetFileByMessageID(messageID)
+			if err != nil && !errors.Is(err, database.ErrFileNotFound) {
+				ctx.JSON(http.StatusInternalServerError, types.ErrorResponse{Error: err.Error()})
+				return
+			}
+			if err != nil || owned.UserID != apiKey.UserID {
+				ctx.JSON(http.StatusForbidden, types.ErrorResponse{Error: "this file doesn't belong to the user of this API key"})
+				return
+			}
+		}
+		worker := bot.GetNextWorker()
+		file, err = utils.FileFromMessage(ctx, worker.Client, messageID)
+		if err != nil {
+			ctx.JSON(http.StatusNotFound, types.ErrorResponse{Error: err.Error()})
+			return
+		}
+	}
+	ctx.JSON(http.StatusOK, linkResponse(messageID, file, time.Duration(body.TTL)*time.Second, body.IP))
+}
+
+// peerChatID converts a chat ID of the Bot API, which Telegram clients show
+// as -100… for channels and supergroups and as negative for groups, to the
+// ID of the peer the bot stores. IDs without the prefix are kept.
+func peerChatID(chatID int64) int64 {
+	const channelOffset = 1000000000000
+	switch {
+	case chatID < -channelOffset:
+		return -chatID - channelOffset
+	case chatID < 0:
+		return -chatID
+	}
+	return chatID
+}
+
+// forwardToLogChannel forwards messageID of chatID to the log channel and
+// returns the ID and file of the new message.
+func forwardToLogChannel(tgCtx *ext.Context, chatID int64, messageID int) (int, *types.File, error) {
+	update, err := utils.ForwardMessages(tgCtx, chatID, config.ValueOf.LogChannelID, messageID)
+	if err != nil {
+		return 0, nil, err
+	}
+	return utils.FileFromUpdates(update)
+}
+
+// checkAPIQuota makes sure a file of fileSize bytes fits in the quota of
+// userID. It writes the response and returns false if it doesn't.
+func checkAPIQuota(ctx *gin.Context, userID int64, fileSize int64) bool {
+	reason, resetAt, err := bot.CheckQuota(userID, fileSize)
+	if err != nil {
+		log.Error("Failed to check quota", zap.Int64("userID", userID), zap.Error(err))
+		return true
+	}
+	if reason == "" {
+		return true
+	}
+	if resetAt.IsZero() {
+		ctx.JSON(http.StatusRequestEntityTooLarge, types.ErrorResponse{Error: reason})
+		return false
+	}
+	ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(resetAt).Seconds()))))
+	ctx.JSON(http.StatusTooManyRequests, types.ErrorResponse{Error: reason})
+	return false
+}
+
+// recordFile stores a file forwarded to the log channel for userID, as the
+// bot does for the files sent to it.
+func recordFile(messageID int, file *types.File, userID int64) {
+	addFile(messageID, file, userID)
+	if err := database.DB.AddLinkGeneration(userID, file.FileSize); err != nil {
+		log.Error("Failed to record link generation", zap.Int64("userID", userID), zap.Error(err))
+	}
+}
+
+// addFile stores a file posted to the log channel for userID, without
+// counting it against the quota.
+func addFile(messageID int, file *types.File, userID int64) {
+	err := database.DB.AddFile(&database.File{
+		MessageID: messageID,
+		UserID:    userID,
+		FileName:  file.FileName,
+		FileSize:  file.FileSize,
+		MimeType:  file.MimeType,
+		FileID:    file.ID,
+		Hash:      fileHash(file),
+	})
+	if err != nil {
+		log.Error("Failed to record file", zap.Int("messageID", messageID), zap.Error(err))
+	}
+}
+
+func fileHash(file *types.File) string {
+	return utils.GetShortHash(utils.PackFile(file.FileName, file.FileSize, file.MimeType, file.ID))
+}
+
+// linkResponse returns the signed links to messageID, valid for ttl (0 for
+// ever) and, if clientIP is set, only from that address.
+func linkResponse(messageID int, file *types.File, ttl time.Duration, clientIP string) types.LinkResponse {
+	query := utils.LinkQuery(messageID, file.ID, ttl, clientIP)
+	link := func(route string) string {
+		return fmt.Sprintf("%s/%s/%d?%s", config.ValueOf.Host, route, messageID, query)
+	}
+	response := types.LinkResponse{
+		Ok:          true,
+		MessageID:   messageID,
+		StreamURL:   link("stream"),
+		DownloadURL: link("stream") + "&d=true",
+		WatchURL:    link("watch"),
+		Hash:        fileHash(file),
+		File:        file.Info(),
+	}
+	if ttl > 0 {
+		expiresAt := time.Now().Add(ttl).UTC().Truncate(time.Second)
+		response.ExpiresAt = &expiresAt
+	}
+	return response
+}
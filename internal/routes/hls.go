@@ -0,0 +1,216 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/media"
+	"EverythingSuckz/fsb/internal/throttle"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	// playlists are cached as long as file properties
+	hlsPlaylistTTL = 3600
+	// maxHLSIndexes is how many container indexes are kept in memory
+	maxHLSIndexes = 4
+	// mediaReadTimeout bounds reading the head of a file. The read is shared
+	// by all the requests waiting for it, so it isn't tied to any of them.
+	mediaReadTimeout = time.Minute
+)
+
+// hlsIndexes keeps the last few container indexes, so that the segments of
+// a playlist being watched don't each read the index again.
+var hlsIndexes = newRecent[int64, *media.Index](maxHLSIndexes)
+
+func (e *allRoutes) LoadHLS(r *Route) {
+	log := e.log.Named("HLS")
+	defer log.Info("Loaded hls route")
+	r.Engine.GET("/hls/:messageID/:name", getHLSRoute)
+}
+
+// getHLSRoute serves index.m3u8, an HLS playlist cut at the keyframes of the
+// file of a link, and its segments <n>.ts, remuxed into MPEG-TS on demand.
+// It takes the same query parameters as /stream and passes them on to the
+// segments.
+func getHLSRoute(ctx *gin.Context) {
+	messageID, ok := linkMessageID(ctx)
+	if !ok {
+		return
+	}
+
+	worker := bot.GetNextWorker()
+	worker.StartStream()
+	defer worker.EndStream()
+
+	file, ok := linkedFile(ctx, worker, messageID)
+	if !ok {
+		return
+	}
+
+	name := ctx.Param("name")
+	if name == "index.m3u8" {
+		serveHLSPlaylist(ctx, worker, file)
+		return
+	}
+	segment, err := strconv.Atoi(strings.TrimSuffix(name, ".ts"))
+	if err != nil || segment < 0 || !strings.HasSuffix(name, ".ts") {
+		http.Error(ctx.Writer, "not found", http.StatusNotFound)
+		return
+	}
+	serveHLSSegment(ctx, worker, messageID, file, segment)
+}
+
+func serveHLSPlaylist(ctx *gin.Context, worker *bot.Worker, file *types.File) {
+	key := fmt.Sprintf("hls:%d", file.ID)
+	var durations []time.Duration
+	if data, err := cache.GetCache().GetBytes(key); err == nil {
+		durations = decodeDurations(data)
+	} else {
+		index, err := loadHLSIndex(worker, file)
+		if err != nil {
+			mediaError(ctx, err)
+			return
+		}
+		durations = index.Durations()
+		if err := cache.GetCache().SetBytes(key, encodeDurations(durations), hlsPlaylistTTL); err != nil {
+			log.Debug("Playlist not cached", zap.Int64("fileID", file.ID), zap.Error(err))
+		}
+	}
+
+	query := ctx.Request.URL.RawQuery
+	ctx.Header("Content-Type", "application/vnd.apple.mpegurl")
+	// the playlist holds the signed link
+	ctx.Header("Cache-Control", "private, no-cache")
+	ctx.Status(http.StatusOK)
+	err := media.WritePlaylist(ctx.Writer, durations, func(n int) string {
+		return strconv.Itoa(n) + ".ts?" + query
+	})
+	if err != nil {
+		log.Error("Error while writing playlist", zap.Error(err))
+	}
+}
+
+func serveHLSSegment(ctx *gin.Context, worker *bot.Worker, messageID int, file *types.File, segment int) {
+	index, err := loadHLSIndex(worker, file)
+	if err != nil {
+		mediaError(ctx, err)
+		return
+	}
+	// the bytes fetched from Telegram are reserved before they are read,
+	// and the MPEG-TS overhead once the size of the segment is known
+	reserved, err := index.SegmentSpan(segment)
+	if err != nil {
+		mediaError(ctx, err)
+		return
+	}
+	if !reserveBytes(ctx, messageID, reserved) {
+		return
+	}
+	var written int64
+	defer func() { releaseBytes(messageID, reserved-written) }()
+	samples, err := index.ReadSegment(segment, func(start, end int64) (io.ReadCloser, error) {
+		return newStreamReader(ctx, worker, messageID, file, start, end)
+	})
+	if err != nil {
+		mediaError(ctx, err)
+		return
+	}
+
+	// the segment is remuxed while it is written, so only its samples are
+	// held in memory
+	size := samples.Size()
+	if size > reserved {
+		if !reserveBytes(ctx, messageID, size-reserved) {
+			return
+		}
+		reserved = size
+	}
+
+	ctx.Header("Content-Type", "video/mp2t")
+	ctx.Header("Content-Length", strconv.FormatInt(size, 10))
+	ctx.Header("Cache-Control", "private, max-age=3600")
+	ctx.Status(http.StatusOK)
+	out := throttle.GetThrottle().Writer(ctx, ctx.Writer, linkOwner(messageID))
+	defer out.Close()
+	written, err = samples.WriteTo(out)
+	if err != nil {
+		log.Error("Error while copying segment", zap.Error(err))
+	}
+}
+
+// mediaError writes the response for an error from the media package.
+func mediaError(ctx *gin.Context, err error) {
+	switch {
+	case isLimitError(err):
+		limitError(ctx, err)
+	case errors.Is(err, media.ErrUnsupported):
+		http.Error(ctx.Writer, err.Error(), http.StatusUnsupportedMediaType)
+	case errors.Is(err, media.ErrNoSegment), errors.Is(err, media.ErrNoTrack):
+		http.Error(ctx.Writer, err.Error(), http.StatusNotFound)
+	default:
+		log.Error("Media error", zap.Error(err))
+		http.Error(ctx.Writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// loadHLSIndex returns the container index of file, reading it from
+// Telegram if it isn't in memory. Concurrent requests for the same file
+// share one read.
+func loadHLSIndex(worker *bot.Worker, file *types.File) (*media.Index, error) {
+	if file.FileSize == 0 {
+		return nil, fmt.Errorf("%w: photo", media.ErrUnsupported)
+	}
+	return hlsIndexes.get(file.ID, func() (*media.Index, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), mediaReadTimeout)
+		defer cancel()
+		start := time.Now()
+		r := utils.NewTelegramReaderAt(ctx, worker.Client, file.Location, file.FileSize)
+		index, err := media.NewIndex(r, file.FileSize)
+		if err != nil {
+			return nil, err
+		}
+		log.Info("Read container index",
+			zap.Int64("fileID", file.ID),
+			zap.String("container", index.Container),
+			zap.Int("segments", len(index.Segments)),
+			zap.Duration("took", time.Since(start)),
+		)
+		return index, nil
+	})
+}
+
+// encodeDurations packs segment durations in milliseconds, so that the
+// playlists of long videos still fit in a cache entry.
+func encodeDurations(durations []time.Duration) []byte {
+	var data []byte
+	for _, d := range durations {
+		data = binary.AppendUvarint(data, uint64(d.Milliseconds()))
+	}
+	return data
+}
+
+func decodeDurations(data []byte) []time.Duration {
+	var durations []time.Duration
+	for len(data) > 0 {
+		ms, n := binary.Uvarint(data)
+		if n <= 0 {
+			break
+		}
+		durations = append(durations, time.Duration(ms)*time.Millisecond)
+		data = data[n:]
+	}
+	return durations
+}
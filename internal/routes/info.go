@@ -0,0 +1,35 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/types"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (e *allRoutes) LoadInfo(r *Route) {
+	log := e.log.Named("Info")
+	defer log.Info("Loaded info route")
+	r.Engine.GET("/info/:messageID", getInfoRoute)
+}
+
+// getInfoRoute returns the metadata of the file of a link as JSON. It takes
+// the same query parameters as /stream.
+func getInfoRoute(ctx *gin.Context) {
+	messageID, ok := linkMessageID(ctx)
+	if !ok {
+		return
+	}
+
+	worker := bot.GetNextWorker()
+	worker.StartStream()
+	defer worker.EndStream()
+
+	file, ok := linkedFile(ctx, worker, messageID)
+	if !ok {
+		return
+	}
+	ctx.Header("Access-Control-Allow-Origin", "*")
+	ctx.JSON(http.StatusOK, types.InfoResponse{Ok: true, FileInfo: file.Info()})
+}
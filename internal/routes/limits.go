@@ -0,0 +1,53 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/database"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// reserveBytes counts n bytes against the limits of the link before they are
+// sent. It writes the error response and returns false if the link is used up.
+func reserveBytes(ctx *gin.Context, messageID int, n int64) bool {
+	if err := database.DB.ReserveBytes(messageID, n); err != nil {
+		limitError(ctx, err)
+		return false
+	}
+	return true
+}
+
+// limitError writes the response for an error of database.ReserveBytes.
+func limitError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, database.ErrDownloadLimitReached), errors.Is(err, database.ErrByteLimitReached):
+		http.Error(ctx.Writer, err.Error(), http.StatusGone)
+	case errors.Is(err, database.ErrLimitExceeded):
+		http.Error(ctx.Writer, err.Error(), http.StatusTooManyRequests)
+	default:
+		http.Error(ctx.Writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// isLimitError tells whether err is from a link that is used up.
+func isLimitError(err error) bool {
+	return errors.Is(err, database.ErrDownloadLimitReached) ||
+		errors.Is(err, database.ErrByteLimitReached) ||
+		errors.Is(err, database.ErrLimitExceeded)
+}
+
+// chargeBytes counts n bytes fetched from Telegram for the link against its
+// limits, for reads that aren't sent as they are, like the parts of a file
+// subtitles are converted from. They are not given back.
+func chargeBytes(messageID int, n int64) error {
+	return database.DB.ReserveBytes(messageID, n)
+}
+
+// releaseBytes gives back the reserved bytes that were not sent.
+func releaseBytes(messageID int, n int64) {
+	if err := database.DB.ReleaseBytes(messageID, n); err != nil {
+		log.Error("Failed to release bytes", zap.Error(err), zap.Int("messageID", messageID))
+	}
+}
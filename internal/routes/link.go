@@ -0,0 +1,114 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/database"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// linkMessageID returns the :messageID of a link after the checks that don't
+// need the file. It writes the error response and returns false if the
+// request must not go on.
+func linkMessageID(ctx *gin.Context) (int, bool) {
+	messageID, err := strconv.Atoi(ctx.Param("messageID"))
+	if err != nil {
+		http.Error(ctx.Writer, err.Error(), http.StatusBadRequest)
+		return 0, false
+	}
+	revoked, err := database.DB.IsRevoked(messageID)
+	if err != nil {
+		http.Error(ctx.Writer, err.Error(), http.StatusInternalServerError)
+		return 0, false
+	}
+	if revoked {
+		http.Error(ctx.Writer, "this link has been revoked", http.StatusGone)
+		return 0, false
+	}
+	if ctx.Query("sig") == "" && ctx.Query("hash") == "" {
+		http.Error(ctx.Writer, "missing sig param", http.StatusBadRequest)
+		return 0, false
+	}
+	return messageID, true
+}
+
+// linkedFile fetches the file of a link through worker and checks that the
+// link is valid for it and that its password was given. It writes the error
+// response and returns false if the request must not go on.
+func linkedFile(ctx *gin.Context, worker *bot.Worker, messageID int) (*types.File, bool) {
+	file, err := utils.FileFromMessage(ctx, worker.Client, messageID)
+	if err != nil {
+		http.Error(ctx.Writer, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if status, err := checkLinkAuth(ctx, messageID, file); err != nil {
+		http.Error(ctx.Writer, err.Error(), status)
+		return nil, false
+	}
+	if !checkFilePassword(ctx, messageID) {
+		return nil, false
+	}
+	return file, true
+}
+
+// checkLinkAuth verifies the query parameters of a link to file. Signed links
+// carry sig and optionally exp and ip; links generated before signing existed
+// carry hash and are only accepted when LEGACY_HASH_LINKS is enabled. The
+// returned status is meant to be sent along with the error.
+func checkLinkAuth(ctx *gin.Context, messageID int, file *types.File) (int, error) {
+	signature := ctx.Query("sig")
+	if signature == "" {
+		authHash := ctx.Query("hash")
+		if authHash == "" {
+			return http.StatusBadRequest, errors.New("missing sig param")
+		}
+		if !config.ValueOf.LegacyHashLinks {
+			return http.StatusForbidden, errors.New("unsigned links are no longer accepted")
+		}
+		expectedHash := utils.PackFile(
+			file.FileName,
+			file.FileSize,
+			file.MimeType,
+			file.ID,
+		)
+		if !utils.CheckHash(authHash, expectedHash) {
+			return http.StatusBadRequest, errors.New("invalid hash")
+		}
+		return http.StatusOK, nil
+	}
+	var expires int64
+	if exp := ctx.Query("exp"); exp != "" {
+		var err error
+		expires, err = strconv.ParseInt(exp, 10, 64)
+		if err != nil || expires <= 0 {
+			return http.StatusBadRequest, errors.New("invalid exp param")
+		}
+	}
+	clientIP := ctx.Query("ip")
+	if !utils.CheckLinkSignature(signature, messageID, file.ID, expires, clientIP) {
+		return http.StatusForbidden, errors.New("invalid signature")
+	}
+	if expires != 0 && time.Now().Unix() > expires {
+		return http.StatusGone, errors.New("this link has expired")
+	}
+	if clientIP != "" && clientIP != ctx.ClientIP() {
+		return http.StatusForbidden, errors.New("this link is bound to another IP address")
+	}
+	return http.StatusOK, nil
+}
+
+// linkOwner returns the user who generated the link, or 0 if it isn't
+// recorded. Bandwidth tiers apply to this user.
+func linkOwner(messageID int) int64 {
+	if record, err := database.DB.GetFileByMessageID(messageID); err == nil {
+		return record.UserID
+	}
+	return 0
+}
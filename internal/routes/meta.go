@@ -0,0 +1,109 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// user agents of the bots that fetch links to build previews in chat apps
+var previewBots = []string{
+	"TelegramBot",
+	"Twitterbot",
+	"facebookexternalhit",
+	"Discordbot",
+	"Slackbot",
+	"WhatsApp",
+	"LinkedInBot",
+	"redditbot",
+}
+
+func (e *allRoutes) LoadMeta(r *Route) {
+	log := e.log.Named("Meta")
+	defer log.Info("Loaded meta route")
+	r.Engine.GET("/meta/:messageID", getMetaRoute)
+}
+
+// getMetaRoute serves a page with the Open Graph tags of the file of a link
+// that redirects browsers to the player page. It takes the same query
+// parameters as /stream.
+func getMetaRoute(ctx *gin.Context) {
+	messageID, ok := linkMessageID(ctx)
+	if !ok {
+		return
+	}
+
+	worker := bot.GetNextWorker()
+	worker.StartStream()
+	defer worker.EndStream()
+
+	file, ok := linkedFile(ctx, worker, messageID)
+	if !ok {
+		return
+	}
+	renderMeta(ctx, messageID, file)
+}
+
+func renderMeta(ctx *gin.Context, messageID int, file *types.File) {
+	ctx.Header("Content-Type", "text/html; charset=utf-8")
+	ctx.Header("Cache-Control", "private, no-cache")
+	ctx.Status(http.StatusOK)
+	if err := templates.ExecuteTemplate(ctx.Writer, "meta.html", pageData(ctx, messageID, file)); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// isPreviewBot tells whether the request comes from a bot building a link preview.
+func isPreviewBot(ctx *gin.Context) bool {
+	userAgent := ctx.GetHeader("User-Agent")
+	for _, bot := range previewBots {
+		if strings.Contains(userAgent, bot) {
+			return true
+		}
+	}
+	return false
+}
+
+// pageData returns what the HTML pages of a link show about its file. The
+// links in it carry the query parameters of the request.
+func pageData(ctx *gin.Context, messageID int, file *types.File) gin.H {
+	mimeType := file.MimeType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	query := ctx.Request.URL.RawQuery
+	link := func(route string) string {
+		return fmt.Sprintf("%s/%s/%d?%s", config.ValueOf.Host, route, messageID, query)
+	}
+	kind := mediaKind(mimeType)
+	data := gin.H{
+		"FileName":  file.FileName,
+		"Size":      utils.SizeFormat(file.FileSize),
+		"MimeType":  mimeType,
+		"Kind":      kind,
+		"StreamURL": link("stream"),
+		// preview bots are served the file itself at this one
+		"MediaURL": link("stream") + "&raw=true",
+		"WatchURL": link("watch"),
+		"OGType":   "website",
+	}
+	switch kind {
+	case "video":
+		data["OGType"] = "video.other"
+	case "audio":
+		data["OGType"] = "music.song"
+	}
+	if len(file.Thumbs) > 0 {
+		thumb := file.Thumbs[len(file.Thumbs)-1]
+		data["ThumbURL"] = link("thumb")
+		data["ThumbWidth"] = thumb.Width
+		data["ThumbHeight"] = thumb.Height
+	}
+	return data
+}
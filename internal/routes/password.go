@@ -0,0 +1,222 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/database"
+	"EverythingSuckz/fsb/internal/utils"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var templates = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+
+// how long a browser can download a password protected file after entering the password
+const passwordCookieTTL = time.Hour
+
+// Wrong passwords are capped per client IP and per link, whatever the rate
+// limits are, so that a password can't be guessed by trying many.
+const (
+	passwordFailureWindow   = 15 * time.Minute
+	maxIPPasswordFailures   = 10
+	maxLinkPasswordFailures = 50
+)
+
+var (
+	ipPasswordFailures   = newFailureCounter(maxIPPasswordFailures, passwordFailureWindow)
+	linkPasswordFailures = newFailureCounter(maxLinkPasswordFailures, passwordFailureWindow)
+)
+
+func (e *allRoutes) LoadPassword(r *Route) {
+	log := e.log.Named("Password")
+	defer log.Info("Loaded password route")
+	go func() {
+		for range time.Tick(time.Minute) {
+			ipPasswordFailures.prune()
+			linkPasswordFailures.prune()
+		}
+	}()
+	r.Engine.POST("/stream/:messageID", postPasswordRoute)
+}
+
+// checkFilePassword makes sure the password of a protected file was given,
+// either with HTTP Basic auth or through the password form. It writes the
+// response and returns false if the request must not go on.
+func checkFilePassword(ctx *gin.Context, messageID int) bool {
+	file, err := database.DB.GetFileByMessageID(messageID)
+	if errors.Is(err, database.ErrFileNotFound) {
+		return true
+	}
+	if err != nil {
+		http.Error(ctx.Writer, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if file.PasswordHash == "" {
+		return true
+	}
+	if cookie, err := ctx.Cookie(passwordCookieName(messageID)); err == nil && utils.CheckPasswordCookie(cookie, messageID, file.PasswordHash) {
+		return true
+	}
+	if _, password, ok := ctx.Request.BasicAuth(); ok {
+		if wait := passwordBlocked(ctx, messageID); wait > 0 {
+			ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(ctx.Writer, "too many wrong passwords, try again later", http.StatusTooManyRequests)
+			return false
+		}
+		if utils.CheckPassword(file.PasswordHash, password) {
+			return true
+		}
+		passwordFailed(ctx, messageID)
+	}
+	// browsers get a form, everything else (download managers, players) a Basic auth challenge
+	if strings.Contains(ctx.GetHeader("Accept"), "text/html") {
+		renderPasswordForm(ctx, http.StatusUnauthorized, file.FileName, "")
+		return false
+	}
+	ctx.Header("WWW-Authenticate", `Basic realm="fsb", charset="UTF-8"`)
+	http.Error(ctx.Writer, "password required", http.StatusUnauthorized)
+	return false
+}
+
+// postPasswordRoute checks the password sent with the form of
+// checkFilePassword. The link must be valid before the password is looked at.
+func postPasswordRoute(ctx *gin.Context) {
+	messageID, ok := linkMessageID(ctx)
+	if !ok {
+		return
+	}
+	worker := bot.GetNextWorker()
+	tgFile, err := utils.FileFromMessage(ctx, worker.Client, messageID)
+	if err != nil {
+		http.Error(ctx.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if status, err := checkLinkAuth(ctx, messageID, tgFile); err != nil {
+		http.Error(ctx.Writer, err.Error(), status)
+		return
+	}
+	file, err := database.DB.GetFileByMessageID(messageID)
+	if err != nil && !errors.Is(err, database.ErrFileNotFound) {
+		http.Error(ctx.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err == nil && file.PasswordHash != "" {
+		if wait := passwordBlocked(ctx, messageID); wait > 0 {
+			ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			renderPasswordForm(ctx, http.StatusTooManyRequests, file.FileName,
+				fmt.Sprintf("Too many wrong passwords, please try again in %d minutes.", int(math.Ceil(wait.Minutes()))))
+			return
+		}
+		if !utils.CheckPassword(file.PasswordHash, ctx.PostForm("password")) {
+			passwordFailed(ctx, messageID)
+			renderPasswordForm(ctx, http.StatusUnauthorized, file.FileName, "Wrong password, please try again.")
+			return
+		}
+		expires := time.Now().Add(passwordCookieTTL)
+		ctx.SetSameSite(http.SameSiteLaxMode)
+		ctx.SetCookie(
+			passwordCookieName(messageID),
+			utils.PasswordCookie(messageID, file.PasswordHash, expires),
+			int(passwordCookieTTL.Seconds()),
+			"/",
+			"",
+			ctx.Request.TLS != nil || strings.HasPrefix(config.ValueOf.Host, "https://"),
+			true,
+		)
+	}
+	// back to the same link, now with the cookie
+	ctx.Redirect(http.StatusSeeOther, ctx.Request.URL.RequestURI())
+}
+
+func renderPasswordForm(ctx *gin.Context, status int, fileName string, message string) {
+	ctx.Header("Content-Type", "text/html; charset=utf-8")
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Status(status)
+	err := templates.ExecuteTemplate(ctx.Writer, "password.html", gin.H{
+		"FileName": fileName,
+		"Error":    message,
+	})
+	if err != nil {
+		log.Error(err.Error())
+	}
+}
+
+func passwordCookieName(messageID int) string {
+	return fmt.Sprintf("fsb_pw_%d", messageID)
+}
+
+// passwordBlocked returns how long the client has to wait before trying
+// another password for the link, or 0 if it can try now.
+func passwordBlocked(ctx *gin.Context, messageID int) time.Duration {
+	return max(
+		ipPasswordFailures.blocked(ctx.ClientIP()),
+		linkPasswordFailures.blocked(strconv.Itoa(messageID)),
+	)
+}
+
+func passwordFailed(ctx *gin.Context, messageID int) {
+	ipPasswordFailures.fail(ctx.ClientIP())
+	linkPasswordFailures.fail(strconv.Itoa(messageID))
+}
+
+// failureCounter counts failures per key and blocks a key once it has max of
+// them in a window, until the window ends.
+type failureCounter struct {
+	mu      sync.Mutex
+	max     int
+	window  time.Duration
+	entries map[string]*failureEntry
+}
+
+type failureEntry struct {
+	count int
+	reset time.Time
+}
+
+func newFailureCounter(max int, window time.Duration) *failureCounter {
+	return &failureCounter{max: max, window: window, entries: make(map[string]*failureEntry)}
+}
+
+func (c *failureCounter) blocked(key string) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || entry.count < c.max {
+		return 0
+	}
+	return max(time.Until(entry.reset), 0)
+}
+
+func (c *failureCounter) fail(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.reset) {
+		entry = &failureEntry{reset: time.Now().Add(c.window)}
+		c.entries[key] = entry
+	}
+	entry.count++
+}
+
+func (c *failureCounter) prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		if time.Now().After(entry.reset) {
+			delete(c.entries, key)
+		}
+	}
+}
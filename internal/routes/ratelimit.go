@@ -0,0 +1,174 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// limiters that weren't used for this long are forgotten
+const limiterIdleTimeout = 5 * time.Minute
+
+// linkRateRoutes are the routes that count towards the request rate of a
+// link. The requests a player makes on its own (HLS playlists and segments,
+// thumbnails, subtitles, file info) don't, so watching a file doesn't use it up.
+var linkRateRoutes = map[string]bool{
+	"/stream/:messageID": true,
+	"/watch/:messageID":  true,
+	"/meta/:messageID":   true,
+}
+
+// streamRoute is the only route whose requests count as streams.
+const streamRoute = "/stream/:messageID"
+
+// rateLimit limits the requests per second per client IP and per link, and
+// the concurrent streams per client IP and per link. The client IP comes from
+// X-Forwarded-For only for TRUSTED_PROXIES.
+func rateLimit(log *zap.Logger) gin.HandlerFunc {
+	ipRate := newKeyedLimiter(config.ValueOf.IPRateLimit, config.ValueOf.IPRateBurst)
+	linkRate := newKeyedLimiter(config.ValueOf.LinkRateLimit, config.ValueOf.LinkRateBurst)
+	ipStreams := newConcurrencyLimiter(config.ValueOf.IPMaxStreams)
+	linkStreams := newConcurrencyLimiter(config.ValueOf.LinkMaxStreams)
+	go func() {
+		for range time.Tick(time.Minute) {
+			ipRate.prune()
+			linkRate.prune()
+		}
+	}()
+
+	tooManyRequests := func(ctx *gin.Context, retryAfter time.Duration, reason string) {
+		ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		ctx.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"ok": false, "error": reason})
+	}
+	return func(ctx *gin.Context) {
+		ip := ctx.ClientIP()
+		messageID := ctx.Param("messageID")
+		if wait := ipRate.reserve(ip); wait > 0 {
+			log.Debug("Rate limited", zap.String("ip", ip))
+			tooManyRequests(ctx, wait, "too many requests from your IP address")
+			return
+		}
+		if messageID == "" {
+			ctx.Next()
+			return
+		}
+		if linkRateRoutes[ctx.FullPath()] {
+			if wait := linkRate.reserve(messageID); wait > 0 {
+				log.Debug("Rate limited", zap.String("messageID", messageID))
+				tooManyRequests(ctx, wait, "too many requests for this link")
+				return
+			}
+		}
+		if ctx.FullPath() != streamRoute || ctx.Request.Method == http.MethodPost {
+			ctx.Next()
+			return
+		}
+		if !ipStreams.acquire(ip) {
+			tooManyRequests(ctx, time.Second, "too many concurrent streams from your IP address")
+			return
+		}
+		defer ipStreams.release(ip)
+		if !linkStreams.acquire(messageID) {
+			tooManyRequests(ctx, time.Second, "too many concurrent streams for this link")
+			return
+		}
+		defer linkStreams.release(messageID)
+		ctx.Next()
+	}
+}
+
+// keyedLimiter is a token bucket per key, a rate of 0 disables it.
+type keyedLimiter struct {
+	mu       sync.Mutex
+	limit    rate.Limit
+	burst    int
+	limiters map[string]*keyedLimiterEntry
+}
+
+type keyedLimiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newKeyedLimiter(perSecond float64, burst int) *keyedLimiter {
+	return &keyedLimiter{
+		limit:    rate.Limit(perSecond),
+		burst:    burst,
+		limiters: make(map[string]*keyedLimiterEntry),
+	}
+}
+
+// reserve takes a token for key, or returns how long to wait for one.
+func (l *keyedLimiter) reserve(key string) time.Duration {
+	if l.limit == 0 {
+		return 0
+	}
+	now := time.Now()
+	l.mu.Lock()
+	entry, ok := l.limiters[key]
+	if !ok {
+		entry = &keyedLimiterEntry{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.limiters[key] = entry
+	}
+	entry.lastSeen = now
+	l.mu.Unlock()
+
+	reservation := entry.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return delay
+	}
+	return 0
+}
+
+func (l *keyedLimiter) prune() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, entry := range l.limiters {
+		if time.Since(entry.lastSeen) > limiterIdleTimeout {
+			delete(l.limiters, key)
+		}
+	}
+}
+
+// concurrencyLimiter caps the running requests per key, a max of 0 disables it.
+type concurrencyLimiter struct {
+	mu     sync.Mutex
+	max    int
+	active map[string]int
+}
+
+func newConcurrencyLimiter(max int) *concurrencyLimiter {
+	return &concurrencyLimiter{max: max, active: make(map[string]int)}
+}
+
+func (c *concurrencyLimiter) acquire(key string) bool {
+	if c.max == 0 {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.active[key] >= c.max {
+		return false
+	}
+	c.active[key]++
+	return true
+}
+
+func (c *concurrencyLimiter) release(key string) {
+	if c.max == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.active[key]--; c.active[key] <= 0 {
+		delete(c.active, key)
+	}
+}
@@ -0,0 +1,57 @@
+package routes
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// recent keeps the values last loaded for a few keys, most recently used
+// first. Concurrent loads of the same key share one call.
+type recent[K comparable, V any] struct {
+	max     int
+	mu      sync.Mutex
+	entries []recentEntry[K, V]
+	group   singleflight.Group
+}
+
+type recentEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+func newRecent[K comparable, V any](max int) *recent[K, V] {
+	return &recent[K, V]{max: max}
+}
+
+// get returns the value of key, calling load if it isn't kept.
+func (r *recent[K, V]) get(key K, load func() (V, error)) (V, error) {
+	r.mu.Lock()
+	for i, entry := range r.entries {
+		if entry.key == key {
+			copy(r.entries[1:i+1], r.entries[:i])
+			r.entries[0] = entry
+			r.mu.Unlock()
+			return entry.value, nil
+		}
+	}
+	r.mu.Unlock()
+
+	value, err, _ := r.group.Do(fmt.Sprint(key), func() (any, error) {
+		value, err := load()
+		if err != nil {
+			return nil, err
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		entries := append([]recentEntry[K, V]{{key: key, value: value}}, r.entries...)
+		r.entries = entries[:min(len(entries), r.max)]
+		return value, nil
+	})
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+	return value.(V), nil
+}
@@ -0,0 +1,38 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/types"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func (e *allRoutes) LoadRevoke(r *Route) {
+	revokeLog := e.log.Named("Revoke")
+	if config.ValueOf.AdminAPIToken == "" {
+		revokeLog.Info("ADMIN_API_TOKEN not set, skipping revoke route")
+		return
+	}
+	defer revokeLog.Info("Loaded revoke route")
+	r.Engine.DELETE("/admin/files/:messageID", adminAuth, func(ctx *gin.Context) {
+		revokeRoute(ctx, revokeLog)
+	})
+}
+
+func revokeRoute(ctx *gin.Context, log *zap.Logger) {
+	messageID, err := strconv.Atoi(ctx.Param("messageID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, types.ErrorResponse{Error: "invalid message id"})
+		return
+	}
+	if err := bot.RevokeFile(ctx, messageID, config.ValueOf.AdminUserID); err != nil {
+		log.Error("Failed to revoke file", zap.Int("messageID", messageID), zap.Error(err))
+		ctx.JSON(http.StatusInternalServerError, types.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, types.RevokeResponse{Ok: true, MessageID: messageID})
+}
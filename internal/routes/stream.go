@@ -1,12 +1,21 @@
 package routes
 
 import (
+	"EverythingSuckz/fsb/config"
 	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/throttle"
+	"EverythingSuckz/fsb/internal/types"
 	"EverythingSuckz/fsb/internal/utils"
+	"cmp"
+	"context"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/gotd/td/tg"
 	range_parser "github.com/quantumsheep/range-parser"
@@ -27,38 +36,29 @@ func getStreamRoute(ctx *gin.Context) {
 	w := ctx.Writer
 	r := ctx.Request
 
-	messageIDParm := ctx.Param("messageID")
-	messageID, err := strconv.Atoi(messageIDParm)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	authHash := ctx.Query("hash")
-	if authHash == "" {
-		http.Error(w, "missing hash param", http.StatusBadRequest)
+	messageID, ok := linkMessageID(ctx)
+	if !ok {
 		return
 	}
 
 	worker := bot.GetNextWorker()
+	worker.StartStream()
+	defer worker.EndStream()
 
-	file, err := utils.FileFromMessage(ctx, worker.Client, messageID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	file, ok := linkedFile(ctx, worker, messageID)
+	if !ok {
 		return
 	}
 
-	expectedHash := utils.PackFile(
-		file.FileName,
-		file.FileSize,
-		file.MimeType,
-		file.ID,
-	)
-	if !utils.CheckHash(authHash, expectedHash) {
-		http.Error(w, "invalid hash", http.StatusBadRequest)
+	// chat apps get a page with Open Graph tags instead of the file, unless
+	// they follow the og:video or og:audio URL of that page
+	if isPreviewBot(ctx) && r.Header.Get("Range") == "" && ctx.Query("raw") != "true" {
+		renderMeta(ctx, messageID, file)
 		return
 	}
 
+	ownerID := linkOwner(messageID)
+
 	// for photo messages
 	if file.FileSize == 0 {
 		res, err := worker.Client.API().UploadGetFile(ctx, &tg.UploadGetFileRequest{
@@ -77,55 +77,239 @@ func getStreamRoute(ctx *gin.Context) {
 		}
 		fileBytes := result.GetBytes()
 		ctx.Header("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", file.FileName))
-		if r.Method != "HEAD" {
-			ctx.Data(http.StatusOK, file.MimeType, fileBytes)
+		if r.Method == "HEAD" {
+			return
+		}
+		size := int64(len(fileBytes))
+		if !reserveBytes(ctx, messageID, size) {
+			return
+		}
+		var written int
+		defer func() { releaseBytes(messageID, size-int64(written)) }()
+		ctx.Header("Content-Type", file.MimeType)
+		ctx.Header("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteHeader(http.StatusOK)
+		out := throttle.GetThrottle().Writer(ctx, w, ownerID)
+		defer out.Close()
+		written, err = out.Write(fileBytes)
+		if err != nil {
+			log.Error("Error while writing photo", zap.Error(err))
 		}
 		return
 	}
 
+	mimeType := file.MimeType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	disposition := "inline"
+	if ctx.Query("d") == "true" {
+		disposition = "attachment"
+	}
+
 	ctx.Header("Accept-Ranges", "bytes")
+	ctx.Header("Content-Disposition", fmt.Sprintf("%s; filename=\"%s\"", disposition, file.FileName))
+
 	var start, end int64
+	status := http.StatusOK
 	rangeHeader := r.Header.Get("Range")
 
 	if rangeHeader == "" {
 		start = 0
 		end = file.FileSize - 1
-		w.WriteHeader(http.StatusOK)
 	} else {
-		ranges, err := range_parser.Parse(file.FileSize, r.Header.Get("Range"))
+		ranges, err := range_parser.Parse(file.FileSize, strings.ReplaceAll(rangeHeader, " ", ""))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			ctx.Header("Content-Range", fmt.Sprintf("bytes */%d", file.FileSize))
+			http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
 			return
 		}
-		start = ranges[0].Start
-		end = ranges[0].End
-		ctx.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, file.FileSize))
-		log.Info("Content-Range", zap.Int64("start", start), zap.Int64("end", end), zap.Int64("fileSize", file.FileSize))
-		w.WriteHeader(http.StatusPartialContent)
+		ranges = coalesceRanges(ranges, file.FileSize)
+		switch {
+		case ranges == nil:
+			start = 0
+			end = file.FileSize - 1
+		case len(ranges) > 1:
+			serveMultipartRanges(ctx, worker, messageID, ownerID, file, mimeType, ranges)
+			return
+		default:
+			start = ranges[0].Start
+			end = ranges[0].End
+			status = http.StatusPartialContent
+		}
 	}
 
 	contentLength := end - start + 1
-	mimeType := file.MimeType
 
-	if mimeType == "" {
-		mimeType = "application/octet-stream"
+	var written int64
+	if r.Method != "HEAD" {
+		if !reserveBytes(ctx, messageID, contentLength) {
+			return
+		}
+		defer func() { releaseBytes(messageID, contentLength-written) }()
 	}
 
+	if status == http.StatusPartialContent {
+		ctx.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, file.FileSize))
+		log.Info("Content-Range", zap.Int64("start", start), zap.Int64("end", end), zap.Int64("fileSize", file.FileSize))
+	}
 	ctx.Header("Content-Type", mimeType)
 	ctx.Header("Content-Length", strconv.FormatInt(contentLength, 10))
+	w.WriteHeader(status)
 
-	disposition := "inline"
+	if r.Method != "HEAD" {
+		lr, err := newStreamReader(ctx, worker, messageID, file, start, end)
+		if err != nil {
+			log.Error("Error while creating stream reader", zap.Error(err))
+			return
+		}
+		defer lr.Close()
+		out := throttle.GetThrottle().Writer(ctx, w, ownerID)
+		defer out.Close()
+		written, err = io.CopyN(out, lr, contentLength)
+		if err != nil {
+			log.Error("Error while copying stream", zap.Error(err))
+		}
+	}
+}
 
-	if ctx.Query("d") == "true" {
-		disposition = "attachment"
+// maxRangeParts is the most parts a multipart/byteranges response has.
+const maxRangeParts = 16
+
+// coalesceRanges sorts ranges and merges those that overlap or touch, so that
+// no byte is fetched twice. It returns nil if the whole file should be sent
+// instead: when the requested ranges add up to more than the file, as
+// net/http does, or when more than maxRangeParts parts are left.
+func coalesceRanges(ranges []*range_parser.Range, size int64) []*range_parser.Range {
+	var sum int64
+	for _, ra := range ranges {
+		sum += ra.End - ra.Start + 1
+	}
+	if sum > size {
+		return nil
+	}
+	sorted := slices.Clone(ranges)
+	slices.SortFunc(sorted, func(a, b *range_parser.Range) int { return cmp.Compare(a.Start, b.Start) })
+	var merged []*range_parser.Range
+	for _, ra := range sorted {
+		if n := len(merged); n > 0 && ra.Start <= merged[n-1].End+1 {
+			merged[n-1].End = max(merged[n-1].End, ra.End)
+			continue
+		}
+		merged = append(merged, &range_parser.Range{Start: ra.Start, End: ra.End})
+	}
+	if len(merged) > maxRangeParts {
+		return nil
 	}
+	return merged
+}
 
-	ctx.Header("Content-Disposition", fmt.Sprintf("%s; filename=\"%s\"", disposition, file.FileName))
+// serveMultipartRanges writes a multipart/byteranges response (RFC 7233) with
+// one part per requested range, each fetched through its own telegram reader.
+func serveMultipartRanges(ctx *gin.Context, worker *bot.Worker, messageID int, ownerID int64, file *types.File, mimeType string, ranges []*range_parser.Range) {
+	w := ctx.Writer
+	out := throttle.GetThrottle().Writer(ctx, w, ownerID)
+	defer out.Close()
+	mw := multipart.NewWriter(out)
+	contentLength := multipartRangesSize(mw.Boundary(), file.FileSize, mimeType, ranges)
 
-	if r.Method != "HEAD" {
-		lr, _ := utils.NewTelegramReader(ctx, worker.Client, file.Location, start, end, contentLength)
-		if _, err := io.CopyN(w, lr, contentLength); err != nil {
+	var reserved, written int64
+	for _, ra := range ranges {
+		reserved += ra.End - ra.Start + 1
+	}
+	if ctx.Request.Method != "HEAD" {
+		if !reserveBytes(ctx, messageID, reserved) {
+			return
+		}
+		defer func() { releaseBytes(messageID, reserved-written) }()
+	}
+
+	ctx.Header("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	ctx.Header("Content-Length", strconv.FormatInt(contentLength, 10))
+	log.Info("Multipart Content-Range", zap.Int("parts", len(ranges)), zap.Int64("fileSize", file.FileSize))
+	w.WriteHeader(http.StatusPartialContent)
+
+	if ctx.Request.Method == "HEAD" {
+		return
+	}
+
+	for _, ra := range ranges {
+		part, err := mw.CreatePart(rangePartHeader(ra, mimeType, file.FileSize))
+		if err != nil {
+			log.Error("Error while writing multipart header", zap.Error(err))
+			return
+		}
+		length := ra.End - ra.Start + 1
+		lr, err := newStreamReader(ctx, worker, messageID, file, ra.Start, ra.End)
+		if err != nil {
+			log.Error("Error while creating stream reader", zap.Error(err))
+			return
+		}
+		n, err := io.CopyN(part, lr, length)
+		written += n
+		lr.Close()
+		if err != nil {
 			log.Error("Error while copying stream", zap.Error(err))
+			return
 		}
 	}
+	if err := mw.Close(); err != nil {
+		log.Error("Error while closing multipart writer", zap.Error(err))
+	}
+}
+
+// newStreamReader returns a reader for [start, end] of file, striped across all
+// workers when STRIPE_WORKERS is enabled.
+func newStreamReader(ctx context.Context, worker *bot.Worker, messageID int, file *types.File, start, end int64) (io.ReadCloser, error) {
+	contentLength := end - start + 1
+	if !config.ValueOf.StripeWorkers {
+		return utils.NewTelegramReader(ctx, worker.Client, file.Location, start, end, contentLength)
+	}
+	clients, release := bot.GetStripeClients(worker)
+	lr, err := utils.NewStripedTelegramReader(ctx, clients, messageID, start, end, contentLength)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &releasingReader{ReadCloser: lr, release: release}, nil
+}
+
+// releasingReader hands the workers borrowed for striping back on Close.
+type releasingReader struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r *releasingReader) Close() error {
+	defer r.release()
+	return r.ReadCloser.Close()
+}
+
+func rangePartHeader(ra *range_parser.Range, mimeType string, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Range": {fmt.Sprintf("bytes %d-%d/%d", ra.Start, ra.End, size)},
+		"Content-Type":  {mimeType},
+	}
+}
+
+// multipartRangesSize returns the exact body length of a multipart/byteranges
+// response so that Content-Length can be sent before any part is fetched.
+func multipartRangesSize(boundary string, size int64, mimeType string, ranges []*range_parser.Range) int64 {
+	var cw countingWriter
+	mw := multipart.NewWriter(&cw)
+	mw.SetBoundary(boundary)
+	for _, ra := range ranges {
+		mw.CreatePart(rangePartHeader(ra, mimeType, size))
+		cw += countingWriter(ra.End - ra.Start + 1)
+	}
+	mw.Close()
+	return int64(cw)
+}
+
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
 }
@@ -0,0 +1,187 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/media"
+	"EverythingSuckz/fsb/internal/throttle"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxMatroskaHeads is how many Matroska track lists and cues are kept
+	// in memory
+	maxMatroskaHeads = 8
+	// maxSubtitleFiles is how many converted subtitle tracks are kept in
+	// memory
+	maxSubtitleFiles = 16
+)
+
+var (
+	matroskaHeads = newRecent[int64, *media.Matroska](maxMatroskaHeads)
+	subtitleFiles = newRecent[string, []byte](maxSubtitleFiles)
+)
+
+func (e *allRoutes) LoadSubs(r *Route) {
+	log := e.log.Named("Subs")
+	defer log.Info("Loaded subs route")
+	r.Engine.GET("/subs/:messageID", getSubsRoute)
+	r.Engine.GET("/subs/:messageID/:name", getSubsRoute)
+}
+
+// getSubsRoute lists the subtitle tracks of the Matroska file of a link, or
+// serves the track <n>.vtt converted to WebVTT. It takes the same query
+// parameters as /stream and passes them on to the track URLs. Both answer
+// any origin, so that players on other pages can load the tracks.
+func getSubsRoute(ctx *gin.Context) {
+	messageID, ok := linkMessageID(ctx)
+	if !ok {
+		return
+	}
+
+	worker := bot.GetNextWorker()
+	worker.StartStream()
+	defer worker.EndStream()
+
+	file, ok := linkedFile(ctx, worker, messageID)
+	if !ok {
+		return
+	}
+
+	ctx.Header("Access-Control-Allow-Origin", "*")
+	if !isMatroska(file) {
+		http.Error(ctx.Writer, "subtitles are only read from Matroska files", http.StatusUnsupportedMediaType)
+		return
+	}
+	m, err := loadMatroska(worker, messageID, file)
+	if err != nil {
+		mediaError(ctx, err)
+		return
+	}
+
+	name := ctx.Param("name")
+	if name == "" {
+		serveSubtitleTracks(ctx, messageID, m)
+		return
+	}
+	track, err := strconv.ParseUint(strings.TrimSuffix(name, ".vtt"), 10, 64)
+	if err != nil || !strings.HasSuffix(name, ".vtt") {
+		http.Error(ctx.Writer, "not found", http.StatusNotFound)
+		return
+	}
+	serveSubtitleTrack(ctx, worker, messageID, file, m, track)
+}
+
+func serveSubtitleTracks(ctx *gin.Context, messageID int, m *media.Matroska) {
+	tracks := []types.SubtitleTrack{}
+	for _, t := range m.SubtitleTracks() {
+		track := types.SubtitleTrack{
+			Track:    t.Number,
+			Codec:    t.CodecID,
+			Language: t.Language,
+			Name:     t.Name,
+			Default:  t.Default,
+			Forced:   t.Forced,
+		}
+		if t.IsText() {
+			track.URL = fmt.Sprintf("%s/subs/%d/%d.vtt?%s", config.ValueOf.Host, messageID, t.Number, ctx.Request.URL.RawQuery)
+		}
+		tracks = append(tracks, track)
+	}
+	// the track URLs hold the signed link
+	ctx.Header("Cache-Control", "private, no-cache")
+	ctx.JSON(http.StatusOK, types.SubtitlesResponse{Ok: true, Tracks: tracks})
+}
+
+func serveSubtitleTrack(ctx *gin.Context, worker *bot.Worker, messageID int, file *types.File, m *media.Matroska, track uint64) {
+	key := fmt.Sprintf("%d:%d", file.ID, track)
+	vtt, err := subtitleFiles.get(key, func() ([]byte, error) {
+		readCtx, cancel := context.WithTimeout(context.Background(), mediaReadTimeout)
+		defer cancel()
+		start := time.Now()
+		// a track without cue points is read whole, so the range can be
+		// large and is charged before it is fetched
+		blocks, err := m.ReadSubtitles(track, func(start, end int64) (io.ReadCloser, error) {
+			if err := chargeBytes(messageID, end-start+1); err != nil {
+				return nil, err
+			}
+			return newStreamReader(readCtx, worker, messageID, file, start, end)
+		})
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := media.WriteWebVTT(&buf, m.Track(track), blocks); err != nil {
+			return nil, err
+		}
+		log.Info("Read subtitle track",
+			zap.Int64("fileID", file.ID),
+			zap.Uint64("track", track),
+			zap.Int("cues", len(blocks)),
+			zap.Duration("took", time.Since(start)),
+		)
+		return buf.Bytes(), nil
+	})
+	if err != nil {
+		mediaError(ctx, err)
+		return
+	}
+
+	size := int64(len(vtt))
+	if !reserveBytes(ctx, messageID, size) {
+		return
+	}
+	var written int64
+	defer func() { releaseBytes(messageID, size-written) }()
+
+	ctx.Header("Content-Type", "text/vtt; charset=utf-8")
+	ctx.Header("Content-Length", strconv.FormatInt(size, 10))
+	ctx.Header("Cache-Control", "private, max-age=3600")
+	ctx.Status(http.StatusOK)
+	out := throttle.GetThrottle().Writer(ctx, ctx.Writer, linkOwner(messageID))
+	defer out.Close()
+	written, err = io.Copy(out, bytes.NewReader(vtt))
+	if err != nil {
+		log.Error("Error while copying subtitles", zap.Error(err))
+	}
+}
+
+// isMatroska tells from its MIME type or name whether file is a Matroska or
+// WebM file.
+func isMatroska(file *types.File) bool {
+	switch file.MimeType {
+	case "video/x-matroska", "audio/x-matroska", "video/webm", "audio/webm":
+		return true
+	}
+	switch strings.ToLower(path.Ext(file.FileName)) {
+	case ".mkv", ".mka", ".mks", ".webm":
+		return true
+	}
+	return false
+}
+
+// loadMatroska returns the track list and cues of file, reading them from
+// Telegram if they aren't in memory. The blocks read are charged to the link.
+func loadMatroska(worker *bot.Worker, messageID int, file *types.File) (*media.Matroska, error) {
+	return matroskaHeads.get(file.ID, func() (*media.Matroska, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), mediaReadTimeout)
+		defer cancel()
+		r := utils.NewMeteredTelegramReaderAt(ctx, worker.Client, file.Location, file.FileSize, func(n int64) error {
+			return chargeBytes(messageID, n)
+		})
+		return media.ReadMatroska(r, file.FileSize)
+	})
+}
@@ -0,0 +1,110 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	_ "embed"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed static/placeholder.svg
+var placeholderThumb []byte
+
+// image documents without a thumbnail (e.g. static stickers) up to this size
+// are served as their own thumbnail
+const maxSelfThumbSize = 1024 * 1024
+
+func (e *allRoutes) LoadThumb(r *Route) {
+	log := e.log.Named("Thumb")
+	defer log.Info("Loaded thumb route")
+	r.Engine.GET("/thumb/:messageID", getThumbRoute)
+}
+
+// getThumbRoute serves a thumbnail Telegram has for the file of a link, or a
+// placeholder image if there is none. It takes the same query parameters as
+// /stream, plus size: small, medium, large (default), a Telegram thumbnail
+// type like m or x, or a width in pixels.
+func getThumbRoute(ctx *gin.Context) {
+	messageID, ok := linkMessageID(ctx)
+	if !ok {
+		return
+	}
+
+	worker := bot.GetNextWorker()
+	worker.StartStream()
+	defer worker.EndStream()
+
+	file, ok := linkedFile(ctx, worker, messageID)
+	if !ok {
+		return
+	}
+
+	thumb, ok := selectThumb(file.Thumbs, ctx.Query("size"))
+	if !ok {
+		if len(file.Thumbs) > 0 {
+			http.Error(ctx.Writer, "invalid size param", http.StatusBadRequest)
+			return
+		}
+		if !strings.HasPrefix(file.MimeType, "image/") || file.FileSize == 0 || file.FileSize > maxSelfThumbSize {
+			ctx.Header("Cache-Control", "private, max-age=3600")
+			ctx.Data(http.StatusOK, "image/svg+xml", placeholderThumb)
+			return
+		}
+		// the empty thumbnail type downloads the file itself
+		thumb = types.Thumb{}
+	}
+
+	// thumbnails never change for a file, so the file ID and type identify
+	// them. private because the file may be password protected.
+	etag := fmt.Sprintf(`"%d-%s"`, file.ID, thumb.Type)
+	ctx.Header("ETag", etag)
+	ctx.Header("Cache-Control", "private, max-age=604800, immutable")
+	if ctx.GetHeader("If-None-Match") == etag {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+
+	data, err := utils.DownloadThumb(ctx, worker.Client, messageID, thumb.Type)
+	if err != nil {
+		ctx.Header("Cache-Control", "no-store")
+		http.Error(ctx.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ctx.Data(http.StatusOK, http.DetectContentType(data), data)
+}
+
+// selectThumb picks a thumbnail from thumbs, sorted smallest first, by size.
+func selectThumb(thumbs []types.Thumb, size string) (types.Thumb, bool) {
+	if len(thumbs) == 0 {
+		return types.Thumb{}, false
+	}
+	switch size {
+	case "", "large":
+		return thumbs[len(thumbs)-1], true
+	case "small":
+		return thumbs[0], true
+	case "medium":
+		return thumbs[len(thumbs)/2], true
+	}
+	if width, err := strconv.Atoi(size); err == nil {
+		// the smallest one that is at least as large as asked for
+		for _, thumb := range thumbs {
+			if max(thumb.Width, thumb.Height) >= width {
+				return thumb, true
+			}
+		}
+		return thumbs[len(thumbs)-1], true
+	}
+	for _, thumb := range thumbs {
+		if thumb.Type == size {
+			return thumb, true
+		}
+	}
+	return types.Thumb{}, false
+}
@@ -0,0 +1,394 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/database"
+	"EverythingSuckz/fsb/internal/types"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+)
+
+// uploadSessionTTL is how long an interrupted upload can be resumed.
+const uploadSessionTTL = time.Hour
+
+// uploadSession is an upload that can be resumed with the API key that
+// started it.
+type uploadSession struct {
+	id         string
+	upload     *bot.Upload
+	keyID      uint
+	userID     int64
+	generation uint // counts the upload against the quota of the user
+	active     time.Time
+	busy       int // requests feeding the upload
+}
+
+var uploadSessions = struct {
+	sync.Mutex
+	m map[string]*uploadSession
+}{m: make(map[string]*uploadSession)}
+
+// uploadQuota makes checking the quota and counting an upload against it one
+// step, so that concurrent uploads can't all fit in what is left of it.
+var uploadQuota sync.Mutex
+
+func (e *allRoutes) LoadUpload(r *Route) {
+	log := e.log.Named("Upload")
+	defer log.Info("Loaded upload routes")
+	api := r.Engine.Group("/api/v1", apiKeyAuth)
+	api.POST("/upload", uploadRoute)
+	api.GET("/upload/:id", uploadStatusRoute)
+	api.PATCH("/upload/:id", resumeUploadRoute)
+	api.DELETE("/upload/:id", abortUploadRoute)
+	go func() {
+		for range time.Tick(time.Minute) {
+			pruneUploadSessions()
+		}
+	}()
+}
+
+// uploadRoute uploads the file in a multipart form field named file, or the
+// raw request body, to the log channel and returns its links. Without a body
+// but with an Upload-Length header it only starts an upload, which is then
+// sent in chunks with PATCH /api/v1/upload/:id. The file must fit in the
+// quota of the user of the API key, and counts against it from the start.
+func uploadRoute(ctx *gin.Context) {
+	apiKey := ctx.MustGet(apiKeyContextKey).(*database.APIKey)
+	name := ctx.Query("name")
+	mimeType := ctx.Query("mime_type")
+
+	var size int64
+	var body io.Reader
+	switch {
+	case ctx.ContentType() == "multipart/form-data":
+		header, err := ctx.FormFile("file")
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, types.ErrorResponse{Error: err.Error()})
+			return
+		}
+		f, err := header.Open()
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, types.ErrorResponse{Error: err.Error()})
+			return
+		}
+		defer f.Close()
+		if name == "" {
+			name = header.Filename
+		}
+		if mimeType == "" {
+			mimeType = uploadMimeType(header.Header.Get("Content-Type"))
+		}
+		size, body = header.Size, f
+	case ctx.GetHeader("Upload-Length") != "":
+		var err error
+		size, err = strconv.ParseInt(ctx.GetHeader("Upload-Length"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, types.ErrorResponse{Error: "invalid Upload-Length"})
+			return
+		}
+	default:
+		if ctx.Request.ContentLength < 0 {
+			ctx.JSON(http.StatusLengthRequired, types.ErrorResponse{Error: "Content-Length is required"})
+			return
+		}
+		if mimeType == "" {
+			mimeType = uploadMimeType(ctx.GetHeader("Content-Type"))
+		}
+		size, body = ctx.Request.ContentLength, ctx.Request.Body
+	}
+	if name == "" {
+		ctx.JSON(http.StatusBadRequest, types.ErrorResponse{Error: "name is required"})
+		return
+	}
+	if mimeType == "" {
+		mimeType = uploadMimeType(mime.TypeByExtension(path.Ext(name)))
+	}
+
+	upload, err := bot.NewUpload(size, name, mimeType)
+	if err != nil {
+		status := http.StatusBadRequest
+		if size > bot.MaxUploadSize {
+			status = http.StatusRequestEntityTooLarge
+		}
+		ctx.JSON(status, types.ErrorResponse{Error: err.Error()})
+		return
+	}
+	upload.Attributes, err = uploadAttributes(ctx, mimeType)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, types.ErrorResponse{Error: err.Error()})
+		return
+	}
+	generation, ok := reserveUploadQuota(ctx, apiKey.UserID, size)
+	if !ok {
+		return
+	}
+	session, err := newUploadSession(upload, apiKey, generation)
+	if err != nil {
+		releaseUploadQuota(generation)
+		ctx.JSON(http.StatusInternalServerError, types.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if body == nil {
+		ctx.Header("Location", "/api/v1/upload/"+session.id)
+		uploadStatus(ctx, http.StatusCreated, session, nil)
+		return
+	}
+	feedUpload(ctx, session, 0, body)
+}
+
+// uploadStatusRoute returns how much of an upload was received.
+func uploadStatusRoute(ctx *gin.Context) {
+	session, ok := getUploadSession(ctx)
+	if !ok {
+		return
+	}
+	uploadStatus(ctx, http.StatusOK, session, nil)
+}
+
+// resumeUploadRoute goes on with an upload from the Upload-Offset header,
+// which must match the offset the upload is at. The request that completes
+// the file gets its links.
+func resumeUploadRoute(ctx *gin.Context) {
+	session, ok := getUploadSession(ctx)
+	if !ok {
+		return
+	}
+	offset, err := strconv.ParseInt(ctx.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, types.ErrorResponse{Error: "invalid Upload-Offset"})
+		return
+	}
+	feedUpload(ctx, session, offset, ctx.Request.Body)
+}
+
+// abortUploadRoute drops an upload and gives back its quota.
+func abortUploadRoute(ctx *gin.Context) {
+	session, ok := getUploadSession(ctx)
+	if !ok {
+		return
+	}
+	uploadSessions.Lock()
+	_, ok = uploadSessions.m[session.id]
+	busy := session.busy > 0
+	if ok && !busy {
+		delete(uploadSessions.m, session.id)
+	}
+	uploadSessions.Unlock()
+	switch {
+	case !ok:
+		ctx.JSON(http.StatusNotFound, types.ErrorResponse{Error: "upload not found"})
+	case busy:
+		uploadStatus(ctx, http.StatusConflict, session, bot.ErrUploadBusy)
+	default:
+		releaseUploadQuota(session.generation)
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+// feedUpload reads the file from body, starting at offset, and posts it to
+// the log channel once it is complete.
+func feedUpload(ctx *gin.Context, session *uploadSession, offset int64, body io.Reader) {
+	uploadSessions.Lock()
+	session.busy++
+	uploadSessions.Unlock()
+	defer func() {
+		uploadSessions.Lock()
+		session.busy--
+		session.active = time.Now()
+		uploadSessions.Unlock()
+	}()
+
+	err := session.upload.ReadFrom(ctx, offset, body)
+	if errors.Is(err, bot.ErrUploadOffset) || errors.Is(err, bot.ErrUploadBusy) {
+		uploadStatus(ctx, http.StatusConflict, session, err)
+		return
+	}
+	if err != nil {
+		log.Error("Upload interrupted", zap.String("uploadID", session.id), zap.Error(err))
+		uploadStatus(ctx, http.StatusBadGateway, session, err)
+		return
+	}
+	if !session.upload.Done() {
+		uploadStatus(ctx, http.StatusOK, session, nil)
+		return
+	}
+	// only one of the requests that find the upload complete posts it
+	uploadSessions.Lock()
+	_, ok := uploadSessions.m[session.id]
+	delete(uploadSessions.m, session.id)
+	uploadSessions.Unlock()
+	if !ok {
+		ctx.JSON(http.StatusConflict, types.ErrorResponse{Error: "the upload is already being posted"})
+		return
+	}
+	messageID, file, err := session.upload.Send(ctx)
+	if err != nil {
+		log.Error("Failed to post upload", zap.String("uploadID", session.id), zap.Error(err))
+		uploadSessions.Lock()
+		uploadSessions.m[session.id] = session
+		uploadSessions.Unlock()
+		uploadStatus(ctx, http.StatusBadGateway, session, err)
+		return
+	}
+	// the upload was counted against the quota when it started
+	addFile(messageID, file, session.userID)
+	ctx.JSON(http.StatusCreated, linkResponse(messageID, file, 0, ""))
+}
+
+// uploadStatus writes the state of an upload, along with err if it
+// couldn't go on.
+func uploadStatus(ctx *gin.Context, status int, session *uploadSession, err error) {
+	response := types.UploadResponse{
+		Ok:       err == nil,
+		UploadID: session.id,
+		Offset:   session.upload.Offset(),
+		Size:     session.upload.Size,
+	}
+	if err != nil {
+		response.Error = err.Error()
+	}
+	ctx.Header("Upload-Offset", strconv.FormatInt(response.Offset, 10))
+	ctx.Header("Upload-Length", strconv.FormatInt(response.Size, 10))
+	ctx.JSON(status, response)
+}
+
+// reserveUploadQuota counts an upload of size bytes against the quota of
+// userID when it starts rather than when it is posted, so that concurrent
+// uploads can't all pass the check. It writes the error response and returns
+// false if the upload doesn't fit. The link generation it returns is given
+// back with releaseUploadQuota if the upload is never posted.
+func reserveUploadQuota(ctx *gin.Context, userID int64, size int64) (uint, bool) {
+	uploadQuota.Lock()
+	defer uploadQuota.Unlock()
+	if !checkAPIQuota(ctx, userID, size) {
+		return 0, false
+	}
+	generation, err := database.DB.ReserveLinkGeneration(userID, size)
+	if err != nil {
+		log.Error("Failed to reserve quota", zap.Int64("userID", userID), zap.Error(err))
+	}
+	return generation, true
+}
+
+// releaseUploadQuota gives back the quota of an upload that was dropped.
+func releaseUploadQuota(generation uint) {
+	if generation == 0 {
+		return
+	}
+	if err := database.DB.DeleteLinkGeneration(generation); err != nil {
+		log.Error("Failed to release quota", zap.Uint("generation", generation), zap.Error(err))
+	}
+}
+
+// newUploadSession registers upload so that it can be resumed.
+func newUploadSession(upload *bot.Upload, apiKey *database.APIKey, generation uint) (*uploadSession, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	session := &uploadSession{
+		id:         hex.EncodeToString(id[:]),
+		upload:     upload,
+		keyID:      apiKey.ID,
+		userID:     apiKey.UserID,
+		generation: generation,
+		active:     time.Now(),
+	}
+	uploadSessions.Lock()
+	uploadSessions.m[session.id] = session
+	uploadSessions.Unlock()
+	return session, nil
+}
+
+// pruneUploadSessions drops the uploads that weren't resumed in time and
+// gives back their quota.
+func pruneUploadSessions() {
+	var expired []*uploadSession
+	uploadSessions.Lock()
+	for id, s := range uploadSessions.m {
+		if s.busy == 0 && time.Since(s.active) > uploadSessionTTL {
+			delete(uploadSessions.m, id)
+			expired = append(expired, s)
+		}
+	}
+	uploadSessions.Unlock()
+	for _, s := range expired {
+		releaseUploadQuota(s.generation)
+	}
+}
+
+// getUploadSession returns the upload :id started with the API key of the
+// request. It writes the error response and returns false if there is none.
+func getUploadSession(ctx *gin.Context) (*uploadSession, bool) {
+	apiKey := ctx.MustGet(apiKeyContextKey).(*database.APIKey)
+	uploadSessions.Lock()
+	session, ok := uploadSessions.m[ctx.Param("id")]
+	if ok && (session.keyID != apiKey.ID || session.busy == 0 && time.Since(session.active) > uploadSessionTTL) {
+		ok = false
+	}
+	uploadSessions.Unlock()
+	if !ok {
+		ctx.JSON(http.StatusNotFound, types.ErrorResponse{Error: "upload not found"})
+		return nil, false
+	}
+	return session, true
+}
+
+// uploadMimeType returns the MIME type of a Content-Type header, or "" if it
+// doesn't tell anything about the file.
+func uploadMimeType(contentType string) string {
+	mimeType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	switch mimeType {
+	case "application/octet-stream", "application/x-www-form-urlencoded":
+		return ""
+	}
+	return mimeType
+}
+
+// uploadAttributes returns the video or audio attributes given in the
+// duration, width, height, title and performer query parameters. Telegram
+// doesn't read them from the file, so files uploaded without a duration are
+// plain documents.
+func uploadAttributes(ctx *gin.Context, mimeType string) ([]tg.DocumentAttributeClass, error) {
+	if ctx.Query("duration") == "" {
+		return nil, nil
+	}
+	duration, err := strconv.ParseFloat(ctx.Query("duration"), 64)
+	if err != nil || duration < 0 {
+		return nil, errors.New("invalid duration")
+	}
+	switch {
+	case strings.HasPrefix(mimeType, "video/"):
+		width, _ := strconv.Atoi(ctx.Query("width"))
+		height, _ := strconv.Atoi(ctx.Query("height"))
+		return []tg.DocumentAttributeClass{&tg.DocumentAttributeVideo{
+			SupportsStreaming: true,
+			Duration:          duration,
+			W:                 width,
+			H:                 height,
+		}}, nil
+	case strings.HasPrefix(mimeType, "audio/"):
+		return []tg.DocumentAttributeClass{&tg.DocumentAttributeAudio{
+			Duration:  int(duration),
+			Title:     ctx.Query("title"),
+			Performer: ctx.Query("performer"),
+		}}, nil
+	}
+	return nil, nil
+}
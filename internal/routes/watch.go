@@ -0,0 +1,93 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (e *allRoutes) LoadWatch(r *Route) {
+	log := e.log.Named("Watch")
+	defer log.Info("Loaded watch route")
+	r.Engine.GET("/watch/:messageID", getWatchRoute)
+	r.Engine.POST("/watch/:messageID", postPasswordRoute)
+}
+
+// getWatchRoute renders a player page for the file of a link. The page takes
+// the same query parameters as /stream and embeds the stream link.
+func getWatchRoute(ctx *gin.Context) {
+	messageID, ok := linkMessageID(ctx)
+	if !ok {
+		return
+	}
+
+	worker := bot.GetNextWorker()
+	worker.StartStream()
+	defer worker.EndStream()
+
+	file, ok := linkedFile(ctx, worker, messageID)
+	if !ok {
+		return
+	}
+
+	data := pageData(ctx, messageID, file)
+	streamURL := data["StreamURL"].(string)
+	mimeType := data["MimeType"].(string)
+	data["DownloadURL"] = streamURL + "&d=true"
+	if kind := data["Kind"]; kind == "video" || kind == "audio" {
+		data["VLCURL"] = playerIntent(streamURL, "org.videolan.vlc", mimeType, file.FileName)
+		data["VLCiOSURL"] = template.URL("vlc-x-callback://x-callback-url/stream?url=" + url.QueryEscape(streamURL))
+		data["MXPlayerURL"] = playerIntent(streamURL, "com.mxtech.videoplayer.ad", mimeType, file.FileName)
+	}
+	if data["Kind"] == "video" {
+		data["HLSURL"] = fmt.Sprintf("%s/hls/%d/index.m3u8?%s", config.ValueOf.Host, messageID, ctx.Request.URL.RawQuery)
+		if isMatroska(file) {
+			data["SubsURL"] = fmt.Sprintf("%s/subs/%d?%s", config.ValueOf.Host, messageID, ctx.Request.URL.RawQuery)
+		}
+	}
+
+	ctx.Header("Content-Type", "text/html; charset=utf-8")
+	// the page holds the signed link
+	ctx.Header("Cache-Control", "private, no-cache")
+	ctx.Status(http.StatusOK)
+	if err := templates.ExecuteTemplate(ctx.Writer, "watch.html", data); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// mediaKind tells how the watch page can show a file: video, audio, image,
+// pdf or "" if it can't be shown in the browser.
+func mediaKind(mimeType string) string {
+	switch {
+	case strings.HasPrefix(mimeType, "video/"):
+		return "video"
+	case strings.HasPrefix(mimeType, "audio/"):
+		return "audio"
+	case strings.HasPrefix(mimeType, "image/"):
+		return "image"
+	case mimeType == "application/pdf":
+		return "pdf"
+	}
+	return ""
+}
+
+// playerIntent returns an Android intent link opening streamURL in the app
+// with the given package.
+func playerIntent(streamURL string, pkg string, mimeType string, title string) template.URL {
+	u, err := url.Parse(streamURL)
+	if err != nil {
+		return ""
+	}
+	scheme := u.Scheme
+	u.Scheme = ""
+	return template.URL(fmt.Sprintf(
+		"intent:%s#Intent;scheme=%s;type=%s;package=%s;S.title=%s;end",
+		u.String(), scheme, mimeType, pkg, url.PathEscape(title),
+	))
+}
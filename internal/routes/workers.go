@@ -0,0 +1,92 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/types"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func (e *allRoutes) LoadWorkers(r *Route) {
+	workerLog := e.log.Named("Workers")
+	if config.ValueOf.AdminAPIToken == "" {
+		workerLog.Info("ADMIN_API_TOKEN not set, skipping worker management routes")
+		return
+	}
+	defer workerLog.Info("Loaded worker management routes")
+	admin := r.Engine.Group("/admin", adminAuth)
+	admin.GET("/workers", listWorkersRoute)
+	admin.POST("/workers", func(ctx *gin.Context) { addWorkerRoute(ctx, workerLog) })
+	admin.DELETE("/workers/:id", removeWorkerRoute)
+}
+
+// adminAuth only lets requests through that carry ADMIN_API_TOKEN as a
+// bearer token.
+func adminAuth(ctx *gin.Context) {
+	token, ok := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(config.ValueOf.AdminAPIToken)) != 1 {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, types.ErrorResponse{Error: "unauthorized"})
+		return
+	}
+	ctx.Next()
+}
+
+func listWorkersRoute(ctx *gin.Context) {
+	workers := bot.Workers.List()
+	infos := make([]types.WorkerInfo, 0, len(workers))
+	for _, worker := range workers {
+		infos = append(infos, worker.Info())
+	}
+	ctx.JSON(http.StatusOK, types.WorkersResponse{Ok: true, Workers: infos})
+}
+
+func addWorkerRoute(ctx *gin.Context, log *zap.Logger) {
+	var body struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, types.ErrorResponse{Error: err.Error()})
+		return
+	}
+	worker, err := bot.Workers.Add(ctx.Request.Context(), body.Token)
+	if errors.Is(err, bot.ErrWorkerNotAdmin) {
+		ctx.JSON(http.StatusCreated, types.WorkersResponse{Ok: true, Workers: []types.WorkerInfo{worker.Info()}, Warning: err.Error()})
+		return
+	}
+	if errors.Is(err, bot.ErrWorkerExists) {
+		ctx.JSON(http.StatusConflict, types.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		log.Error("Failed to add worker", zap.Error(err))
+		ctx.JSON(http.StatusBadGateway, types.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, types.WorkersResponse{Ok: true, Workers: []types.WorkerInfo{worker.Info()}})
+}
+
+func removeWorkerRoute(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, types.ErrorResponse{Error: "invalid worker id"})
+		return
+	}
+	worker, err := bot.Workers.Remove(id)
+	switch {
+	case errors.Is(err, bot.ErrWorkerNotFound):
+		ctx.JSON(http.StatusNotFound, types.ErrorResponse{Error: err.Error()})
+	case errors.Is(err, bot.ErrDefaultWorker):
+		ctx.JSON(http.StatusForbidden, types.ErrorResponse{Error: err.Error()})
+	case err != nil:
+		ctx.JSON(http.StatusInternalServerError, types.ErrorResponse{Error: err.Error()})
+	default:
+		ctx.JSON(http.StatusAccepted, types.WorkersResponse{Ok: true, Workers: []types.WorkerInfo{worker.Info()}})
+	}
+}
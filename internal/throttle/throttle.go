@@ -0,0 +1,226 @@
+package throttle
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/database"
+	"context"
+	"io"
+	"maps"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// DefaultTier is the bandwidth tier of users who weren't assigned one.
+const DefaultTier = "default"
+
+// writes are split in chunks of this size so that no single write needs more
+// tokens than a bucket can hold
+const chunkSize = 32 * 1024
+
+var throttle *Throttle
+
+// Throttle limits the bandwidth of streams with token buckets: one shared by
+// all streams, one per link owner according to their tier and one per stream.
+// All rates are in bytes per second, 0 meaning unlimited.
+type Throttle struct {
+	mu        sync.RWMutex
+	log       *zap.Logger
+	global    *bucket
+	perStream int64
+	tiers     map[string]int64
+	users     map[int64]*userBucket
+}
+
+type userBucket struct {
+	*bucket
+	streams int
+}
+
+// Limits is a snapshot of the current rates.
+type Limits struct {
+	Global    int64
+	PerStream int64
+	Tiers     map[string]int64
+}
+
+func InitThrottle(log *zap.Logger) {
+	log = log.Named("throttle")
+	defer log.Sugar().Info("Initialized")
+	throttle = &Throttle{
+		log:       log,
+		global:    newBucket(config.ValueOf.BandwidthGlobal),
+		perStream: config.ValueOf.BandwidthStream,
+		tiers:     maps.Clone(config.ValueOf.BandwidthTiers),
+		users:     make(map[int64]*userBucket),
+	}
+	if throttle.tiers == nil {
+		throttle.tiers = make(map[string]int64)
+	}
+}
+
+func GetThrottle() *Throttle {
+	return throttle
+}
+
+func (t *Throttle) Limits() Limits {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return Limits{
+		Global:    t.global.bps.Load(),
+		PerStream: t.perStream,
+		Tiers:     maps.Clone(t.tiers),
+	}
+}
+
+func (t *Throttle) SetGlobal(bytesPerSecond int64) {
+	t.global.set(bytesPerSecond)
+	t.log.Sugar().Infof("Global bandwidth set to %d B/s", bytesPerSecond)
+}
+
+func (t *Throttle) SetPerStream(bytesPerSecond int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.perStream = bytesPerSecond
+	t.log.Sugar().Infof("Per stream bandwidth set to %d B/s", bytesPerSecond)
+}
+
+// SetTier creates or updates a tier, running streams pick up the new rate.
+func (t *Throttle) SetTier(name string, bytesPerSecond int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tiers[name] = bytesPerSecond
+	t.log.Sugar().Infof("Bandwidth of tier %s set to %d B/s", name, bytesPerSecond)
+}
+
+func (t *Throttle) HasTier(name string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	_, ok := t.tiers[name]
+	return ok || name == DefaultTier
+}
+
+// Writer throttles the writes to w of a stream of a file owned by userID (0
+// if unknown). Close must be called once the stream is done.
+func (t *Throttle) Writer(ctx context.Context, w io.Writer, userID int64) io.WriteCloser {
+	tw := &writer{ctx: ctx, w: w, t: t, userID: userID, tier: DefaultTier, stream: newBucket(0)}
+	if userID == 0 {
+		return tw
+	}
+	tier, err := database.DB.GetUserTier(userID)
+	if err != nil {
+		t.log.Sugar().Warnf("Failed to get tier of %d, using the default tier: %v", userID, err)
+	}
+	if tier != "" {
+		tw.tier = tier
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	user, ok := t.users[userID]
+	if !ok {
+		user = &userBucket{bucket: newBucket(0)}
+		t.users[userID] = user
+	}
+	user.streams++
+	tw.user = user.bucket
+	return tw
+}
+
+// rates returns the per stream rate and the rate of tier. Unknown tiers are unlimited.
+func (t *Throttle) rates(tier string) (int64, int64) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.perStream, t.tiers[tier]
+}
+
+func (t *Throttle) release(userID int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if user, ok := t.users[userID]; ok {
+		if user.streams--; user.streams <= 0 {
+			delete(t.users, userID)
+		}
+	}
+}
+
+type writer struct {
+	ctx    context.Context
+	w      io.Writer
+	t      *Throttle
+	userID int64
+	tier   string
+	stream *bucket
+	user   *bucket
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		n := min(len(p), chunkSize)
+		perStream, tierRate := w.t.rates(w.tier)
+		w.stream.ensure(perStream)
+		if err := w.stream.wait(w.ctx, n); err != nil {
+			return written, err
+		}
+		if w.user != nil {
+			w.user.ensure(tierRate)
+			if err := w.user.wait(w.ctx, n); err != nil {
+				return written, err
+			}
+		}
+		if err := w.t.global.wait(w.ctx, n); err != nil {
+			return written, err
+		}
+		m, err := w.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *writer) Close() error {
+	if w.user != nil {
+		w.t.release(w.userID)
+	}
+	return nil
+}
+
+// bucket is a token bucket holding up to one second worth of bytes.
+type bucket struct {
+	limiter *rate.Limiter
+	bps     atomic.Int64
+}
+
+func newBucket(bytesPerSecond int64) *bucket {
+	b := &bucket{limiter: rate.NewLimiter(rate.Inf, chunkSize)}
+	b.set(bytesPerSecond)
+	return b
+}
+
+func (b *bucket) set(bytesPerSecond int64) {
+	b.bps.Store(bytesPerSecond)
+	if bytesPerSecond <= 0 {
+		b.limiter.SetLimit(rate.Inf)
+		return
+	}
+	b.limiter.SetBurst(int(max(bytesPerSecond, chunkSize)))
+	b.limiter.SetLimit(rate.Limit(bytesPerSecond))
+}
+
+func (b *bucket) ensure(bytesPerSecond int64) {
+	if b.bps.Load() != bytesPerSecond {
+		b.set(bytesPerSecond)
+	}
+}
+
+func (b *bucket) wait(ctx context.Context, n int) error {
+	if b.bps.Load() <= 0 {
+		return nil
+	}
+	return b.limiter.WaitN(ctx, n)
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"reflect"
 	"strconv"
+	"time"
 
 	"github.com/gotd/td/tg"
 )
@@ -15,6 +16,38 @@ type File struct {
 	FileName string
 	MimeType string
 	ID       int64
+	Thumbs   []Thumb // smallest first
+
+	// from the video and audio attributes of the document, zero if it has
+	// none
+	Duration          time.Duration
+	Width             int
+	Height            int
+	Title             string
+	Performer         string
+	SupportsStreaming bool
+}
+
+// Info returns the metadata of the file as served by the API.
+func (f *File) Info() FileInfo {
+	return FileInfo{
+		FileName:          f.FileName,
+		FileSize:          f.FileSize,
+		MimeType:          f.MimeType,
+		Duration:          f.Duration.Seconds(),
+		Width:             f.Width,
+		Height:            f.Height,
+		Title:             f.Title,
+		Performer:         f.Performer,
+		SupportsStreaming: f.SupportsStreaming,
+	}
+}
+
+// Thumb is a thumbnail Telegram generated for a file
+type Thumb struct {
+	Type   string
+	Width  int
+	Height int
 }
 
 type HashableFileStruct struct {
@@ -1,8 +1,87 @@
 package types
 
+import "time"
+
 type RootResponse struct {
 	Message string `json:"message"`
 	Ok      bool   `json:"ok"`
 	Uptime  string `json:"uptime"`
 	Version string `json:"version"`
 }
+
+type WorkerInfo struct {
+	ID          int        `json:"id"`
+	Username    string     `json:"username"`
+	Status      string     `json:"status"`
+	InFlight    int64      `json:"in_flight"`
+	ErrorRate   float64    `json:"error_rate"`
+	LatencyMs   int64      `json:"latency_ms"`
+	AvailableAt *time.Time `json:"available_at,omitempty"`
+}
+
+type WorkersResponse struct {
+	Ok      bool         `json:"ok"`
+	Workers []WorkerInfo `json:"workers"`
+	Warning string       `json:"warning,omitempty"`
+}
+
+type ErrorResponse struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+type RevokeResponse struct {
+	Ok        bool `json:"ok"`
+	MessageID int  `json:"message_id"`
+}
+
+type SubtitleTrack struct {
+	Track    uint64 `json:"track"`
+	Codec    string `json:"codec"`
+	Language string `json:"language"`
+	Name     string `json:"name,omitempty"`
+	Default  bool   `json:"default"`
+	Forced   bool   `json:"forced"`
+	URL      string `json:"url,omitempty"`
+}
+
+type SubtitlesResponse struct {
+	Ok     bool            `json:"ok"`
+	Tracks []SubtitleTrack `json:"tracks"`
+}
+
+type FileInfo struct {
+	FileName          string  `json:"file_name"`
+	FileSize          int64   `json:"file_size"`
+	MimeType          string  `json:"mime_type"`
+	Duration          float64 `json:"duration,omitempty"` // seconds
+	Width             int     `json:"width,omitempty"`
+	Height            int     `json:"height,omitempty"`
+	Title             string  `json:"title,omitempty"`
+	Performer         string  `json:"performer,omitempty"`
+	SupportsStreaming bool    `json:"supports_streaming"`
+}
+
+type InfoResponse struct {
+	Ok bool `json:"ok"`
+	FileInfo
+}
+
+type LinkResponse struct {
+	Ok          bool       `json:"ok"`
+	MessageID   int        `json:"message_id"`
+	StreamURL   string     `json:"stream_url"`
+	DownloadURL string     `json:"download_url"`
+	WatchURL    string     `json:"watch_url"`
+	Hash        string     `json:"hash"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	File        FileInfo   `json:"file"`
+}
+
+type UploadResponse struct {
+	Ok       bool   `json:"ok"`
+	UploadID string `json:"upload_id"`
+	Offset   int64  `json:"offset"`
+	Size     int64  `json:"size"`
+	Error    string `json:"error,omitempty"`
+}
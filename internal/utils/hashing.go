@@ -3,6 +3,15 @@ package utils
 import (
 	"EverythingSuckz/fsb/config"
 	"EverythingSuckz/fsb/internal/types"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
 )
 
 func PackFile(fileName string, fileSize int64, mimeType string, fileID int64) string {
@@ -16,3 +25,48 @@ func GetShortHash(fullHash string) string {
 func CheckHash(inputHash string, expectedHash string) bool {
 	return inputHash == GetShortHash(expectedHash)
 }
+
+// SignLink returns the HMAC of a link to messageID. expires is a unix time
+// (0 for links that never expire) and clientIP the only address allowed to
+// use the link ("" for any).
+func SignLink(messageID int, fileID int64, expires int64, clientIP string) string {
+	mac := hmac.New(sha256.New, []byte(config.ValueOf.LinkSecret))
+	fmt.Fprintf(mac, "%d|%d|%d|%s", messageID, fileID, expires, clientIP)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// CheckLinkSignature compares signature with the expected one in constant time.
+func CheckLinkSignature(signature string, messageID int, fileID int64, expires int64, clientIP string) bool {
+	return hmac.Equal([]byte(signature), []byte(SignLink(messageID, fileID, expires, clientIP)))
+}
+
+// LinkQuery returns the signed query string for a link to messageID that is
+// valid for ttl (0 for ever) and, if clientIP is set, only from that address.
+func LinkQuery(messageID int, fileID int64, ttl time.Duration, clientIP string) string {
+	query := url.Values{}
+	var expires int64
+	if ttl > 0 {
+		expires = time.Now().Add(ttl).Unix()
+		query.Set("exp", strconv.FormatInt(expires, 10))
+	}
+	if clientIP != "" {
+		query.Set("ip", clientIP)
+	}
+	query.Set("sig", SignLink(messageID, fileID, expires, clientIP))
+	return query.Encode()
+}
+
+// NewAPIKey returns a random API key.
+func NewAPIKey() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "fsb_" + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashAPIKey returns the hash under which key is stored.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -8,11 +8,13 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"time"
 
 	"github.com/celestix/gotgproto"
 	"github.com/celestix/gotgproto/ext"
 	"github.com/celestix/gotgproto/storage"
 	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
 	"go.uber.org/zap"
 )
 
@@ -53,20 +55,32 @@ func FileFromMedia(media tg.MessageMediaClass) (*types.File, error) {
 		if !ok {
 			return nil, fmt.Errorf("unexpected type %T", media)
 		}
-		var fileName string
-		for _, attribute := range document.Attributes {
-			if name, ok := attribute.(*tg.DocumentAttributeFilename); ok {
-				fileName = name.FileName
-				break
-			}
-		}
-		return &types.File{
+		file := &types.File{
 			Location: document.AsInputDocumentFileLocation(),
 			FileSize: document.Size,
-			FileName: fileName,
 			MimeType: document.MimeType,
 			ID:       document.ID,
-		}, nil
+			Thumbs:   thumbsFromSizes(document.Thumbs),
+		}
+		for _, attribute := range document.Attributes {
+			switch attribute := attribute.(type) {
+			case *tg.DocumentAttributeFilename:
+				file.FileName = attribute.FileName
+			case *tg.DocumentAttributeVideo:
+				file.Duration = time.Duration(attribute.Duration * float64(time.Second))
+				file.Width = attribute.W
+				file.Height = attribute.H
+				file.SupportsStreaming = attribute.SupportsStreaming
+			case *tg.DocumentAttributeAudio:
+				// music sent as video keeps the duration of its video
+				if file.Duration == 0 {
+					file.Duration = time.Duration(attribute.Duration) * time.Second
+				}
+				file.Title = attribute.Title
+				file.Performer = attribute.Performer
+			}
+		}
+		return file, nil
 	case *tg.MessageMediaPhoto:
 		photo, ok := media.Photo.AsNotEmpty()
 		if !ok {
@@ -86,19 +100,31 @@ func FileFromMedia(media tg.MessageMediaClass) (*types.File, error) {
 		location.AccessHash = photo.GetAccessHash()
 		location.FileReference = photo.GetFileReference()
 		location.ThumbSize = size.GetType()
-		return &types.File{
+		file := &types.File{
 			Location: location,
 			FileSize: 0, // caller should judge if this is a photo or not
 			FileName: fmt.Sprintf("photo_%d.jpg", photo.GetID()),
 			MimeType: "image/jpeg",
 			ID:       photo.GetID(),
-		}, nil
+			Thumbs:   thumbsFromSizes(photo.Sizes),
+		}
+		if len(file.Thumbs) > 0 {
+			largest := file.Thumbs[len(file.Thumbs)-1]
+			file.Width, file.Height = largest.Width, largest.Height
+		}
+		return file, nil
 	}
 	return nil, fmt.Errorf("unexpected type %T", media)
 }
 
+// FileCacheKey is the cache key under which client's view of the log channel
+// message messageID is stored.
+func FileCacheKey(messageID int, clientID int64) string {
+	return fmt.Sprintf("file:%d:%d", messageID, clientID)
+}
+
 func FileFromMessage(ctx context.Context, client *gotgproto.Client, messageID int) (*types.File, error) {
-	key := fmt.Sprintf("file:%d:%d", messageID, client.Self.ID)
+	key := FileCacheKey(messageID, client.Self.ID)
 	log := Logger.Named("GetMessageMedia")
 	var cachedMedia types.File
 	err := cache.GetCache().Get(key, &cachedMedia)
@@ -159,6 +185,35 @@ func GetLogChannelPeer(ctx context.Context, api *tg.Client, peerStorage *storage
 	return channel.AsInput(), nil
 }
 
+// FileFromUpdates returns the ID and file of the message that the updates of
+// a forward to or a post in the log channel announce.
+func FileFromUpdates(updates tg.UpdatesClass) (int, *types.File, error) {
+	u, ok := updates.(*tg.Updates)
+	if !ok {
+		return 0, nil, fmt.Errorf("unexpected type %T", updates)
+	}
+	var messageID int
+	var media tg.MessageMediaClass
+	for _, update := range u.Updates {
+		switch update := update.(type) {
+		case *tg.UpdateMessageID:
+			messageID = update.ID
+		case *tg.UpdateNewChannelMessage:
+			if message, ok := update.Message.(*tg.Message); ok {
+				media = message.Media
+			}
+		}
+	}
+	if messageID == 0 || media == nil {
+		return 0, nil, errors.New("the message has no media")
+	}
+	file, err := FileFromMedia(media)
+	if err != nil {
+		return 0, nil, err
+	}
+	return messageID, file, nil
+}
+
 func ForwardMessages(ctx *ext.Context, fromChatId, toChatId int64, messageID int) (*tg.Updates, error) {
 	fromPeer := ctx.PeerStorage.GetInputPeerById(fromChatId)
 	if fromPeer.Zero() {
@@ -179,3 +234,97 @@ func ForwardMessages(ctx *ext.Context, fromChatId, toChatId int64, messageID int
 	}
 	return update.(*tg.Updates), nil
 }
+
+// GetChatMessage returns message messageID of chatID, a chat the bot knows.
+func GetChatMessage(ctx context.Context, api *tg.Client, peerStorage *storage.PeerStorage, chatID int64, messageID int) (*tg.Message, error) {
+	ids := []tg.InputMessageClass{&tg.InputMessageID{ID: messageID}}
+	var res tg.MessagesMessagesClass
+	var err error
+	switch peer := peerStorage.GetInputPeerById(chatID).(type) {
+	case *tg.InputPeerChannel:
+		res, err = api.ChannelsGetMessages(ctx, &tg.ChannelsGetMessagesRequest{
+			Channel: &tg.InputChannel{ChannelID: peer.ChannelID, AccessHash: peer.AccessHash},
+			ID:      ids,
+		})
+	case *tg.InputPeerUser, *tg.InputPeerChat:
+		// private chats and basic groups share the message IDs of the bot
+		res, err = api.MessagesGetMessages(ctx, ids)
+	default:
+		return nil, fmt.Errorf("chatId: %d is not a valid peer", chatID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	modified, ok := res.AsModified()
+	if !ok || len(modified.GetMessages()) == 0 {
+		return nil, errors.New("message not found")
+	}
+	message, ok := modified.GetMessages()[0].(*tg.Message)
+	if !ok || peerID(message.PeerID) != chatID {
+		return nil, errors.New("message not found")
+	}
+	return message, nil
+}
+
+// IsChatMember tells whether userID is in chatID, a chat the bot knows. The
+// private chat of a user with the bot only has that user.
+func IsChatMember(ctx context.Context, api *tg.Client, peerStorage *storage.PeerStorage, chatID, userID int64) (bool, error) {
+	switch peer := peerStorage.GetInputPeerById(chatID).(type) {
+	case *tg.InputPeerUser:
+		return peer.UserID == userID, nil
+	case *tg.InputPeerChat:
+		full, err := api.MessagesGetFullChat(ctx, peer.ChatID)
+		if err != nil {
+			return false, err
+		}
+		chat, ok := full.FullChat.(*tg.ChatFull)
+		if !ok {
+			return false, fmt.Errorf("unexpected type %T", full.FullChat)
+		}
+		participants, ok := chat.Participants.(*tg.ChatParticipants)
+		if !ok {
+			return false, errors.New("the members of the chat are hidden")
+		}
+		for _, participant := range participants.Participants {
+			if participant.GetUserID() == userID {
+				return true, nil
+			}
+		}
+		return false, nil
+	case *tg.InputPeerChannel:
+		user, ok := peerStorage.GetInputPeerById(userID).(*tg.InputPeerUser)
+		if !ok {
+			// the bot has never seen the user, so it can't look them up
+			return false, nil
+		}
+		res, err := api.ChannelsGetParticipant(ctx, &tg.ChannelsGetParticipantRequest{
+			Channel:     &tg.InputChannel{ChannelID: peer.ChannelID, AccessHash: peer.AccessHash},
+			Participant: user,
+		})
+		if tgerr.Is(err, "USER_NOT_PARTICIPANT") {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		switch res.Participant.(type) {
+		case *tg.ChannelParticipantBanned, *tg.ChannelParticipantLeft:
+			return false, nil
+		}
+		return true, nil
+	default:
+		return false, fmt.Errorf("chatId: %d is not a valid peer", chatID)
+	}
+}
+
+func peerID(peer tg.PeerClass) int64 {
+	switch peer := peer.(type) {
+	case *tg.PeerUser:
+		return peer.UserID
+	case *tg.PeerChat:
+		return peer.ChatID
+	case *tg.PeerChannel:
+		return peer.ChannelID
+	}
+	return 0
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"EverythingSuckz/fsb/config"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func CheckPassword(passwordHash string, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) == nil
+}
+
+// PasswordCookie returns the value of the cookie proving that the password of
+// messageID was entered. It is bound to passwordHash, so changing the password
+// invalidates the cookies handed out for the old one.
+func PasswordCookie(messageID int, passwordHash string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + "." + passwordCookieSignature(messageID, passwordHash, exp)
+}
+
+func CheckPasswordCookie(value string, messageID int, passwordHash string) bool {
+	exp, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(passwordCookieSignature(messageID, passwordHash, exp)))
+}
+
+func passwordCookieSignature(messageID int, passwordHash string, exp string) string {
+	mac := hmac.New(sha256.New, []byte(config.ValueOf.LinkSecret))
+	fmt.Fprintf(mac, "password|%d|%s|%s", messageID, exp, passwordHash)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
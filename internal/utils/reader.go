@@ -1,23 +1,34 @@
 package utils
 
 import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/cache"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 
 	"github.com/celestix/gotgproto"
 	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
 	"go.uber.org/zap"
 )
 
+type chunkResult struct {
+	data []byte
+	err  error
+}
+
 type telegramReader struct {
 	ctx           context.Context
+	cancel        context.CancelFunc
 	log           *zap.Logger
-	client        *gotgproto.Client
-	location      tg.InputFileLocationClass
+	clients       []*gotgproto.Client
+	locate        func(client *gotgproto.Client, refresh bool) (tg.InputFileLocationClass, error)
 	start         int64
 	end           int64
-	next          func() ([]byte, error)
+	pending       chan chan chunkResult
+	slots         chan struct{}
 	buffer        []byte
 	bytesread     int64
 	chunkSize     int64
@@ -25,10 +36,14 @@ type telegramReader struct {
 	contentLength int64
 }
 
-func (*telegramReader) Close() error {
+func (r *telegramReader) Close() error {
+	r.cancel()
 	return nil
 }
 
+// NewTelegramReader returns a reader for the byte range [start, end] of the
+// file at location. Up to STREAM_CONCURRENCY chunks are requested ahead of the
+// consumer and handed back in order.
 func NewTelegramReader(
 	ctx context.Context,
 	client *gotgproto.Client,
@@ -37,20 +52,80 @@ func NewTelegramReader(
 	end int64,
 	contentLength int64,
 ) (io.ReadCloser, error) {
+	locate := func(*gotgproto.Client, bool) (tg.InputFileLocationClass, error) {
+		return location, nil
+	}
+	return newTelegramReader(ctx, []*gotgproto.Client{client}, locate, start, end, contentLength), nil
+}
 
+// NewStripedTelegramReader is like NewTelegramReader but spreads the chunks of
+// the log channel message messageID over clients in round-robin order. Every
+// client resolves its own file location, and a chunk that fails on one client
+// is retried on the next one.
+func NewStripedTelegramReader(
+	ctx context.Context,
+	clients []*gotgproto.Client,
+	messageID int,
+	start int64,
+	end int64,
+	contentLength int64,
+) (io.ReadCloser, error) {
+	if len(clients) == 0 {
+		return nil, errors.New("no clients available")
+	}
+	locate := func(client *gotgproto.Client, refresh bool) (tg.InputFileLocationClass, error) {
+		if refresh {
+			cache.GetCache().Delete(FileCacheKey(messageID, client.Self.ID))
+		}
+		file, err := FileFromMessage(ctx, client, messageID)
+		if err != nil {
+			return nil, err
+		}
+		return file.Location, nil
+	}
+	return newTelegramReader(ctx, clients, locate, start, end, contentLength), nil
+}
+
+func newTelegramReader(
+	ctx context.Context,
+	clients []*gotgproto.Client,
+	locate func(*gotgproto.Client, bool) (tg.InputFileLocationClass, error),
+	start int64,
+	end int64,
+	contentLength int64,
+) *telegramReader {
+	concurrency := config.ValueOf.StreamConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
 	r := &telegramReader{
 		ctx:           ctx,
+		cancel:        cancel,
 		log:           Logger.Named("telegramReader"),
-		location:      location,
-		client:        client,
+		clients:       clients,
+		locate:        locate,
 		start:         start,
 		end:           end,
-		chunkSize:     int64(1024 * 1024),
+		pending:       make(chan chan chunkResult, concurrency),
+		slots:         make(chan struct{}, concurrency),
+		chunkSize:     chunkSizeFor(contentLength),
 		contentLength: contentLength,
 	}
 	r.log.Sugar().Debug("Start")
-	r.next = r.partStream()
-	return r, nil
+	go r.prefetch()
+	return r
+}
+
+// chunkSizeFor returns the part size to fetch contentLength bytes with. Small
+// ranges use smaller parts: Telegram accepts any power of two from 4KB to 1MB
+// as long as parts are aligned to their size.
+func chunkSizeFor(contentLength int64) int64 {
+	chunkSize := int64(4 * 1024)
+	for chunkSize < 1024*1024 && chunkSize < contentLength {
+		chunkSize *= 2
+	}
+	return chunkSize
 }
 
 func (r *telegramReader) Read(p []byte) (n int, err error) {
@@ -64,16 +139,9 @@ func (r *telegramReader) Read(p []byte) (n int, err error) {
 		r.buffer, err = r.next()
 		r.log.Debug("Next Buffer", zap.Int64("len", int64(len(r.buffer))))
 		if err != nil {
+			r.cancel()
 			return 0, err
 		}
-		if len(r.buffer) == 0 {
-			r.next = r.partStream()
-			r.buffer, err = r.next()
-			if err != nil {
-				return 0, err
-			}
-
-		}
 		r.i = 0
 	}
 	n = copy(p, r.buffer[r.i:])
@@ -82,15 +150,72 @@ func (r *telegramReader) Read(p []byte) (n int, err error) {
 	return n, nil
 }
 
-func (r *telegramReader) chunk(offset int64, limit int64) ([]byte, error) {
+// next waits for the oldest in-flight chunk and frees its slot so that the
+// prefetcher can request another one.
+func (r *telegramReader) next() ([]byte, error) {
+	var result chan chunkResult
+	select {
+	case <-r.ctx.Done():
+		return nil, r.ctx.Err()
+	case ch, ok := <-r.pending:
+		if !ok {
+			return nil, io.ErrUnexpectedEOF
+		}
+		result = ch
+	}
+	select {
+	case <-r.ctx.Done():
+		return nil, r.ctx.Err()
+	case res := <-result:
+		<-r.slots
+		if res.err != nil {
+			return nil, res.err
+		}
+		if len(res.data) == 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return res.data, nil
+	}
+}
+
+// chunk fetches one part, starting with the client assigned to it and falling
+// back to the remaining clients when a request fails.
+func (r *telegramReader) chunk(part int, offset int64, limit int64) ([]byte, error) {
+	var lastErr error
+	for attempt := 0; attempt < len(r.clients); attempt++ {
+		client := r.clients[(part+attempt)%len(r.clients)]
+		res, err := r.chunkFrom(client, offset, limit)
+		if err == nil {
+			return res, nil
+		}
+		if r.ctx.Err() != nil {
+			return nil, r.ctx.Err()
+		}
+		r.log.Debug("Chunk request failed", zap.Int64("clientID", client.Self.ID), zap.Int64("offset", offset), zap.Error(err))
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (r *telegramReader) chunkFrom(client *gotgproto.Client, offset int64, limit int64) ([]byte, error) {
+	location, err := r.locate(client, false)
+	if err != nil {
+		return nil, err
+	}
 
 	req := &tg.UploadGetFileRequest{
 		Offset:   offset,
 		Limit:    int(limit),
-		Location: r.location,
+		Location: location,
 	}
 
-	res, err := r.client.API().UploadGetFile(r.ctx, req)
+	res, err := client.API().UploadGetFile(r.ctx, req)
+	if tgerr.Is(err, "FILE_REFERENCE_EXPIRED") {
+		if req.Location, err = r.locate(client, true); err != nil {
+			return nil, err
+		}
+		res, err = client.API().UploadGetFile(r.ctx, req)
+	}
 
 	if err != nil {
 		return nil, err
@@ -104,7 +229,10 @@ func (r *telegramReader) chunk(offset int64, limit int64) ([]byte, error) {
 	}
 }
 
-func (r *telegramReader) partStream() func() ([]byte, error) {
+// prefetch issues chunk requests in order, keeping at most cap(r.slots) of
+// them outstanding, and queues their result channels on r.pending.
+func (r *telegramReader) prefetch() {
+	defer close(r.pending)
 
 	start := r.start
 	end := r.end
@@ -113,30 +241,36 @@ func (r *telegramReader) partStream() func() ([]byte, error) {
 	firstPartCut := start - offset
 	lastPartCut := (end % r.chunkSize) + 1
 	partCount := int((end - offset + r.chunkSize) / r.chunkSize)
-	currentPart := 1
 
-	readData := func() ([]byte, error) {
-		if currentPart > partCount {
-			return make([]byte, 0), nil
+	for currentPart := 1; currentPart <= partCount; currentPart++ {
+		select {
+		case <-r.ctx.Done():
+			return
+		case r.slots <- struct{}{}:
 		}
-		res, err := r.chunk(offset, r.chunkSize)
-		if err != nil {
-			return nil, err
-		}
-		if len(res) == 0 {
-			return res, nil
-		} else if partCount == 1 {
-			res = res[firstPartCut:lastPartCut]
-		} else if currentPart == 1 {
-			res = res[firstPartCut:]
-		} else if currentPart == partCount {
-			res = res[:lastPartCut]
-		}
-
-		currentPart++
+		result := make(chan chunkResult, 1)
+		go func(part int, offset int64) {
+			res, err := r.chunk(part-1, offset, r.chunkSize)
+			if err == nil {
+				// every part but the last is full, and the last one
+				// holds at least up to end
+				want := r.chunkSize
+				if part == partCount {
+					want = lastPartCut
+				}
+				if int64(len(res)) < want {
+					res, err = nil, fmt.Errorf("short read of part %d: got %d bytes, want %d: %w", part, len(res), want, io.ErrUnexpectedEOF)
+				} else {
+					res = res[:want]
+					if part == 1 {
+						res = res[firstPartCut:]
+					}
+				}
+			}
+			r.log.Sugar().Debugf("Part %d/%d", part, partCount)
+			result <- chunkResult{data: res, err: err}
+		}(currentPart, offset)
+		r.pending <- result
 		offset += r.chunkSize
-		r.log.Sugar().Debugf("Part %d/%d", currentPart, partCount)
-		return res, nil
 	}
-	return readData
 }
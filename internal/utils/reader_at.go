@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/celestix/gotgproto"
+	"github.com/gotd/td/tg"
+)
+
+const (
+	readerAtBlockSize = 1024 * 1024
+	readerAtBlocks    = 8
+)
+
+// telegramReaderAt reads a file in blocks through NewTelegramReader and keeps
+// the last few of them, as container parsers make many small reads close to
+// each other.
+type telegramReaderAt struct {
+	ctx      context.Context
+	client   *gotgproto.Client
+	location tg.InputFileLocationClass
+	size     int64
+	fetch    func(n int64) error
+
+	mu     sync.Mutex
+	blocks map[int64][]byte
+	order  []int64
+}
+
+// NewTelegramReaderAt returns an io.ReaderAt for the file of the given size
+// at location.
+func NewTelegramReaderAt(ctx context.Context, client *gotgproto.Client, location tg.InputFileLocationClass, size int64) io.ReaderAt {
+	return NewMeteredTelegramReaderAt(ctx, client, location, size, nil)
+}
+
+// NewMeteredTelegramReaderAt is NewTelegramReaderAt, but calls fetch with the
+// length of every block before it is fetched, and fails the read if fetch
+// returns an error.
+func NewMeteredTelegramReaderAt(ctx context.Context, client *gotgproto.Client, location tg.InputFileLocationClass, size int64, fetch func(n int64) error) io.ReaderAt {
+	return &telegramReaderAt{
+		ctx:      ctx,
+		client:   client,
+		location: location,
+		size:     size,
+		fetch:    fetch,
+		blocks:   make(map[int64][]byte),
+	}
+}
+
+func (r *telegramReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off >= r.size {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(p) && off+int64(n) < r.size {
+		pos := off + int64(n)
+		block, err := r.block(pos / readerAtBlockSize)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], block[pos%readerAtBlockSize:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *telegramReaderAt) block(i int64) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if block, ok := r.blocks[i]; ok {
+		return block, nil
+	}
+	start := i * readerAtBlockSize
+	end := min(start+readerAtBlockSize, r.size) - 1
+	if r.fetch != nil {
+		if err := r.fetch(end - start + 1); err != nil {
+			return nil, err
+		}
+	}
+	lr, err := NewTelegramReader(r.ctx, r.client, r.location, start, end, end-start+1)
+	if err != nil {
+		return nil, err
+	}
+	defer lr.Close()
+	block := make([]byte, end-start+1)
+	if _, err := io.ReadFull(lr, block); err != nil {
+		return nil, err
+	}
+	if len(r.order) == readerAtBlocks {
+		delete(r.blocks, r.order[0])
+		r.order = r.order[1:]
+	}
+	r.blocks[i] = block
+	r.order = append(r.order, i)
+	return block, nil
+}
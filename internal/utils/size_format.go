@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func SizeFormat(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// ParseSize parses sizes like "700", "500MB" or "1.5 GiB". Units are binary,
+// so 1KB and 1KiB are both 1024 bytes.
+func ParseSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.ReplaceAll(value, " ", ""))
+	number := strings.TrimRight(value, "KMGTPIB")
+	unit := strings.TrimSuffix(strings.TrimSuffix(value[len(number):], "B"), "I")
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	multiplier := int64(1)
+	if unit != "" {
+		exp := strings.Index("KMGTP", unit)
+		if exp == -1 || len(unit) != 1 {
+			return 0, fmt.Errorf("invalid size %q", value)
+		}
+		for i := 0; i <= exp; i++ {
+			multiplier *= 1024
+		}
+	}
+	return int64(n * float64(multiplier)), nil
+}
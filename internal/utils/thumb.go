@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/types"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/celestix/gotgproto"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// ErrNoThumb is returned for files Telegram has no thumbnail for
+var ErrNoThumb = errors.New("this file has no thumbnail")
+
+// thumbsFromSizes returns the downloadable thumbnails among sizes, smallest
+// first. Stripped and cached sizes are inlined in the message and skipped.
+func thumbsFromSizes(sizes []tg.PhotoSizeClass) []types.Thumb {
+	var thumbs []types.Thumb
+	for _, size := range sizes {
+		switch size := size.(type) {
+		case *tg.PhotoSize:
+			thumbs = append(thumbs, types.Thumb{Type: size.Type, Width: size.W, Height: size.H})
+		case *tg.PhotoSizeProgressive:
+			thumbs = append(thumbs, types.Thumb{Type: size.Type, Width: size.W, Height: size.H})
+		}
+	}
+	sort.SliceStable(thumbs, func(i, j int) bool {
+		return thumbs[i].Width*thumbs[i].Height < thumbs[j].Width*thumbs[j].Height
+	})
+	return thumbs
+}
+
+// thumbLocation returns the location of the thumbnail thumbType of a file.
+func thumbLocation(location tg.InputFileLocationClass, thumbType string) (tg.InputFileLocationClass, error) {
+	switch location := location.(type) {
+	case *tg.InputDocumentFileLocation:
+		thumb := *location
+		thumb.ThumbSize = thumbType
+		return &thumb, nil
+	case *tg.InputPhotoFileLocation:
+		thumb := *location
+		thumb.ThumbSize = thumbType
+		return &thumb, nil
+	}
+	return nil, fmt.Errorf("unexpected location type %T", location)
+}
+
+// DownloadThumb downloads the thumbnail thumbType of the file in the log
+// channel message messageID.
+func DownloadThumb(ctx context.Context, client *gotgproto.Client, messageID int, thumbType string) ([]byte, error) {
+	file, err := FileFromMessage(ctx, client, messageID)
+	if err != nil {
+		return nil, err
+	}
+	location, err := thumbLocation(file.Location, thumbType)
+	if err != nil {
+		return nil, err
+	}
+	req := &tg.UploadGetFileRequest{
+		Location: location,
+		Offset:   0,
+		Limit:    1024 * 1024,
+	}
+	res, err := client.API().UploadGetFile(ctx, req)
+	if tgerr.Is(err, "FILE_REFERENCE_EXPIRED") {
+		cache.GetCache().Delete(FileCacheKey(messageID, client.Self.ID))
+		if file, err = FileFromMessage(ctx, client, messageID); err != nil {
+			return nil, err
+		}
+		if req.Location, err = thumbLocation(file.Location, thumbType); err != nil {
+			return nil, err
+		}
+		res, err = client.API().UploadGetFile(ctx, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result, ok := res.(*tg.UploadFile)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type %T", res)
+	}
+	return result.Bytes, nil
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"fmt"
 	"math/bits"
+	"time"
 )
 
 func TimeFormat(seconds uint64) (timeStr string) {
@@ -40,3 +41,12 @@ func TimeFormat(seconds uint64) (timeStr string) {
 	}
 	return timeStr
 }
+
+// DurationFormat formats d like a media player does, as 4:05 or 1:02:03.
+func DurationFormat(d time.Duration) string {
+	s := int64(d.Round(time.Second).Seconds())
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
@@ -7,12 +7,18 @@ package qrlogin
 
 import (
 	"bytes"
+	"crypto/sha1"
 	"encoding/base64"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
+	"strconv"
 	"strings"
 
 	"github.com/gotd/td/session"
+	"github.com/gotd/td/telegram/dcs"
 )
 
 func EncodeToPyrogramSession(data *session.Data, appID int32) (string, error) {
@@ -50,3 +56,142 @@ func EncodeToPyrogramSession(data *session.Data, appID int32) (string, error) {
 	trimmedEncoded := strings.TrimRight(encodedString, "=")
 	return trimmedEncoded, nil
 }
+
+// DecodePyrogramSession is the inverse of EncodeToPyrogramSession.
+func DecodePyrogramSession(value string) (*session.Data, error) {
+	data, err := base64.URLEncoding.DecodeString(padBase64(value))
+	if err != nil {
+		return nil, err
+	}
+	// dc id, app id, test mode, auth key, user id, is bot
+	if len(data) != 1+4+1+256+8+1 {
+		return nil, fmt.Errorf("unexpected pyrogram session length %d", len(data))
+	}
+	authKey := data[6:262]
+	return &session.Data{
+		DC:        int(data[0]),
+		AuthKey:   authKey,
+		AuthKeyID: authKeyID(authKey),
+		Config: session.Config{
+			TestMode: data[5] == 1,
+		},
+	}, nil
+}
+
+// EncodeToTelethonSession encodes data as a Telethon StringSession. Telethon
+// stores the address of the DC, which is looked up from the production DC
+// list when the session doesn't carry one.
+func EncodeToTelethonSession(data *session.Data) (string, error) {
+	if len(data.AuthKey) != 256 {
+		return "", errors.New("auth key must be 256 bytes long")
+	}
+	addr := data.Addr
+	if addr == "" {
+		for _, option := range dcs.Prod().Options {
+			if option.ID == data.DC && !option.Ipv6 && !option.MediaOnly && !option.CDN {
+				addr = net.JoinHostPort(option.IPAddress, strconv.Itoa(option.Port))
+				break
+			}
+		}
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid DC address %q: %w", addr, err)
+	}
+	portNumber, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid DC port %q: %w", port, err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", fmt.Errorf("invalid DC IP %q", host)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	buf := new(bytes.Buffer)
+	buf.WriteByte(byte(data.DC))
+	buf.Write(ip)
+	binary.Write(buf, binary.BigEndian, uint16(portNumber))
+	buf.Write(data.AuthKey)
+	return "1" + base64.URLEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// DecodeTelethonSession decodes a Telethon StringSession.
+func DecodeTelethonSession(value string) (*session.Data, error) {
+	return session.TelethonSession(value)
+}
+
+// gotdSession is the layout gotd's session storages persist.
+type gotdSession struct {
+	Version int
+	Data    session.Data
+}
+
+// EncodeToGotdSession encodes data as the JSON document stored by gotd's
+// session storages.
+func EncodeToGotdSession(data *session.Data) (string, error) {
+	encoded, err := json.Marshal(gotdSession{Version: 1, Data: *data})
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// DecodeGotdSession decodes the JSON document stored by gotd's session storages.
+func DecodeGotdSession(value string) (*session.Data, error) {
+	var decoded gotdSession
+	if err := json.Unmarshal([]byte(value), &decoded); err != nil {
+		return nil, err
+	}
+	if len(decoded.Data.AuthKey) != 256 {
+		return nil, errors.New("auth key must be 256 bytes long")
+	}
+	return &decoded.Data, nil
+}
+
+const (
+	FormatPyrogram = "pyrogram"
+	FormatTelethon = "telethon"
+	FormatGotd     = "gotd"
+)
+
+// EncodeSession encodes data in the given format.
+func EncodeSession(data *session.Data, format string, appID int32) (string, error) {
+	switch format {
+	case FormatPyrogram:
+		return EncodeToPyrogramSession(data, appID)
+	case FormatTelethon:
+		return EncodeToTelethonSession(data)
+	case FormatGotd:
+		return EncodeToGotdSession(data)
+	}
+	return "", fmt.Errorf("unknown session format %q", format)
+}
+
+// DetectSessionFormat guesses the format of a session string. Strings that
+// are neither gotd JSON nor a valid Telethon session are treated as Pyrogram.
+func DetectSessionFormat(value string) string {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "{") {
+		return FormatGotd
+	}
+	if strings.HasPrefix(value, "1") {
+		if _, err := DecodeTelethonSession(value); err == nil {
+			return FormatTelethon
+		}
+	}
+	return FormatPyrogram
+}
+
+func padBase64(value string) string {
+	if n := len(value) % 4; n != 0 {
+		value += strings.Repeat("=", 4-n)
+	}
+	return value
+}
+
+func authKeyID(authKey []byte) []byte {
+	hash := sha1.Sum(authKey)
+	return hash[12:20]
+}
@@ -0,0 +1,146 @@
+// This file is a part of EverythingSuckz/TG-FileStreamBot
+// And is licenced under the Affero General Public License.
+// Any distributions of this code MUST be accompanied by a copy of the AGPL
+// with proper attribution to the original author(s).
+
+package qrlogin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/gotd/td/session"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/auth"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// maxLoginAttempts is how many times each prompt is repeated after a wrong answer.
+const maxLoginAttempts = 3
+
+// GeneratePhoneSession logs in interactively with a phone number, the login
+// code Telegram sends and the 2FA password if one is set.
+func GeneratePhoneSession(apiId int, apiHash string, format string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fmt.Println("Generating phone session...")
+	reader := bufio.NewReader(os.Stdin)
+	sessionStorage := &session.StorageMemory{}
+	client := telegram.NewClient(apiId, apiHash, telegram.Options{
+		SessionStorage: sessionStorage,
+		Device: telegram.DeviceConfig{
+			DeviceModel:   "Pyrogram",
+			SystemVersion: runtime.GOOS,
+			AppVersion:    "2.0",
+		},
+	})
+	return client.Run(ctx, func(ctx context.Context) error {
+		phone, sentCode, err := sendLoginCode(ctx, client.Auth(), reader)
+		if err != nil {
+			return err
+		}
+		if err := signInWithCode(ctx, client, reader, phone, sentCode); err != nil {
+			return err
+		}
+		return sendSessionString(ctx, client, sessionStorage, apiId, format)
+	})
+}
+
+func prompt(reader *bufio.Reader, text string) (string, error) {
+	fmt.Print(text)
+	input, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(input), nil
+}
+
+func sendLoginCode(ctx context.Context, client *auth.Client, reader *bufio.Reader) (string, *tg.AuthSentCode, error) {
+	for attempt := 1; attempt <= maxLoginAttempts; attempt++ {
+		phone, err := prompt(reader, "Enter your phone number with the country code (e.g. +12025550123): ")
+		if err != nil {
+			return "", nil, err
+		}
+		res, err := client.SendCode(ctx, phone, auth.SendCodeOptions{})
+		if tgerr.Is(err, "PHONE_NUMBER_INVALID") {
+			fmt.Println("Invalid phone number, please try again.")
+			continue
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		sentCode, err := asSentCode(res)
+		if err != nil {
+			return "", nil, err
+		}
+		return phone, sentCode, nil
+	}
+	return "", nil, errors.New("too many invalid phone numbers")
+}
+
+func signInWithCode(ctx context.Context, client *telegram.Client, reader *bufio.Reader, phone string, sentCode *tg.AuthSentCode) error {
+	for attempt := 1; attempt <= maxLoginAttempts; attempt++ {
+		code, err := prompt(reader, "Enter the login code you received: ")
+		if err != nil {
+			return err
+		}
+		_, err = client.Auth().SignIn(ctx, phone, code, sentCode.PhoneCodeHash)
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, auth.ErrPasswordAuthNeeded):
+			return signInWithPassword(ctx, client.Auth(), reader)
+		case tgerr.Is(err, "PHONE_CODE_INVALID", "PHONE_CODE_EMPTY"):
+			fmt.Println("Invalid code, please try again.")
+		case tgerr.Is(err, "PHONE_CODE_EXPIRED"):
+			fmt.Println("The code has expired, sending a new one.")
+			res, err := client.API().AuthResendCode(ctx, &tg.AuthResendCodeRequest{
+				PhoneNumber:   phone,
+				PhoneCodeHash: sentCode.PhoneCodeHash,
+			})
+			if err != nil {
+				return err
+			}
+			if sentCode, err = asSentCode(res); err != nil {
+				return err
+			}
+		default:
+			var signUpRequired *auth.SignUpRequired
+			if errors.As(err, &signUpRequired) {
+				return errors.New("this phone number is not registered on Telegram")
+			}
+			return err
+		}
+	}
+	return errors.New("too many invalid codes")
+}
+
+func signInWithPassword(ctx context.Context, client *auth.Client, reader *bufio.Reader) error {
+	for attempt := 1; attempt <= maxLoginAttempts; attempt++ {
+		password, err := prompt(reader, "2FA password is required, enter it below: ")
+		if err != nil {
+			return err
+		}
+		_, err = client.Password(ctx, password)
+		if errors.Is(err, auth.ErrPasswordInvalid) {
+			fmt.Println("Invalid password, please try again.")
+			continue
+		}
+		return err
+	}
+	return errors.New("too many invalid passwords")
+}
+
+func asSentCode(res tg.AuthSentCodeClass) (*tg.AuthSentCode, error) {
+	sentCode, ok := res.(*tg.AuthSentCode)
+	if !ok {
+		return nil, fmt.Errorf("unexpected response %T", res)
+	}
+	return sentCode, nil
+}
@@ -8,7 +8,6 @@ package qrlogin
 import (
 	"bufio"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -48,7 +47,7 @@ func clearQrCode(writer *CustomWriter) {
 	writer.LineLength = 0
 }
 
-func GenerateQRSession(apiId int, apiHash string) error {
+func GenerateQRSession(apiId int, apiHash string, format string) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	fmt.Println("Generating QR session...")
@@ -65,7 +64,6 @@ func GenerateQRSession(apiId int, apiHash string) error {
 			AppVersion:    "2.0",
 		},
 	})
-	var stringSession string
 	qrWriter := &CustomWriter{}
 	tickerCtx, cancelTicker := context.WithCancel(context.Background())
 	err := client.Run(ctx, func(ctx context.Context) error {
@@ -114,36 +112,7 @@ func GenerateQRSession(apiId int, apiHash string) error {
 			cancel()
 			return errors.New("authorization is nil")
 		}
-		user, err := client.Self(ctx)
-		if err != nil {
-			return err
-		}
-		if user.Username == "" {
-			fmt.Println("Logged in as ", user.FirstName, user.LastName)
-		} else {
-			fmt.Println("Logged in as @", user.Username)
-		}
-		res, _ := sessionStorage.LoadSession(ctx)
-		type jsonDataStruct struct {
-			Version int
-			Data    session.Data
-		}
-		var jsonData jsonDataStruct
-		json.Unmarshal(res, &jsonData)
-		stringSession, err = EncodeToPyrogramSession(&jsonData.Data, int32(apiId))
-		if err != nil {
-			return err
-		}
-		fmt.Println("Your pyrogram session string:", stringSession)
-		client.API().MessagesSendMessage(
-			ctx,
-			&tg.MessagesSendMessageRequest{
-				NoWebpage: true,
-				Peer:      &tg.InputPeerSelf{},
-				Message:   "Your pyrogram session string: " + stringSession,
-			},
-		)
-		return nil
+		return sendSessionString(ctx, client, sessionStorage, apiId, format)
 	})
 	if err != nil {
 		return err
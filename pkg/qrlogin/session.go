@@ -0,0 +1,51 @@
+// This file is a part of EverythingSuckz/TG-FileStreamBot
+// And is licenced under the Affero General Public License.
+// Any distributions of this code MUST be accompanied by a copy of the AGPL
+// with proper attribution to the original author(s).
+
+package qrlogin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gotd/td/session"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+)
+
+// sendSessionString encodes the session of a freshly logged in client in the
+// given format, prints it and sends it to the user's saved messages.
+func sendSessionString(ctx context.Context, client *telegram.Client, sessionStorage *session.StorageMemory, apiId int, format string) error {
+	user, err := client.Self(ctx)
+	if err != nil {
+		return err
+	}
+	if user.Username == "" {
+		fmt.Println("Logged in as ", user.FirstName, user.LastName)
+	} else {
+		fmt.Println("Logged in as @", user.Username)
+	}
+	res, _ := sessionStorage.LoadSession(ctx)
+	type jsonDataStruct struct {
+		Version int
+		Data    session.Data
+	}
+	var jsonData jsonDataStruct
+	json.Unmarshal(res, &jsonData)
+	stringSession, err := EncodeSession(&jsonData.Data, format, int32(apiId))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Your %s session string: %s\n", format, stringSession)
+	client.API().MessagesSendMessage(
+		ctx,
+		&tg.MessagesSendMessageRequest{
+			NoWebpage: true,
+			Peer:      &tg.InputPeerSelf{},
+			Message:   fmt.Sprintf("Your %s session string: %s", format, stringSession),
+		},
+	)
+	return nil
+}